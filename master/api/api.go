@@ -0,0 +1,124 @@
+package api
+
+// Framework of the /api/v2 management API. Endpoints describe their method,
+// path, permission and request and response types, the OpenAPI document is
+// generated from the same descriptions. Every error is answered with the
+// envelope {"error": {"code": ..., "message": ..., "fields": ..., "details": ...}}.
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"wired.rip/wiredutils/rbac"
+)
+
+const Prefix = "/api/v2"
+
+type Endpoint struct {
+	Method     string
+	Path       string // below Prefix, e.g. /routes/{id}
+	Summary    string
+	Permission rbac.Permission // empty admits every authenticated caller
+	Public     bool            // no authentication at all
+	Action     string          // audit action, mutations are audited and run on the leader
+	LeaderOnly bool            // reads that only the leader can answer
+	Status     int             // success status, defaults to 200
+	Request    any             // zero value of the JSON body, nil without body
+	Response   any             // zero value of the response, nil for 204
+	Query      []Param
+	Paginated  bool
+	Handle     func(r *http.Request) (any, error)
+}
+
+type Param struct {
+	Name        string
+	Description string
+}
+
+// Mutates reports whether the endpoint changes state
+func (e Endpoint) Mutates() bool {
+	return e.Method != http.MethodGet && e.Method != http.MethodHead
+}
+
+// Serve runs the endpoint, the caller checked authentication and permission
+func (e Endpoint) Serve(w http.ResponseWriter, r *http.Request) {
+	v, err := e.Handle(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	status := e.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if e.Response == nil || status == http.StatusNoContent {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	WriteJSON(w, status, v)
+}
+
+// Group collects the endpoints sharing a path, they are registered once
+// and dispatched by method so wrong methods get the error envelope too
+type Group struct {
+	Path      string
+	Endpoints []Endpoint
+}
+
+func Groups(endpoints []Endpoint) []Group {
+	var groups []Group
+	index := map[string]int{}
+	for _, e := range endpoints {
+		i, ok := index[e.Path]
+		if !ok {
+			i = len(groups)
+			index[e.Path] = i
+			groups = append(groups, Group{Path: e.Path})
+		}
+
+		groups[i].Endpoints = append(groups[i].Endpoints, e)
+	}
+
+	return groups
+}
+
+func (g Group) Find(method string) (Endpoint, bool) {
+	for _, e := range g.Endpoints {
+		if e.Method == method {
+			return e, true
+		}
+	}
+
+	return Endpoint{}, false
+}
+
+func (g Group) Allow() string {
+	methods := make([]string, 0, len(g.Endpoints))
+	for _, e := range g.Endpoints {
+		methods = append(methods, e.Method)
+	}
+
+	return strings.Join(methods, ", ")
+}
+
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError answers with the error envelope, errors that are not an
+// *Error are reported as internal errors
+func WriteError(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = Internal("Internal error", err)
+	}
+
+	WriteJSON(w, e.Status, map[string]*Error{"error": e})
+}
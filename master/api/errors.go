@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type Error struct {
+	Status  int               `json:"-"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`  // problems of the request body by field
+	Details map[string]string `json:"details,omitempty"` // e.g. the missing permission
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func BadRequest(message string) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: "bad_request", Message: message}
+}
+
+// Invalid reports problems with individual fields, e.g.
+// {"server_port": "must be a port number"}
+func Invalid(fields map[string]string) *Error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := make([]string, 0, len(names))
+	for _, name := range names {
+		problems = append(problems, name+" "+fields[name])
+	}
+
+	return &Error{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    "invalid",
+		Message: strings.Join(problems, ", "),
+		Fields:  fields,
+	}
+}
+
+func Unauthorized() *Error {
+	return &Error{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "Unauthorized"}
+}
+
+func Forbidden(permission string) *Error {
+	return &Error{
+		Status:  http.StatusForbidden,
+		Code:    "forbidden",
+		Message: "Forbidden",
+		Details: map[string]string{"permission": permission},
+	}
+}
+
+func NotFound(message string) *Error {
+	return &Error{Status: http.StatusNotFound, Code: "not_found", Message: message}
+}
+
+func Conflict(message string) *Error {
+	return &Error{Status: http.StatusConflict, Code: "conflict", Message: message}
+}
+
+func MethodNotAllowed() *Error {
+	return &Error{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Message: "Method not allowed"}
+}
+
+func Unavailable(message string) *Error {
+	return &Error{Status: http.StatusServiceUnavailable, Code: "unavailable", Message: message}
+}
+
+func Internal(message string, err error) *Error {
+	e := &Error{Status: http.StatusInternalServerError, Code: "internal", Message: message}
+	if err != nil {
+		e.Details = map[string]string{"error": err.Error()}
+	}
+
+	return e
+}
+
+// With adds a detail to the error
+func (e *Error) With(key, value string) *Error {
+	if e.Details == nil {
+		e.Details = map[string]string{}
+	}
+
+	e.Details[key] = value
+	return e
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// OpenAPI generates the OpenAPI 3.1 document of the endpoints
+func OpenAPI(endpoints []Endpoint) map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]any{}
+
+	errorSchema := map[string]any{
+		"type":     "object",
+		"required": []string{"error"},
+		"properties": map[string]any{
+			"error": schemaOf(reflect.TypeOf(Error{}), schemas),
+		},
+	}
+	schemas["ErrorEnvelope"] = errorSchema
+
+	for _, group := range Groups(endpoints) {
+		operations := map[string]any{}
+		for _, e := range group.Endpoints {
+			operations[strings.ToLower(e.Method)] = operation(e, schemas)
+		}
+
+		paths[Prefix+group.Path] = operations
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Wired management API",
+			"version": "2",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Access token of a session or API token (wired_...)",
+				},
+			},
+		},
+	}
+}
+
+func operation(e Endpoint, schemas map[string]any) map[string]any {
+	op := map[string]any{
+		"summary":     e.Summary,
+		"operationId": operationId(e),
+		"tags":        []string{strings.Split(strings.Trim(e.Path, "/"), "/")[0]},
+	}
+
+	if !e.Public {
+		op["security"] = []any{map[string]any{"bearer": []string{}}}
+	}
+
+	if e.Permission != "" {
+		op["x-permission"] = string(e.Permission)
+	}
+
+	var parameters []any
+	for _, segment := range strings.Split(e.Path, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			parameters = append(parameters, map[string]any{
+				"name":     strings.TrimSuffix(name, "}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+	}
+
+	query := e.Query
+	if e.Paginated {
+		query = append(query,
+			Param{Name: "limit", Description: "Items per page, 1 to " + strconv.Itoa(MaxLimit) + ", defaults to " + strconv.Itoa(DefaultLimit)},
+			Param{Name: "cursor", Description: "The next value of the previous page"},
+		)
+	}
+
+	for _, p := range query {
+		parameters = append(parameters, map[string]any{
+			"name":        p.Name,
+			"in":          "query",
+			"description": p.Description,
+			"schema":      map[string]any{"type": "string"},
+		})
+	}
+
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	if e.Request != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(e.Request), schemas)},
+			},
+		}
+	}
+
+	status := e.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	responses := map[string]any{
+		"default": map[string]any{
+			"description": "Error",
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/ErrorEnvelope"}},
+			},
+		},
+	}
+
+	if e.Response == nil {
+		responses["204"] = map[string]any{"description": "No content"}
+	} else {
+		schema := schemaOf(reflect.TypeOf(e.Response), schemas)
+		if e.Paginated {
+			schema = map[string]any{
+				"type":     "object",
+				"required": []string{"items"},
+				"properties": map[string]any{
+					"items": map[string]any{"type": "array", "items": schema},
+					"next":  map[string]any{"type": "string"},
+				},
+			}
+		}
+
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": schema},
+			},
+		}
+	}
+
+	op["responses"] = responses
+	return op
+}
+
+func operationId(e Endpoint) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(e.Method))
+	for _, segment := range strings.Split(e.Path, "/") {
+		segment = strings.Trim(segment, "{}")
+		if segment == "" {
+			continue
+		}
+
+		b.WriteString("_" + strings.ReplaceAll(segment, "-", "_"))
+	}
+
+	return b.String()
+}
+
+// schemaOf describes a Go type as JSON schema, named structs become
+// components referenced by name
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	if t == rawMessageType {
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+
+		if _, ok := schemas[t.Name()]; !ok {
+			// placeholder for recursive types
+			schemas[t.Name()] = map[string]any{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	return map[string]any{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	addFields(t, schemas, properties, &required)
+
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+func addFields(t reflect.Type, schemas map[string]any, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			addFields(field.Type, schemas, properties, required)
+			continue
+		}
+
+		name := jsonName(field)
+		if !field.IsExported() || name == "" {
+			continue
+		}
+
+		schema := schemaOf(field.Type, schemas)
+		if description := field.Tag.Get("doc"); description != "" {
+			schema = map[string]any{"allOf": []any{schema}, "description": description}
+		}
+
+		properties[name] = schema
+		if field.Tag.Get("required") == "true" {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxBodySize  = 1 << 20
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// Validator is implemented by request bodies with rules beyond required
+// fields, it returns the problems by JSON field name
+type Validator interface {
+	Validate() map[string]string
+}
+
+// Decode reads the JSON body of r into v. Fields tagged required:"true" must
+// be set, unknown fields are rejected.
+func Decode(r *http.Request, v any) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if errors.Is(err, io.EOF) {
+		return BadRequest("Request body is required")
+	}
+
+	if err != nil {
+		return BadRequest("Invalid JSON body: " + err.Error())
+	}
+
+	if decoder.More() {
+		return BadRequest("Invalid JSON body: unexpected data after the object")
+	}
+
+	return Check(v)
+}
+
+// Check validates a request body that was filled in another way, like the
+// query string of the former API
+func Check(v any) error {
+	fields := map[string]string{}
+	checkRequired(reflect.ValueOf(v).Elem(), fields)
+
+	if validator, ok := v.(Validator); ok {
+		for name, problem := range validator.Validate() {
+			if _, ok := fields[name]; !ok {
+				fields[name] = problem
+			}
+		}
+	}
+
+	if len(fields) > 0 {
+		return Invalid(fields)
+	}
+
+	return nil
+}
+
+func checkRequired(v reflect.Value, fields map[string]string) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			checkRequired(v.Field(i), fields)
+			continue
+		}
+
+		if field.Tag.Get("required") == "true" && v.Field(i).IsZero() {
+			fields[jsonName(field)] = "is required"
+		}
+	}
+}
+
+// jsonName returns the name of a struct field in JSON, "" if it is skipped
+func jsonName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return field.Name
+	}
+
+	return name
+}
+
+// Page is the response of paginated endpoints, Next is passed as cursor to
+// get the following page and empty on the last one
+type Page struct {
+	Items any    `json:"items"`
+	Next  string `json:"next,omitempty"`
+}
+
+// PageParams reads the limit and cursor query parameters
+func PageParams(r *http.Request) (int, string, error) {
+	query := r.URL.Query()
+
+	limit := DefaultLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > MaxLimit {
+			return 0, "", Invalid(map[string]string{"limit": "must be between 1 and " + strconv.Itoa(MaxLimit)})
+		}
+
+		limit = n
+	}
+
+	cursor := ""
+	if value := query.Get("cursor"); value != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return 0, "", Invalid(map[string]string{"cursor": "is not a cursor of this API"})
+		}
+
+		cursor = string(decoded)
+	}
+
+	return limit, cursor, nil
+}
+
+func Cursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// Paginate pages through items by their key, which must be unique
+func Paginate[T any](r *http.Request, items []T, key func(T) string) (Page, error) {
+	limit, cursor, err := PageParams(r)
+	if err != nil {
+		return Page{}, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return key(items[i]) < key(items[j])
+	})
+
+	start := sort.Search(len(items), func(i int) bool {
+		return key(items[i]) > cursor
+	})
+
+	page := items[start:]
+	next := ""
+	if len(page) > limit {
+		page = page[:limit]
+		next = Cursor(key(page[limit-1]))
+	}
+
+	return Page{Items: page, Next: next}, nil
+}
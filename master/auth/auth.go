@@ -0,0 +1,84 @@
+package auth
+
+// Sign-in providers configured in config.json. Discord, GitHub and OIDC
+// redirect users to an external site and link the returned account to a
+// user as identity, local users sign in with a password and optional TOTP.
+
+import (
+	"fmt"
+
+	"wired.rip/wiredutils/config"
+)
+
+const (
+	TypeDiscord = "discord"
+	TypeGitHub  = "github"
+	TypeOIDC    = "oidc"
+	TypeLocal   = "local"
+)
+
+// Identity is the account a user signed in with at a provider
+type Identity struct {
+	Provider string
+	Subject  string // stable account id, never the username
+	Username string
+	Avatar   string
+}
+
+// RedirectProvider signs users in through an OAuth 2.0 authorization code flow
+type RedirectProvider interface {
+	AuthURL(state, verifier string) (string, error)
+	Exchange(code, verifier string) (Identity, error)
+}
+
+// Get returns the configuration of a provider
+func Get(id string) (config.AuthProvider, bool) {
+	for _, p := range config.GetAuthProviders() {
+		if p.Id == id {
+			return p, true
+		}
+	}
+
+	return config.AuthProvider{}, false
+}
+
+// LocalEnabled reports whether users may sign in with a password
+func LocalEnabled() bool {
+	for _, p := range config.GetAuthProviders() {
+		if p.Type == TypeLocal {
+			return true
+		}
+	}
+
+	return false
+}
+
+func Redirect(p config.AuthProvider) (RedirectProvider, error) {
+	client := oauthClient{
+		provider:    p.Id,
+		clientId:    p.ClientId,
+		secret:      p.ClientSecret,
+		redirectUri: CallbackURL(p),
+	}
+
+	switch p.Type {
+	case TypeDiscord:
+		return discord{client}, nil
+	case TypeGitHub:
+		return github{client}, nil
+	case TypeOIDC:
+		return oidc{oauthClient: client, issuer: p.Issuer, scopes: p.Scopes}, nil
+	}
+
+	return nil, fmt.Errorf("provider %s does not redirect", p.Id)
+}
+
+// CallbackURL is the redirect URI registered at the provider
+func CallbackURL(p config.AuthProvider) string {
+	// keep the redirect URI of the former Discord sign-in working
+	if p.Id == "discord" && config.GetDiscordRedirectUri() != "" {
+		return config.GetDiscordRedirectUri()
+	}
+
+	return config.GetPublicUrl() + "/api/auth/oauth/" + p.Id + "/callback"
+}
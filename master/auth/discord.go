@@ -0,0 +1,50 @@
+package auth
+
+import "net/url"
+
+type discord struct {
+	oauthClient
+}
+
+func (d discord) AuthURL(state, verifier string) (string, error) {
+	return d.authURL("https://discord.com/oauth2/authorize", "identify", state, url.Values{
+		"code_challenge":        {codeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}), nil
+}
+
+func (d discord) Exchange(code, verifier string) (Identity, error) {
+	token, err := d.exchange("https://discord.com/api/v10/oauth2/token", code, url.Values{"code_verifier": {verifier}})
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var user struct {
+		Id            string `json:"id"`
+		Username      string `json:"username"`
+		Discriminator string `json:"discriminator"`
+		Avatar        string `json:"avatar"`
+	}
+
+	err = getJSON("https://discord.com/api/v10/users/@me", token, &user)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{
+		Provider: d.provider,
+		Subject:  user.Id,
+		Username: user.Username,
+	}
+
+	// accounts that did not migrate to unique usernames yet
+	if user.Discriminator != "" && user.Discriminator != "0" {
+		identity.Username += "#" + user.Discriminator
+	}
+
+	if user.Avatar != "" {
+		identity.Avatar = "https://cdn.discordapp.com/avatars/" + user.Id + "/" + user.Avatar + ".png"
+	}
+
+	return identity, nil
+}
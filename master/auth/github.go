@@ -0,0 +1,36 @@
+package auth
+
+import "strconv"
+
+type github struct {
+	oauthClient
+}
+
+func (g github) AuthURL(state, _ string) (string, error) {
+	return g.authURL("https://github.com/login/oauth/authorize", "read:user", state, nil), nil
+}
+
+func (g github) Exchange(code, _ string) (Identity, error) {
+	token, err := g.exchange("https://github.com/login/oauth/access_token", code, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var user struct {
+		Id        int64  `json:"id"`
+		Login     string `json:"login"`
+		AvatarUrl string `json:"avatar_url"`
+	}
+
+	err = getJSON("https://api.github.com/user", token, &user)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{
+		Provider: g.provider,
+		Subject:  strconv.FormatInt(user.Id, 10),
+		Username: user.Login,
+		Avatar:   user.AvatarUrl,
+	}, nil
+}
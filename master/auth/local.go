@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"time"
+
+	"wired.rip/wiredutils/sqlite"
+)
+
+var (
+	usernamePattern    = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+	ErrInvalidUsername = errors.New("username must be 3 to 32 letters, digits, dots, dashes or underscores")
+)
+
+func NewUserId() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// CreateLocalUser creates a user that signs in with username and password
+func CreateLocalUser(username, password, role string) (sqlite.User, error) {
+	if !usernamePattern.MatchString(username) {
+		return sqlite.User{}, ErrInvalidUsername
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return sqlite.User{}, err
+	}
+
+	id, err := NewUserId()
+	if err != nil {
+		return sqlite.User{}, err
+	}
+
+	user := sqlite.User{
+		Id:        id,
+		Username:  username,
+		Role:      role,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	err = sqlite.CreateLocalUser(user, sqlite.LocalCredential{
+		Username:     username,
+		PasswordHash: hash,
+	})
+	if err != nil {
+		return sqlite.User{}, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+type oauthClient struct {
+	provider    string
+	clientId    string
+	secret      string
+	redirectUri string
+}
+
+func (c oauthClient) authURL(endpoint, scope, state string, extra url.Values) string {
+	query := url.Values{
+		"client_id":     {c.clientId},
+		"response_type": {"code"},
+		"redirect_uri":  {c.redirectUri},
+		"scope":         {scope},
+		"state":         {state},
+	}
+
+	for key, values := range extra {
+		query[key] = values
+	}
+
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+	}
+
+	return endpoint + separator + query.Encode()
+}
+
+// exchange trades an authorization code for an access token
+func (c oauthClient) exchange(endpoint, code string, extra url.Values) (string, error) {
+	form := url.Values{
+		"client_id":     {c.clientId},
+		"client_secret": {c.secret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.redirectUri},
+	}
+
+	for key, values := range extra {
+		form[key] = values
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+
+	err = doJSON(req, &token)
+	if err != nil {
+		return "", fmt.Errorf("exchanging code: %w", err)
+	}
+
+	// github reports errors with status 200
+	if token.Error != "" || token.AccessToken == "" {
+		return "", fmt.Errorf("exchanging code: %s", token.Error)
+	}
+
+	return token.AccessToken, nil
+}
+
+func getJSON(endpoint, accessToken string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	return doJSON(req, v)
+}
+
+func doJSON(req *http.Request, v any) error {
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Host, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// codeChallenge derives the PKCE S256 challenge of a verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidc works with any OpenID Connect issuer (Keycloak, Authentik, Google,
+// ...). The endpoints are discovered from the issuer and the account is read
+// from the userinfo endpoint, so the ID token itself is never trusted.
+type oidc struct {
+	oauthClient
+	issuer string
+	scopes []string
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+type cachedDiscovery struct {
+	discovery
+	fetchedAt time.Time
+}
+
+const discoveryLifetime = time.Hour
+
+var (
+	discoveries   = map[string]cachedDiscovery{}
+	discoveriesMu sync.Mutex
+)
+
+func (o oidc) discover() (discovery, error) {
+	issuer := strings.TrimSuffix(o.issuer, "/")
+	if issuer == "" {
+		return discovery{}, fmt.Errorf("provider %s has no issuer", o.provider)
+	}
+
+	discoveriesMu.Lock()
+	cached, ok := discoveries[issuer]
+	discoveriesMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < discoveryLifetime {
+		return cached.discovery, nil
+	}
+
+	var d discovery
+	err := getJSON(issuer+"/.well-known/openid-configuration", "", &d)
+	if err != nil {
+		return discovery{}, fmt.Errorf("discovering %s: %w", issuer, err)
+	}
+
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return discovery{}, fmt.Errorf("discovering %s: issuer mismatch %q", issuer, d.Issuer)
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.UserinfoEndpoint == "" {
+		return discovery{}, fmt.Errorf("discovering %s: missing endpoints", issuer)
+	}
+
+	discoveriesMu.Lock()
+	discoveries[issuer] = cachedDiscovery{discovery: d, fetchedAt: time.Now()}
+	discoveriesMu.Unlock()
+
+	return d, nil
+}
+
+func (o oidc) AuthURL(state, verifier string) (string, error) {
+	d, err := o.discover()
+	if err != nil {
+		return "", err
+	}
+
+	scopes := o.scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+
+	return o.authURL(d.AuthorizationEndpoint, strings.Join(scopes, " "), state, url.Values{
+		"code_challenge":        {codeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}), nil
+}
+
+func (o oidc) Exchange(code, verifier string) (Identity, error) {
+	d, err := o.discover()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	token, err := o.exchange(d.TokenEndpoint, code, url.Values{"code_verifier": {verifier}})
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var info struct {
+		Subject           string `json:"sub"`
+		PreferredUsername string `json:"preferred_username"`
+		Name              string `json:"name"`
+		Email             string `json:"email"`
+		Picture           string `json:"picture"`
+	}
+
+	err = getJSON(d.UserinfoEndpoint, token, &info)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if info.Subject == "" {
+		return Identity{}, fmt.Errorf("userinfo of %s has no subject", o.provider)
+	}
+
+	username := info.PreferredUsername
+	for _, fallback := range []string{info.Name, info.Email, info.Subject} {
+		if username == "" {
+			username = fallback
+		}
+	}
+
+	return Identity{
+		Provider: o.provider,
+		Subject:  info.Subject,
+		Username: username,
+		Avatar:   info.Picture,
+	}, nil
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// mockProvider is an OIDC issuer handing out a single code. The token
+// endpoint checks the PKCE verifier against the challenge of the sign-in.
+type mockProvider struct {
+	*httptest.Server
+	issuer    string // returned by discovery, the server URL unless changed
+	challenge string
+	userinfo  map[string]string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	p := &mockProvider{userinfo: map[string]string{"sub": "1234", "preferred_username": "alice", "picture": "https://sso.test/alice.png"}}
+	mux := http.NewServeMux()
+	p.Server = httptest.NewServer(mux)
+	p.issuer = p.URL
+	t.Cleanup(p.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                p.issuer,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			UserinfoEndpoint:      p.URL + "/userinfo",
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Method != http.MethodPost || r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("code") != "code" ||
+			r.Form.Get("client_id") != "wired" || r.Form.Get("client_secret") != "secret" ||
+			codeChallenge(r.Form.Get("code_verifier")) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "provider-token", "token_type": "Bearer"})
+	})
+
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer provider-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(p.userinfo)
+	})
+
+	return p
+}
+
+func (p *mockProvider) client() oidc {
+	return oidc{
+		oauthClient: oauthClient{provider: "sso", clientId: "wired", secret: "secret", redirectUri: "https://master.wired.test/api/auth/oauth/sso/callback"},
+		issuer:      p.URL,
+	}
+}
+
+// authorize follows the URL the user is sent to and remembers its challenge
+func (p *mockProvider) authorize(t *testing.T, state, verifier string) {
+	t.Helper()
+
+	authURL, err := p.client().AuthURL(state, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := u.Query()
+	if u.Scheme+"://"+u.Host+u.Path != p.URL+"/authorize" {
+		t.Fatalf("sign-in sent to %s", authURL)
+	}
+
+	if query.Get("state") != state || query.Get("client_id") != "wired" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	p.challenge = query.Get("code_challenge")
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	p := newMockProvider(t)
+	p.authorize(t, "state", "verifier")
+
+	identity, err := p.client().Exchange("code", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Identity{Provider: "sso", Subject: "1234", Username: "alice", Avatar: "https://sso.test/alice.png"}
+	if identity != want {
+		t.Fatalf("got %+v, want %+v", identity, want)
+	}
+}
+
+func TestOIDCUsernameFallback(t *testing.T) {
+	p := newMockProvider(t)
+	p.userinfo = map[string]string{"sub": "1234", "email": "alice@sso.test"}
+	p.authorize(t, "state", "verifier")
+
+	identity, err := p.client().Exchange("code", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Username != "alice@sso.test" {
+		t.Fatalf("username %q", identity.Username)
+	}
+}
+
+func TestOIDCRejectsWrongVerifier(t *testing.T) {
+	p := newMockProvider(t)
+	p.authorize(t, "state", "verifier")
+
+	_, err := p.client().Exchange("code", "another verifier")
+	if err == nil {
+		t.Fatal("exchanged a code with the verifier of another sign-in")
+	}
+}
+
+func TestOIDCRejectsUserinfoWithoutSubject(t *testing.T) {
+	p := newMockProvider(t)
+	p.userinfo = map[string]string{"preferred_username": "alice"}
+	p.authorize(t, "state", "verifier")
+
+	_, err := p.client().Exchange("code", "verifier")
+	if err == nil {
+		t.Fatal("signed in an account without subject")
+	}
+}
+
+func TestOIDCRejectsIssuerMismatch(t *testing.T) {
+	p := newMockProvider(t)
+	p.issuer = "https://evil.test"
+
+	_, err := p.client().AuthURL("state", "verifier")
+	if err == nil {
+		t.Fatal("trusted the discovery document of another issuer")
+	}
+}
+
+func TestStateIsSingleUse(t *testing.T) {
+	state, verifier, err := Begin("sso", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, ok := Finish(state)
+	if !ok || pending.Provider != "sso" || pending.Verifier != verifier {
+		t.Fatalf("state not accepted: %+v %v", pending, ok)
+	}
+
+	if _, ok := Finish(state); ok {
+		t.Fatal("state accepted twice")
+	}
+
+	if _, ok := Finish("forged"); ok {
+		t.Fatal("unknown state accepted")
+	}
+}
+
+func TestStateExpires(t *testing.T) {
+	state, _, err := Begin("sso", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pendingMu.Lock()
+	p := pending[state]
+	p.expiresAt = time.Now().Add(-time.Second)
+	pending[state] = p
+	pendingMu.Unlock()
+
+	if _, ok := Finish(state); ok {
+		t.Fatal("expired state accepted")
+	}
+}
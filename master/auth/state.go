@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"wired.rip/wiredutils/utils"
+)
+
+// Pending is a sign-in that was sent to a provider and awaits its callback
+type Pending struct {
+	Provider   string
+	Verifier   string // PKCE code verifier
+	LinkUserId string // set when linking the identity to a signed in user
+	expiresAt  time.Time
+}
+
+const stateLifetime = 10 * time.Minute
+
+var (
+	pending   = map[string]Pending{}
+	pendingMu sync.Mutex
+)
+
+// Begin starts a sign-in and returns the state and PKCE verifier to send
+// to the provider
+func Begin(provider, linkUserId string) (string, string, error) {
+	state, err := utils.NewSecret("")
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := utils.NewSecret("")
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	for s, p := range pending {
+		if now.After(p.expiresAt) {
+			delete(pending, s)
+		}
+	}
+
+	pending[state] = Pending{
+		Provider:   provider,
+		Verifier:   verifier,
+		LinkUserId: linkUserId,
+		expiresAt:  now.Add(stateLifetime),
+	}
+
+	return state, verifier, nil
+}
+
+// Finish looks up the sign-in of a callback, every state is only valid once
+func Finish(state string) (Pending, bool) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	p, ok := pending[state]
+	if !ok {
+		return Pending{}, false
+	}
+
+	delete(pending, state)
+	return p, time.Now().Before(p.expiresAt)
+}
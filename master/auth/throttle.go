@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failed password and TOTP attempts are counted per account and per client
+// address. Once either reaches its limit, sign-ins are refused until the
+// window of its first failure passed, so neither can be guessed at speed.
+const (
+	throttleWindow     = 15 * time.Minute
+	maxAccountFailures = 5
+	maxAddressFailures = 20
+)
+
+type failures struct {
+	count int
+	since time.Time
+}
+
+var (
+	failed   = map[string]failures{}
+	failedMu sync.Mutex
+)
+
+func accountKey(username string) string {
+	// usernames are case insensitive
+	return "account:" + strings.ToLower(username)
+}
+
+func addressKey(address string) string {
+	return "address:" + address
+}
+
+// Throttled returns how long sign-ins as username from address are refused,
+// zero if they are not
+func Throttled(username, address string, now time.Time) time.Duration {
+	failedMu.Lock()
+	defer failedMu.Unlock()
+
+	var wait time.Duration
+	for key, limit := range map[string]int{accountKey(username): maxAccountFailures, addressKey(address): maxAddressFailures} {
+		f, ok := failed[key]
+		if !ok || f.count < limit {
+			continue
+		}
+
+		wait = max(wait, f.since.Add(throttleWindow).Sub(now))
+	}
+
+	return wait
+}
+
+// FailedSignIn counts a wrong password or TOTP code
+func FailedSignIn(username, address string, now time.Time) {
+	failedMu.Lock()
+	defer failedMu.Unlock()
+
+	for key, f := range failed {
+		if now.Sub(f.since) >= throttleWindow {
+			delete(failed, key)
+		}
+	}
+
+	for _, key := range []string{accountKey(username), addressKey(address)} {
+		f, ok := failed[key]
+		if !ok {
+			f.since = now
+		}
+
+		f.count++
+		failed[key] = f
+	}
+}
+
+// SignedIn forgets the failed attempts on the account of username
+func SignedIn(username string) {
+	failedMu.Lock()
+	defer failedMu.Unlock()
+
+	delete(failed, accountKey(username))
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailedSignInsThrottleAccount(t *testing.T) {
+	now := time.Now()
+	for i := 0; i < maxAccountFailures; i++ {
+		if Throttled("Alice", "10.0.0.1", now) > 0 {
+			t.Fatalf("throttled after %d failures", i)
+		}
+
+		FailedSignIn("alice", "10.0.0.1", now)
+	}
+
+	// from another address too, usernames are case insensitive
+	if Throttled("ALICE", "10.0.0.2", now) <= 0 {
+		t.Fatal("account not throttled")
+	}
+
+	if Throttled("bob", "10.0.0.2", now) > 0 {
+		t.Fatal("another account throttled")
+	}
+
+	if Throttled("alice", "10.0.0.2", now.Add(throttleWindow)) > 0 {
+		t.Fatal("account still throttled after the window")
+	}
+
+	SignedIn("alice")
+	if Throttled("alice", "10.0.0.2", now) > 0 {
+		t.Fatal("account throttled after signing in")
+	}
+}
+
+func TestFailedSignInsThrottleAddress(t *testing.T) {
+	now := time.Now()
+	for i := 0; i < maxAddressFailures; i++ {
+		FailedSignIn("guess"+string(rune('a'+i)), "10.0.1.1", now)
+	}
+
+	if Throttled("carol", "10.0.1.1", now) <= 0 {
+		t.Fatal("address not throttled")
+	}
+
+	if Throttled("carol", "10.0.1.2", now) > 0 {
+		t.Fatal("another address throttled")
+	}
+}
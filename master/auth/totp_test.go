@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTotpCodeIsSingleUse(t *testing.T) {
+	secret, err := NewTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	current := now.Unix() / totpStep
+	code := totpCode(key, uint64(current))
+
+	step, ok := CheckTotp(secret, code, 0, now)
+	if !ok || step != current {
+		t.Fatalf("current code refused: %d %v", step, ok)
+	}
+
+	if _, ok := CheckTotp(secret, code, step, now); ok {
+		t.Fatal("code accepted twice")
+	}
+
+	// the previous step is still within the allowed drift
+	if _, ok := CheckTotp(secret, totpCode(key, uint64(current-1)), step, now); ok {
+		t.Fatal("code of an earlier step accepted after a later one")
+	}
+
+	if _, ok := CheckTotp(secret, totpCode(key, uint64(current+1)), step, now); !ok {
+		t.Fatal("code of the next step refused")
+	}
+}
@@ -0,0 +1,347 @@
+package cluster
+
+// Multi-master support: every master polls its peers and votes for the
+// reachable master with the newest state and the lowest (priority, id). The
+// leader accepts administrative changes, followers redirect them and
+// replicate the leader's state. All masters serve nodes, so nodes may
+// connect to whichever SRV target answers.
+//
+// A master leads once a quorum of the configured masters votes for it and
+// starts a new term then. A master that missed changes, like a preferred
+// master coming back, is not voted for until it replicated them, and no
+// master ever replaces its state with an older one. Masters that reach no
+// quorum refuse administrative changes until the partition heals.
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+	"wiredmaster/events"
+
+	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+const (
+	pollInterval = 2 * time.Second
+	peerTimeout  = 3 * pollInterval
+	secretHeader = "X-Wired-Cluster-Secret"
+)
+
+type Status struct {
+	Id         string `json:"id"`
+	Priority   int    `json:"priority"`
+	Leader     string `json:"leader"`
+	Vote       string `json:"vote"`
+	Term       uint64 `json:"term"`
+	Generation uint64 `json:"generation"`
+	StateHash  string `json:"state_hash"`
+}
+
+func (s Status) version() sqlite.ClusterVersion {
+	return sqlite.ClusterVersion{Term: s.Term, Generation: s.Generation}
+}
+
+type peerState struct {
+	peer      config.ClusterPeer
+	lastSeen  time.Time
+	status    Status
+	reachable bool
+}
+
+var (
+	mux      = &sync.RWMutex{}
+	peers    = make(map[string]*peerState)
+	leaderId string
+	voteId   string
+	client   = &http.Client{Timeout: pollInterval}
+)
+
+// Enabled reports whether this master is part of a cluster
+func Enabled() bool {
+	c := config.GetCluster()
+	return c.Id != "" && len(c.Peers) > 0
+}
+
+// Run polls the peers and keeps leadership and replication up to date
+func Run() {
+	if !Enabled() {
+		return
+	}
+
+	c := config.GetCluster()
+	log.Printf("Cluster member %s with %d peers\n", c.Id, len(c.Peers))
+
+	for _, peer := range c.Peers {
+		peers[peer.Id] = &peerState{peer: peer}
+	}
+
+	for {
+		poll()
+		elect()
+
+		if IsLeader() {
+			recordChanges()
+		} else {
+			replicate()
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+func poll() {
+	var wg sync.WaitGroup
+	for _, p := range snapshotPeers() {
+		wg.Add(1)
+		go func(p config.ClusterPeer) {
+			defer wg.Done()
+
+			var status Status
+			err := get(p, "/api/cluster/status", &status)
+			if err != nil {
+				return
+			}
+
+			mux.Lock()
+			peers[p.Id].lastSeen = time.Now()
+			peers[p.Id].status = status
+			mux.Unlock()
+		}(p)
+	}
+
+	wg.Wait()
+}
+
+func elect() {
+	c := config.GetCluster()
+	local, hash, err := sqlite.GetClusterVersion()
+	if err != nil {
+		slog.Error("Error reading cluster state version", "error", err)
+		return
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+
+	newest := local
+	var reachable []*peerState
+	for id, p := range peers {
+		isReachable := time.Since(p.lastSeen) <= peerTimeout
+		if isReachable != p.reachable {
+			p.reachable = isReachable
+			events.Publish("cluster.peer", map[string]any{"id": id, "reachable": isReachable}, rbac.NodesRead)
+		}
+
+		if !isReachable {
+			continue
+		}
+
+		reachable = append(reachable, p)
+		if newest.Older(p.status.version()) {
+			newest = p.status.version()
+		}
+	}
+
+	// only masters holding the newest state are voted for
+	vote, votePriority := "", 0
+	candidate := func(id string, priority int, version sqlite.ClusterVersion) {
+		if version != newest {
+			return
+		}
+
+		if vote == "" || priority < votePriority || priority == votePriority && id < vote {
+			vote, votePriority = id, priority
+		}
+	}
+
+	candidate(c.Id, c.Priority, local)
+	for _, p := range reachable {
+		candidate(p.peer.Id, p.peer.Priority, p.status.version())
+	}
+	voteId = vote
+
+	votes := 0
+	if vote == c.Id {
+		votes++
+	}
+
+	for _, p := range reachable {
+		if p.status.Vote == c.Id {
+			votes++
+		}
+	}
+
+	bestId := ""
+	if vote == c.Id && votes >= quorum() {
+		bestId = c.Id
+	} else {
+		// follow a reachable leader, never one with an older state
+		var term uint64
+		for _, p := range reachable {
+			if p.status.Leader == p.peer.Id && p.status.Term >= term && !p.status.version().Older(local) {
+				bestId, term = p.peer.Id, p.status.Term
+			}
+		}
+	}
+
+	if bestId == leaderId {
+		return
+	}
+
+	switch bestId {
+	case "":
+		slog.Warn("No quorum of the cluster reachable, refusing administrative changes", "reachable", len(reachable)+1, "members", len(peers)+1)
+	case c.Id:
+		// the new term makes our state newer than that of any master
+		// that may still believe to lead
+		term := local.Term
+		for _, p := range reachable {
+			term = max(term, p.status.Term)
+		}
+
+		local.Term = term + 1
+		err = sqlite.SetClusterVersion(local, hash)
+		if err != nil {
+			slog.Error("Error starting a new cluster term", "error", err)
+			return
+		}
+
+		slog.Info("This master is now the cluster leader", "term", local.Term)
+	default:
+		slog.Info("Master is now the cluster leader", "leader", bestId)
+	}
+
+	leaderId = bestId
+	events.Publish("cluster.leader", map[string]string{"leader": bestId}, rbac.NodesRead)
+}
+
+// quorum is the number of votes a master needs to lead. Two masters fail
+// over to each other, so both lead while they can not reach one another and
+// the changes of the earlier term are lost once they can.
+func quorum() int {
+	members := len(peers) + 1
+	if members == 2 {
+		return 1
+	}
+
+	return members/2 + 1
+}
+
+// IsLeader reports whether this master accepts administrative changes,
+// a master outside of a cluster always does
+func IsLeader() bool {
+	if !Enabled() {
+		return true
+	}
+
+	mux.RLock()
+	defer mux.RUnlock()
+
+	return leaderId == config.GetCluster().Id
+}
+
+// Leader returns the peer currently leading the cluster
+func Leader() (config.ClusterPeer, bool) {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	p, ok := peers[leaderId]
+	if !ok {
+		return config.ClusterPeer{}, false
+	}
+
+	return p.peer, true
+}
+
+// RedirectToLeader sends administrative requests received by a follower
+// to the leading master
+func RedirectToLeader(w http.ResponseWriter, r *http.Request) {
+	leader, ok := Leader()
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"message": "No cluster leader available"}`))
+		return
+	}
+
+	http.Redirect(w, r, leader.Url+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+}
+
+func snapshotPeers() []config.ClusterPeer {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	list := make([]config.ClusterPeer, 0, len(peers))
+	for _, p := range peers {
+		list = append(list, p.peer)
+	}
+
+	return list
+}
+
+func get(p config.ClusterPeer, path string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, p.Url+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(secretHeader, config.GetCluster().Secret)
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s%s: %s", p.Url, path, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func authorized(r *http.Request) bool {
+	secret := config.GetCluster().Secret
+	if secret == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(secret)) == 1
+}
+
+func HandleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Unauthorized"}`))
+		return
+	}
+
+	c := config.GetCluster()
+
+	mux.RLock()
+	leader, vote := leaderId, voteId
+	mux.RUnlock()
+
+	// an empty hash keeps followers from replicating a state we failed to read
+	version, hash, err := sqlite.GetClusterVersion()
+	if err != nil {
+		hash = ""
+	}
+
+	json.NewEncoder(w).Encode(Status{
+		Id:         c.Id,
+		Priority:   c.Priority,
+		Leader:     leader,
+		Vote:       vote,
+		Term:       version.Term,
+		Generation: version.Generation,
+		StateHash:  hash,
+	})
+}
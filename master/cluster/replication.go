@@ -0,0 +1,357 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+	"wiredmaster/routes"
+
+	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+// State is everything a follower copies from the leader
+type State struct {
+	Config           config.ClusterState      `json:"config"`
+	Users            []sqlite.User            `json:"users"`
+	Identities       []sqlite.Identity        `json:"identities"`
+	LocalCredentials []sqlite.LocalCredential `json:"local_credentials"`
+	Roles            []sqlite.Role            `json:"roles"`
+	Tokens           []sqlite.ApiToken        `json:"tokens"`
+	Sessions         []sqlite.Session         `json:"sessions"`
+	Routes           []sqlite.Route           `json:"routes"`
+	RoutesGeneration uint64                   `json:"routes_generation"`
+	Nodes            []sqlite.Node            `json:"nodes"`
+}
+
+type stateResponse struct {
+	Hash    string                `json:"hash"`
+	Version sqlite.ClusterVersion `json:"version"`
+	State   State                 `json:"state"`
+}
+
+// hash of the state last applied from the leader
+var appliedHash string
+
+func currentState() (State, error) {
+	routes, generation, err := sqlite.GetRoutesWithGeneration()
+	if err != nil {
+		return State{}, err
+	}
+
+	nodes, err := sqlite.GetNodes()
+	if err != nil {
+		return State{}, err
+	}
+
+	roles, err := sqlite.GetRoles()
+	if err != nil {
+		return State{}, err
+	}
+
+	tokens, err := sqlite.GetApiTokens("")
+	if err != nil {
+		return State{}, err
+	}
+
+	sessions, err := sqlite.GetAllSessions(time.Now().Unix())
+	if err != nil {
+		return State{}, err
+	}
+
+	users, err := sqlite.GetUsers()
+	if err != nil {
+		return State{}, err
+	}
+
+	identities, err := sqlite.GetIdentities("")
+	if err != nil {
+		return State{}, err
+	}
+
+	credentials, err := sqlite.GetAllLocalCredentials()
+	if err != nil {
+		return State{}, err
+	}
+
+	return State{
+		Config:           config.GetClusterState(),
+		Users:            users,
+		Identities:       identities,
+		LocalCredentials: credentials,
+		Roles:            roles,
+		Tokens:           tokens,
+		Sessions:         sessions,
+		Routes:           routes,
+		RoutesGeneration: generation,
+		Nodes:            nodes,
+	}, nil
+}
+
+func stateHash(state State) (string, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// recordChanges bumps the generation once the state of the leader changed
+func recordChanges() {
+	state, err := currentState()
+	if err != nil {
+		slog.Error("Error reading local cluster state", "error", err)
+		return
+	}
+
+	hash, err := stateHash(state)
+	if err != nil {
+		slog.Error("Error hashing local cluster state", "error", err)
+		return
+	}
+
+	version, recorded, err := sqlite.GetClusterVersion()
+	if err != nil {
+		slog.Error("Error reading cluster state version", "error", err)
+		return
+	}
+
+	if hash == recorded {
+		return
+	}
+
+	version.Generation++
+	err = sqlite.SetClusterVersion(version, hash)
+	if err != nil {
+		slog.Error("Error recording cluster state version", "error", err)
+	}
+}
+
+// replicate pulls the leader's state if it changed since the last poll
+func replicate() {
+	leader, ok := Leader()
+	if !ok {
+		return
+	}
+
+	mux.RLock()
+	leaderHash := peers[leader.Id].status.StateHash
+	mux.RUnlock()
+
+	if leaderHash == "" || leaderHash == appliedHash {
+		return
+	}
+
+	var res stateResponse
+	err := get(leader, "/api/cluster/state", &res)
+	if err != nil {
+		slog.Error("Error fetching cluster state", "error", err)
+		return
+	}
+
+	// fetch binaries and assets first so nodes are never
+	// pointed at a release or asset we can not serve yet
+	err = syncFiles(leader, res.State.Config)
+	if err != nil {
+		slog.Error("Error replicating files, retrying on the next poll", "error", err)
+		return
+	}
+
+	previous, err := currentState()
+	if err != nil {
+		slog.Error("Error reading local cluster state", "error", err)
+		return
+	}
+
+	local, _, err := sqlite.GetClusterVersion()
+	if err != nil {
+		slog.Error("Error reading cluster state version", "error", err)
+		return
+	}
+
+	if res.Version.Older(local) {
+		slog.Warn("Not replicating an older state from the leader", "leader", leader.Id, "term", res.Version.Term, "generation", res.Version.Generation, "local_term", local.Term, "local_generation", local.Generation)
+		return
+	}
+
+	err = sqlite.ReplaceReplica(sqlite.Replica{
+		Version:          res.Version,
+		Hash:             res.Hash,
+		Roles:            res.State.Roles,
+		Tokens:           res.State.Tokens,
+		Sessions:         res.State.Sessions,
+		Users:            res.State.Users,
+		Identities:       res.State.Identities,
+		LocalCredentials: res.State.LocalCredentials,
+		Routes:           res.State.Routes,
+		RoutesGeneration: res.State.RoutesGeneration,
+		Nodes:            res.State.Nodes,
+	})
+	if err != nil {
+		slog.Error("Error replicating cluster state", "error", err)
+		return
+	}
+
+	config.ApplyClusterState(res.State.Config)
+
+	appliedHash = res.Hash
+	log.Printf("Replicated cluster state %s from %s\n", res.Hash[:8], leader.Id)
+
+	if previous.RoutesGeneration != res.State.RoutesGeneration || !reflect.DeepEqual(previous.Routes, res.State.Routes) {
+		routes.SignalChannel <- true
+	}
+
+	if !reflect.DeepEqual(previous.Config.Assets, res.State.Config.Assets) {
+		routes.AssetSignalChannel <- true
+	}
+}
+
+// syncFiles fetches the releases and assets of the leader we miss and
+// returns the first file that could not be fetched
+func syncFiles(leader config.ClusterPeer, state config.ClusterState) error {
+	wanted := make(map[string]string)
+	for platform, hash := range state.NodeReleases {
+		wanted["updates/"+utils.PlatformFileName(platform)] = hash
+	}
+
+	for _, asset := range state.Assets {
+		wanted["assets/"+asset.Name] = asset.Hash
+	}
+
+	for path, hash := range wanted {
+		if localHash, err := hashFile(path); err == nil && localHash == hash {
+			continue
+		}
+
+		slog.Info("Fetching file from the leader", "path", path, "leader", leader.Id)
+		err := fetchFile(leader, path, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func fetchFile(leader config.ClusterPeer, path string, hash string) error {
+	req, err := http.NewRequest(http.MethodGet, leader.Url+"/api/cluster/file?path="+path, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(secretHeader, config.GetCluster().Secret)
+
+	// binaries take longer than the status polls
+	res, err := (&http.Client{}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", path, res.Status)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cluster-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	sum := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, sum), res.Body)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(sum.Sum(nil)) != hash {
+		return fmt.Errorf("fetching %s: checksum mismatch", path)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	sum := sha256.New()
+	_, err = io.Copy(sum, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+func HandleState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Unauthorized"}`))
+		return
+	}
+
+	// read the version first, the state sent is at least as new as it
+	version, hash, err := sqlite.GetClusterVersion()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to read state version", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	state, err := currentState()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to read state", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	json.NewEncoder(w).Encode(stateResponse{
+		Hash:    hash,
+		Version: version,
+		State:   state,
+	})
+}
+
+// HandleFile serves release binaries and assets to followers
+func HandleFile(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Unauthorized"}`))
+		return
+	}
+
+	dir, name, ok := strings.Cut(r.URL.Query().Get("path"), "/")
+	if !ok || (dir != "updates" && dir != "assets") || !utils.ValidAssetName(name) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Invalid path"}`))
+		return
+	}
+
+	http.ServeFile(w, r, dir+"/"+name)
+}
@@ -0,0 +1,127 @@
+package events
+
+// Events of this master, streamed to the dashboard. Each master publishes
+// what happens on it, like the nodes connected to it, so followers of a
+// cluster have their own streams.
+
+import (
+	"sync"
+	"time"
+
+	"wired.rip/wiredutils/rbac"
+)
+
+const (
+	historySize      = 256 // events kept for clients resuming a stream
+	subscriberBuffer = 64  // events a subscriber may lag behind before it is dropped
+)
+
+type Event struct {
+	Id   uint64 `json:"id"`
+	Type string `json:"type"` // e.g. node.connected, player.joined, route.updated
+	Time int64  `json:"time"`
+	Data any    `json:"data"`
+
+	permission rbac.Permission
+	owners     []string // route owners the event is about, nil if it is about none
+}
+
+// VisibleTo reports whether principal may receive the event. Events about
+// routes are only shown to their owners and callers managing every route.
+func (e Event) VisibleTo(principal rbac.Principal) bool {
+	if e.permission != "" && !principal.Has(e.permission) {
+		return false
+	}
+
+	if e.owners == nil {
+		return true
+	}
+
+	for _, owner := range e.owners {
+		if principal.CanAccessRoute(owner) {
+			return true
+		}
+	}
+
+	return principal.Has(rbac.RoutesAll)
+}
+
+// Subscription receives the events published after it was created. C is
+// closed when the subscriber falls too far behind.
+type Subscription struct {
+	C chan Event
+}
+
+var (
+	mux         = &sync.Mutex{}
+	lastId      uint64
+	history     []Event
+	subscribers = make(map[*Subscription]struct{})
+)
+
+// Publish sends an event to every subscriber. Only callers holding
+// permission see it, with owners only the owners of those routes do.
+func Publish(eventType string, data any, permission rbac.Permission, owners ...string) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	lastId++
+	e := Event{
+		Id:         lastId,
+		Type:       eventType,
+		Time:       time.Now().Unix(),
+		Data:       data,
+		permission: permission,
+		owners:     owners,
+	}
+
+	history = append(history, e)
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+
+	for sub := range subscribers {
+		select {
+		case sub.C <- e:
+		default:
+			// a stuck client must not hold up the master, it resumes
+			// from the history once it reconnects
+			delete(subscribers, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// Subscribe starts a subscription. The events after afterId that are
+// still known are returned with it, complete is false if some are lost.
+func Subscribe(afterId uint64) (*Subscription, []Event, bool) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	sub := &Subscription{C: make(chan Event, subscriberBuffer)}
+	subscribers[sub] = struct{}{}
+
+	if afterId == 0 || afterId >= lastId {
+		return sub, nil, afterId <= lastId
+	}
+
+	missed := []Event{}
+	for _, e := range history {
+		if e.Id > afterId {
+			missed = append(missed, e)
+		}
+	}
+
+	complete := len(missed) > 0 && missed[0].Id == afterId+1
+	return sub, missed, complete
+}
+
+func Unsubscribe(sub *Subscription) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if _, ok := subscribers[sub]; ok {
+		delete(subscribers, sub)
+		close(sub.C)
+	}
+}
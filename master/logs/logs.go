@@ -0,0 +1,179 @@
+package logs
+
+// Logs forwarded by the nodes connected to this master. Each node has a
+// ring of its latest entries that clients tail and follow.
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"wired.rip/wiredutils/logging"
+)
+
+const (
+	bufferSize       = 2000 // entries kept per node
+	subscriberBuffer = 256  // entries a subscriber may lag behind before it is dropped
+)
+
+type Entry struct {
+	Id      uint64            `json:"id"`
+	Node    string            `json:"node"`
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+
+	level slog.Level
+}
+
+// Filter selects entries of at least Level carrying all Fields
+type Filter struct {
+	Level  slog.Level
+	Fields map[string]string
+}
+
+func (f Filter) Matches(e Entry) bool {
+	if e.level < f.Level {
+		return false
+	}
+
+	for key, value := range f.Fields {
+		if e.Fields[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Subscription receives the entries of a node appended after it was
+// created. C is closed when the subscriber falls too far behind.
+type Subscription struct {
+	C    chan Entry
+	node string
+}
+
+var (
+	mux         = &sync.Mutex{}
+	lastId      uint64
+	buffers     = make(map[string][]Entry) // by node id
+	trimmed     = make(map[string]uint64)  // by node id, latest id pushed out of its buffer
+	subscribers = make(map[*Subscription]struct{})
+)
+
+// Append stores entries a node forwarded. Entries the node dropped are
+// noted with a warning in their place.
+func Append(nodeId string, entries []logging.Entry, dropped uint64) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if dropped > 0 {
+		add(nodeId, logging.Entry{
+			Time:    time.Now().UnixNano(),
+			Level:   slog.LevelWarn,
+			Message: fmt.Sprintf("%d entries were dropped by the node", dropped),
+		})
+	}
+
+	for _, entry := range entries {
+		add(nodeId, entry)
+	}
+}
+
+func add(nodeId string, entry logging.Entry) {
+	lastId++
+	e := Entry{
+		Id:      lastId,
+		Node:    nodeId,
+		Time:    time.Unix(0, entry.Time),
+		Level:   logging.LevelName(entry.Level),
+		Message: entry.Message,
+		Fields:  entry.Fields,
+		level:   entry.Level,
+	}
+
+	buffer := append(buffers[nodeId], e)
+	if len(buffer) > bufferSize {
+		trimmed[nodeId] = buffer[len(buffer)-bufferSize-1].Id
+		buffer = buffer[len(buffer)-bufferSize:]
+	}
+
+	buffers[nodeId] = buffer
+
+	for sub := range subscribers {
+		if sub.node != nodeId {
+			continue
+		}
+
+		select {
+		case sub.C <- e:
+		default:
+			// a stuck client must not hold up the node, it resumes
+			// from the buffer once it reconnects
+			delete(subscribers, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// Tail returns the latest limit entries of a node matching filter,
+// oldest first
+func Tail(nodeId string, filter Filter, limit int) []Entry {
+	mux.Lock()
+	defer mux.Unlock()
+
+	buffer := buffers[nodeId]
+	matched := []Entry{}
+	for i := len(buffer) - 1; i >= 0 && len(matched) < limit; i-- {
+		if filter.Matches(buffer[i]) {
+			matched = append(matched, buffer[i])
+		}
+	}
+
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+
+	return matched
+}
+
+// Subscribe starts a subscription to a node. The buffered entries after
+// afterId are returned with it, complete is false if some were lost.
+func Subscribe(nodeId string, afterId uint64) (*Subscription, []Entry, bool) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	sub := &Subscription{C: make(chan Entry, subscriberBuffer), node: nodeId}
+	subscribers[sub] = struct{}{}
+
+	missed := []Entry{}
+	for _, e := range buffers[nodeId] {
+		if e.Id > afterId {
+			missed = append(missed, e)
+		}
+	}
+
+	complete := afterId == 0 || trimmed[nodeId] <= afterId
+	return sub, missed, complete
+}
+
+func Unsubscribe(sub *Subscription) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if _, ok := subscribers[sub]; ok {
+		delete(subscribers, sub)
+		close(sub.C)
+	}
+}
+
+// Remove drops the entries of a deleted node
+func Remove(nodeId string) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	delete(buffers, nodeId)
+	delete(trimmed, nodeId)
+}
@@ -4,18 +4,18 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"runtime"
+	"wiredmaster/auth"
 	"wiredmaster/master"
 
 	"wired.rip/wiredutils/config"
-	"wired.rip/wiredutils/terminal"
+	"wired.rip/wiredutils/logging"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
 )
 
 func main() {
 	config.Init()
-	log.SetFlags(0)
-	prefix := fmt.Sprintf("%s.%s » ", config.GetSystemKey(), config.GetWiredHost())
-	log.SetPrefix(terminal.PrefixColor + prefix + terminal.Reset)
+	logging.Setup(fmt.Sprintf("%s.%s » ", config.GetSystemKey(), config.GetWiredHost()), config.GetLogging().Level)
 
 	if len(os.Args) > 1 {
 		args := os.Args[1:]
@@ -24,8 +24,10 @@ func main() {
 			master.Run()
 		case "add-node":
 			addNode(args[1:])
+		case "add-user":
+			addUser(args[1:])
 		case "debug":
-			log.Println(runtime.GOARCH)
+			log.Println(utils.CurrentPlatform())
 		}
 	} else {
 		master.Run()
@@ -40,11 +42,38 @@ func addNode(args []string) {
 	key := args[0]
 	password := args[1]
 
-	_ = config.AddNode(config.Node{
+	sqlite.Init()
+	defer sqlite.Close()
+
+	err := sqlite.AddNode(sqlite.Node{
 		Id:             key,
 		Passphrase:     password,
 		LastConnection: 0,
 	})
+	if err != nil {
+		log.Fatalln("Error adding node:", err)
+	}
 
 	log.Println("Node added")
 }
+
+func addUser(args []string) {
+	if len(args) < 2 {
+		log.Fatalln("No arguments provided -> add-user <username> <password> [role]")
+	}
+
+	role := "user"
+	if len(args) > 2 {
+		role = args[2]
+	}
+
+	sqlite.Init()
+	defer sqlite.Close()
+
+	user, err := auth.CreateLocalUser(args[0], args[1], role)
+	if err != nil {
+		log.Fatalln("Error adding user:", err)
+	}
+
+	log.Printf("User %s added with id %s\n", user.Username, user.Id)
+}
@@ -0,0 +1,71 @@
+package master
+
+import (
+	"net/http"
+	"wiredmaster/api"
+	"wiredmaster/routes"
+
+	"wired.rip/wiredutils/rbac"
+)
+
+// registerApiV2 serves /api/v2. Endpoints sharing a path are registered
+// once and dispatched by method, so every answer uses the error envelope.
+func registerApiV2() {
+	for _, group := range api.Groups(routes.V2) {
+		group := group
+		http.HandleFunc(api.Prefix+group.Path, func(w http.ResponseWriter, r *http.Request) {
+			endpoint, ok := group.Find(r.Method)
+			if !ok {
+				w.Header().Set("Allow", group.Allow())
+				api.WriteError(w, api.MethodNotAllowed())
+				return
+			}
+
+			serveApiV2(endpoint, w, r)
+		})
+	}
+
+	spec := api.OpenAPI(routes.V2)
+	http.HandleFunc(api.Prefix+"/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			api.WriteError(w, api.MethodNotAllowed())
+			return
+		}
+
+		api.WriteJSON(w, http.StatusOK, spec)
+	})
+
+	http.HandleFunc(api.Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		api.WriteError(w, api.NotFound("Not found"))
+	})
+}
+
+// serveApiV2 applies what adminHandler and userHandler do for the former
+// API: mutations are audited and run on the leader
+func serveApiV2(endpoint api.Endpoint, w http.ResponseWriter, r *http.Request) {
+	handler := endpoint.Serve
+	if endpoint.Mutates() {
+		handler = leaderOnly(routes.Audited(endpoint.Action, handler))
+	} else if endpoint.LeaderOnly {
+		handler = leaderOnly(handler)
+	}
+
+	if endpoint.Public {
+		handler(w, r)
+		return
+	}
+
+	principal, ok := authenticate(r)
+	if !ok {
+		api.WriteError(w, api.Unauthorized())
+		return
+	}
+
+	if endpoint.Permission != "" && !principal.Has(endpoint.Permission) {
+		api.WriteError(w, api.Forbidden(string(endpoint.Permission)))
+		return
+	}
+
+	handler(w, r.WithContext(rbac.WithPrincipal(r.Context(), principal)))
+}
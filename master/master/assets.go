@@ -0,0 +1,123 @@
+package master
+
+import (
+	"log/slog"
+	"sync"
+	"wiredmaster/routes"
+
+	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/packet"
+	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/utils"
+)
+
+// transfers that were sent but not yet confirmed by an asset report,
+// keyed by node and asset name
+var (
+	pendingAssets    = make(map[string]map[string]string)
+	pendingAssetsMux = &sync.Mutex{}
+)
+
+func assetUpdater() {
+	for {
+		<-routes.AssetSignalChannel
+
+		for key, client := range utils.GetClients() {
+			syncAssets(key, client)
+		}
+	}
+}
+
+func handleAssetReport(key string, conn *protocol.Conn, report packet.AssetReport) {
+	assets := make(map[string]string, len(report.Assets))
+	for _, a := range report.Assets {
+		assets[a.Name] = a.Hash
+	}
+
+	utils.SetNodeAssets(key, assets)
+
+	// transfers the node confirmed are done, the others are still in flight
+	pendingAssetsMux.Lock()
+	for name, hash := range pendingAssets[key] {
+		if assets[name] == hash {
+			delete(pendingAssets[key], name)
+		}
+	}
+	pendingAssetsMux.Unlock()
+
+	syncAssets(key, conn)
+}
+
+// syncAssets pushes every asset the node is missing or has an outdated copy
+// of and removes assets that are no longer assigned to it
+func syncAssets(key string, conn *protocol.Conn) {
+	reported, ok := utils.GetNodeAssets(key)
+	if !ok {
+		// the node did not report its state yet, it will after hello
+		return
+	}
+
+	wanted := make(map[string]bool)
+	for _, asset := range config.GetAssetsForNode(key) {
+		wanted[asset.Name] = true
+		if reported[asset.Name] == asset.Hash || !markPending(key, asset) {
+			continue
+		}
+
+		slog.Info("Sending asset", "node", key, "asset", asset.Name, "version", asset.Version)
+		err := conn.SendFile(packet.AssetLabelPrefix+asset.Name, "assets/"+asset.Name, packet.Id_BinaryData, packet.Id_BinaryEnd)
+		if err != nil {
+			slog.Error("Error sending asset", "node", key, "asset", asset.Name, "error", err)
+			unmarkPending(key, asset.Name)
+		}
+	}
+
+	for name := range reported {
+		if wanted[name] {
+			continue
+		}
+
+		slog.Info("Removing asset", "node", key, "asset", name)
+		err := conn.SendPacket(packet.Id_AssetRemove, packet.AssetRemove{
+			Name: name,
+		})
+		if err != nil {
+			slog.Error("Error removing asset", "node", key, "asset", name, "error", err)
+		}
+	}
+}
+
+// markPending records a transfer and reports false if the same
+// version is already on its way to the node
+func markPending(key string, asset config.Asset) bool {
+	pendingAssetsMux.Lock()
+	defer pendingAssetsMux.Unlock()
+
+	pending, ok := pendingAssets[key]
+	if !ok {
+		pending = make(map[string]string)
+		pendingAssets[key] = pending
+	}
+
+	if pending[asset.Name] == asset.Hash {
+		return false
+	}
+
+	pending[asset.Name] = asset.Hash
+	return true
+}
+
+func unmarkPending(key string, name string) {
+	pendingAssetsMux.Lock()
+	defer pendingAssetsMux.Unlock()
+
+	delete(pendingAssets[key], name)
+}
+
+func forgetNodeAssets(key string) {
+	utils.RemoveNodeAssets(key)
+
+	pendingAssetsMux.Lock()
+	delete(pendingAssets, key)
+	pendingAssetsMux.Unlock()
+}
@@ -0,0 +1,172 @@
+package master
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"wired.rip/wiredutils/jwt"
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+// streams check every so often that their caller may still use the API,
+// an expired access token ends them too
+const reauthenticateInterval = 30 * time.Second
+
+// authenticate resolves the caller from the bearer token, either a JWT from
+// signing in or an API token. Roles are read from the database so role
+// changes apply without signing in again.
+func authenticate(r *http.Request) (rbac.Principal, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return rbac.Principal{}, false
+	}
+
+	if strings.HasPrefix(token, sqlite.ApiTokenPrefix) {
+		return authenticateApiToken(token)
+	}
+
+	claims, err := jwt.ValidateToken(token)
+	if err != nil {
+		return rbac.Principal{}, false
+	}
+
+	// access tokens die with their session
+	userId, _ := claims["sub"].(string)
+	sessionId, _ := claims["sid"].(string)
+	session, ok, err := sqlite.GetSession(sessionId)
+	if err != nil {
+		slog.Error("Error looking up session", "error", err)
+		return rbac.Principal{}, false
+	}
+
+	if !ok || session.UserId != userId || !session.Active(time.Now().Unix()) {
+		return rbac.Principal{}, false
+	}
+
+	principal, ok := userPrincipal(userId)
+	principal.SessionId = sessionId
+	return principal, ok
+}
+
+// whileAuthenticated ends long running requests like event streams once
+// their session or token is revoked
+func whileAuthenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		go func() {
+			ticker := time.NewTicker(reauthenticateInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if _, ok := authenticate(r); !ok {
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+func userPrincipal(userId string) (rbac.Principal, bool) {
+	user, ok, err := sqlite.GetUser(userId)
+	if err != nil {
+		slog.Error("Error looking up user", "error", err)
+		return rbac.Principal{}, false
+	}
+
+	if !ok {
+		return rbac.Principal{}, false
+	}
+
+	principal := rbac.Principal{
+		UserId: userId,
+		Role:   user.Role,
+	}
+
+	if user.Role == rbac.AdminRole {
+		principal.Permissions = []rbac.Permission{rbac.Wildcard}
+		return principal, true
+	}
+
+	role, _, err := sqlite.GetRole(user.Role)
+	if err != nil {
+		slog.Error("Error looking up role", "error", err)
+		return rbac.Principal{}, false
+	}
+
+	for _, p := range role.Permissions {
+		principal.Permissions = append(principal.Permissions, rbac.Permission(p))
+	}
+
+	return principal, true
+}
+
+func authenticateApiToken(token string) (rbac.Principal, bool) {
+	t, ok, err := sqlite.GetApiTokenByHash(utils.HashSecret(token))
+	if err != nil {
+		slog.Error("Error looking up API token", "error", err)
+		return rbac.Principal{}, false
+	}
+
+	now := time.Now().Unix()
+	if !ok || t.RevokedAt != 0 || t.ExpiresAt != 0 && t.ExpiresAt <= now {
+		return rbac.Principal{}, false
+	}
+
+	scopes := make([]rbac.Permission, 0, len(t.Scopes))
+	for _, s := range t.Scopes {
+		scopes = append(scopes, rbac.Permission(s))
+	}
+
+	var principal rbac.Principal
+	switch t.Kind {
+	case sqlite.TokenPersonal:
+		// personal tokens die with their owner's access
+		principal, ok = userPrincipal(t.Owner)
+		if !ok {
+			return rbac.Principal{}, false
+		}
+
+		principal = principal.Restrict(scopes)
+	case sqlite.TokenService:
+		// service tokens never hold more than their creator does now and
+		// die with the creator's account
+		creator, ok := userPrincipal(t.CreatedBy)
+		if !ok {
+			return rbac.Principal{}, false
+		}
+
+		principal = rbac.Principal{
+			UserId:      t.Owner,
+			Permissions: creator.Restrict(scopes).Permissions,
+		}
+	default:
+		return rbac.Principal{}, false
+	}
+
+	principal.TokenId = t.Id
+
+	// only track the last use with minute precision to spare the database
+	if now-t.LastUsedAt >= 60 {
+		err = sqlite.SetApiTokenLastUsed(t.Id, now)
+		if err != nil {
+			slog.Error("Error updating API token", "error", err)
+		}
+	}
+
+	return principal, true
+}
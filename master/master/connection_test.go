@@ -0,0 +1,98 @@
+package master
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+	"wiredmaster/logs"
+
+	"wired.rip/wiredutils/logging"
+	"wired.rip/wiredutils/packet"
+	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/utils"
+)
+
+// connectNode opens a connection to the master up to the point a node
+// would say hello. done is closed once the master dropped the connection.
+func connectNode(t *testing.T) (*protocol.Conn, <-chan struct{}) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := wiredKey
+	wiredKey = key
+	t.Cleanup(func() { wiredKey = previous })
+
+	a, b := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleConnection(protocol.NewConn(a, key, nil))
+	}()
+
+	node := protocol.NewConn(b, nil, &key.PublicKey)
+	t.Cleanup(func() { node.Close() })
+
+	secret := []byte("0123456789abcdef")
+	err = node.SendPacket(packet.Id_SharedSecret, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = node.EnableEncryption(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ready protocol.Packet
+	err = ready.Read(node)
+	if err != nil || ready.ID != packet.Id_Ready {
+		t.Fatalf("no ready packet: %v %d", err, ready.ID)
+	}
+
+	return node, done
+}
+
+func TestTelemetryBeforeHelloIsRejected(t *testing.T) {
+	node, done := connectNode(t)
+
+	err := node.SendPacket(packet.Id_Telemetry, packet.Telemetry{Cores: 4, Goroutines: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection that sent telemetry before hello was kept open")
+	}
+
+	if samples := utils.GetTelemetry(node.RemoteAddr().String()); len(samples) != 0 {
+		t.Fatalf("telemetry before hello was recorded: %+v", samples)
+	}
+}
+
+func TestLogsBeforeHelloAreRejected(t *testing.T) {
+	node, done := connectNode(t)
+
+	err := node.SendPacket(packet.Id_Logs, packet.Logs{Entries: []logging.Entry{{Time: time.Now().UnixNano(), Level: slog.LevelInfo, Message: "forged"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection that sent logs before hello was kept open")
+	}
+
+	if entries := logs.Tail(node.RemoteAddr().String(), logs.Filter{}, 10); len(entries) != 0 {
+		t.Fatalf("logs before hello were stored: %+v", entries)
+	}
+}
@@ -1,13 +1,20 @@
 package master
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+
+	"wired.rip/wiredutils/packet"
+	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/sqlite"
 )
 
 var wiredKey *rsa.PrivateKey
@@ -27,7 +34,7 @@ func loadWiredKeyPair() {
 
 		pubFile, err := os.Create(pubFileName)
 		if err != nil {
-			log.Println("Error creating public key file:", err)
+			slog.Error("Error creating public key file", "error", err)
 		}
 		defer pubFile.Close()
 
@@ -100,3 +107,40 @@ func loadWiredKeyPair() {
 	log.Println("Loaded RSA key pair")
 	wiredKey = priv
 }
+
+// signRoutes builds the routes packet for the current routes,
+// signed with the wired key so nodes can persist and verify it
+func signRoutes(routes []protocol.Route, generation uint64) (packet.Routes, error) {
+	packet.SortRoutes(routes)
+	p := packet.Routes{
+		Routes:     routes,
+		Generation: generation,
+	}
+
+	if wiredKey == nil {
+		return p, errors.New("wired key pair not loaded yet")
+	}
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, wiredKey, crypto.SHA256, p.Digest())
+	if err != nil {
+		return p, err
+	}
+
+	p.Signature = signature
+	return p, nil
+}
+
+// currentRoutes reads the routes placed on a node and signs them
+func currentRoutes(nodeId string) (packet.Routes, error) {
+	routes, generation, err := sqlite.GetRoutesWithGeneration()
+	if err != nil {
+		return packet.Routes{}, err
+	}
+
+	node, _, err := sqlite.GetNode(nodeId)
+	if err != nil {
+		return packet.Routes{}, err
+	}
+
+	return signRoutes(sqlite.WireRoutes(routes, node), generation)
+}
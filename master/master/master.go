@@ -1,69 +1,110 @@
 package master
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"strings"
 	"time"
+	"wiredmaster/cluster"
+	"wiredmaster/events"
+	"wiredmaster/logs"
 	"wiredmaster/routes"
 
 	"wired.rip/wiredutils/config"
 	"wired.rip/wiredutils/jwt"
+	"wired.rip/wiredutils/logging"
 	"wired.rip/wiredutils/packet"
 	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/rbac"
 	"wired.rip/wiredutils/sqlite"
-	"wired.rip/wiredutils/terminal"
 	"wired.rip/wiredutils/utils"
 )
 
 func Run() {
 	config.Init()
-	log.SetFlags(0)
-	prefix := fmt.Sprintf("%s.%s » ", config.GetSystemKey(), config.GetWiredHost())
-	log.SetPrefix(terminal.PrefixColor + prefix + terminal.Reset)
+	logging.Setup(fmt.Sprintf("%s.%s » ", config.GetSystemKey(), config.GetWiredHost()), config.GetLogging().Level)
+
+	sqlite.Init()
+	jwt.Init()
+	importConfig()
 
 	go startHttpServer()
 	go routeUpdater()
+	go assetUpdater()
+	go analyticsMaintainer()
 
-	sqlite.Init()
-	jwt.Init()
+	go cluster.Run()
 
 	updateRoles()
 	loadWiredKeyPair()
 	startServer()
 }
 
+// importConfig moves the routes and nodes of older config files into sqlite
+func importConfig() {
+	legacyNodes := config.GetNodes()
+	nodes := make([]sqlite.Node, 0, len(legacyNodes))
+	for _, n := range legacyNodes {
+		nodes = append(nodes, sqlite.Node{
+			Id:             n.Id,
+			Passphrase:     n.Passphrase,
+			LastConnection: n.LastConnection,
+		})
+	}
+
+	imported, err := sqlite.ImportConfig(config.GetRoutes(), config.GetRoutesGeneration(), nodes)
+	if err != nil {
+		log.Fatalln("Error importing routes and nodes from config.json:", err)
+	}
+
+	if imported {
+		log.Printf("Imported %d routes and %d nodes from config.json\n", len(config.GetRoutes()), len(nodes))
+	}
+
+	if len(config.GetRoutes()) > 0 || len(nodes) > 0 {
+		config.ClearImported()
+	}
+}
+
 func updateRoles() {
 	adminId := config.GetAdminDiscordId()
 	if adminId == "" {
-		log.Println("No admin assigned in config.json yet")
+		slog.Warn("No admin assigned in config.json yet")
 		return
 	}
 
-	// check if admin exists in database
-	_, _, _, _, role, err := sqlite.GetUser("discord_id", adminId)
+	// the admin is whoever signs in with that Discord account
+	identity, ok, err := sqlite.GetIdentity("discord", adminId)
 	if err != nil {
-		log.Println("Error checking if admin exists in database:", err)
+		slog.Error("Error checking if admin exists in database", "error", err)
 		return
 	}
 
-	if role == "" {
+	if !ok {
 		log.Println("Admin not yet in database. Consider signing in with Discord soon.")
 		return
 	}
 
-	if role != "admin" {
-		log.Println("Admin ID found in database, but role is not admin. Changing role to admin.")
-		err = sqlite.ChangeUserRole(adminId, "admin")
+	user, ok, err := sqlite.GetUser(identity.UserId)
+	if err != nil || !ok {
+		slog.Error("Error looking up admin", "error", err)
+		return
+	}
+
+	if user.Role != "admin" {
+		slog.Warn("Admin ID found in database, but role is not admin. Changing role to admin.")
+		err = sqlite.ChangeUserRole(user.Id, "admin")
 		if err != nil {
-			log.Println("Error changing user role to admin:", err)
+			slog.Error("Error changing user role to admin", "error", err)
 			return
 		}
 	}
@@ -81,33 +122,80 @@ func startHttpServer() {
 		routes.IndexRoute(w, r)
 	})
 
-	userHandler("/api/routes", routes.GetRoutes, http.MethodGet)
-	userHandler("/api/nodes", routes.GetNodes, http.MethodGet)
-	userHandler("/api/users", routes.GetUsers, http.MethodGet)
-	adminHandler("/api/users/role", routes.ChangeUserRole, http.MethodGet)
-	adminHandler("/api/routes/add", routes.AddRoute, http.MethodGet)
-	adminHandler("/api/routes/remove", routes.RemoveRoute, http.MethodDelete)
-	adminHandler("/api/node/add", routes.AddNode, http.MethodGet)
-	adminHandler("/api/node/delete", routes.DeleteNode, http.MethodGet)
-	adminHandler("/api/node/set-hash", routes.SetNodeHash, http.MethodGet)
-	adminHandler("/api/node/update-binary", routes.UpdateBinary, http.MethodPost)
-	adminHandler("/api/node/disconnect", routes.DisconnectNode, http.MethodGet)
+	userHandler("/api/routes", routes.GetRoutes, http.MethodGet, rbac.RoutesRead)
+	userHandler("/api/nodes", routes.GetNodes, http.MethodGet, rbac.NodesRead)
+	userHandler("/api/nodes/{id}", routes.GetNode, http.MethodGet, rbac.NodesRead)
+	userHandler("/api/nodes/{id}/logs", whileAuthenticated(routes.NodeLogs), http.MethodGet, rbac.NodesLogs)
+	userHandler("/api/players", routes.GetPlayers, http.MethodGet, rbac.PlayersRead)
+	userHandler("/api/players/sessions", routes.GetPlayerSessions, http.MethodGet, rbac.PlayersRead)
+	userHandler("/api/players/stats", routes.GetPlayerStats, http.MethodGet, rbac.PlayersRead)
+	userHandler("/api/players/top", routes.GetTopPlayers, http.MethodGet, rbac.PlayersRead)
+	userHandler("/api/users", routes.GetUsers, http.MethodGet, rbac.UsersManage)
+	adminHandler("/api/users/role", routes.ChangeUserRole, http.MethodGet, rbac.UsersManage)
+	adminHandler("/api/users/create", routes.CreateUser, http.MethodPost, rbac.UsersManage)
+	userHandler("/api/roles", routes.GetRoles, http.MethodGet, rbac.UsersManage)
+	adminHandler("/api/roles/set", routes.SetRole, http.MethodPost, rbac.UsersManage)
+	adminHandler("/api/roles/delete", routes.DeleteRole, http.MethodDelete, rbac.UsersManage)
+	userHandler("/api/tokens", routes.GetTokens, http.MethodGet, "")
+	adminHandler("/api/tokens/create", routes.CreateToken, http.MethodPost, "")
+	adminHandler("/api/tokens/revoke", routes.RevokeToken, http.MethodDelete, "")
+	adminHandler("/api/routes/add", routes.AddRoute, http.MethodGet, rbac.RoutesWrite)
+	adminHandler("/api/routes/remove", routes.RemoveRoute, http.MethodDelete, rbac.RoutesWrite)
+	adminHandler("/api/node/add", routes.AddNode, http.MethodGet, rbac.NodesManage)
+	adminHandler("/api/node/delete", routes.DeleteNode, http.MethodGet, rbac.NodesManage)
+	adminHandler("/api/node/set-hash", routes.SetNodeHash, http.MethodGet, rbac.ReleasesPublish)
+	adminHandler("/api/node/update-binary", routes.UpdateBinary, http.MethodPost, rbac.ReleasesPublish)
+	adminHandler("/api/node/disconnect", routes.DisconnectNode, http.MethodGet, rbac.PlayersKick)
+	userHandler("/api/assets", routes.GetAssets, http.MethodGet, rbac.NodesRead)
+	adminHandler("/api/assets/upload", routes.UploadAsset, http.MethodPost, rbac.AssetsManage)
+	adminHandler("/api/assets/delete", routes.DeleteAsset, http.MethodDelete, rbac.AssetsManage)
 	adminHandler("/api/node/update", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		// send update packet
 
 		clients := utils.GetClients()
-		for _, client := range clients {
-			log.Println(client)
-			sendBinaryUpdate(client, "updates")
+		for key, client := range clients {
+			sendBinaryUpdate(key, client, "updates")
 		}
 
+		routes.Audit(r, "*", nil, nil)
+
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"message": "Update packet sent"}`))
-	}, http.MethodGet)
-
-	customHandler("/api/auth/discord", routes.AuthDiscord, http.MethodGet)
-	customHandler("/api/auth/discord/callback", routes.AuthDiscordCallback, http.MethodGet)
+	}, http.MethodGet, rbac.ReleasesPublish)
+
+	// the audit log lives on the leader, which receives every change
+	userHandler("/api/audit", leaderOnly(routes.GetAudit), http.MethodGet, rbac.AuditRead)
+
+	// each master streams what happens on it, events are filtered per caller
+	userHandler("/api/events", whileAuthenticated(routes.Events), http.MethodGet, "")
+
+	// sign-ins are started on the leader, which keeps the pending states
+	customHandler("/api/auth/providers", routes.GetAuthProviders, http.MethodGet)
+	customHandler("/api/auth/oauth/{provider}", leaderOnly(routes.AuthOAuth), http.MethodGet)
+	customHandler("/api/auth/oauth/{provider}/callback", leaderOnly(routes.AuthOAuthCallback), http.MethodGet)
+	customHandler("/api/auth/discord", leaderOnly(withProvider("discord", routes.AuthOAuth)), http.MethodGet)
+	customHandler("/api/auth/discord/callback", leaderOnly(withProvider("discord", routes.AuthOAuthCallback)), http.MethodGet)
+	customHandler("/api/auth/local/login", leaderOnly(routes.LocalLogin), http.MethodPost)
+	userHandler("/api/auth/oauth/{provider}/link", leaderOnly(routes.LinkIdentity), http.MethodGet, "")
+	userHandler("/api/auth/identities", routes.GetIdentities, http.MethodGet, "")
+	adminHandler("/api/auth/identities/unlink", routes.UnlinkIdentity, http.MethodDelete, "")
+	adminHandler("/api/auth/local/password", routes.ChangePassword, http.MethodPost, "")
+	adminHandler("/api/auth/local/totp/setup", routes.SetupTotp, http.MethodPost, "")
+	adminHandler("/api/auth/local/totp/enable", routes.EnableTotp, http.MethodPost, "")
+	adminHandler("/api/auth/local/totp/disable", routes.DisableTotp, http.MethodPost, "")
+	customHandler("/api/auth/refresh", leaderOnly(routes.RefreshSession), http.MethodPost)
+	userHandler("/api/auth/me", routes.GetMe, http.MethodGet, "")
+	userHandler("/api/auth/sessions", routes.GetSessions, http.MethodGet, "")
+	adminHandler("/api/auth/logout", routes.Logout, http.MethodPost, "")
+	adminHandler("/api/auth/sessions/revoke", routes.RevokeSession, http.MethodDelete, "")
+	adminHandler("/api/auth/sessions/revoke-all", routes.RevokeAllSessions, http.MethodPost, "")
+
+	registerApiV2()
+
+	customHandler("/api/cluster/status", cluster.HandleStatus, http.MethodGet)
+	customHandler("/api/cluster/state", cluster.HandleState, http.MethodGet)
+	customHandler("/api/cluster/file", cluster.HandleFile, http.MethodGet)
 
 	http.HandleFunc("/api/connect/publickey", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/plain")
@@ -123,22 +211,6 @@ func startHttpServer() {
 	http.ListenAndServe("127.0.0.1:37421", nil)
 }
 
-func containsRoute(endpoint string) bool {
-	demoEndpoints := []string{
-		"/api/routes",
-		"/api/nodes",
-		"/api/routes/add",
-	}
-
-	for _, e := range demoEndpoints {
-		if e == endpoint {
-			return true
-		}
-	}
-
-	return false
-}
-
 func customHandler(path string, handler http.HandlerFunc, method string) {
 	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != method {
@@ -152,59 +224,43 @@ func customHandler(path string, handler http.HandlerFunc, method string) {
 	})
 }
 
-func adminHandler(path string, handler http.HandlerFunc, method string) {
-	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte(`{"message": "Method not allowed"}`))
-			return
-		}
-
-		authorization := r.Header.Get("Authorization")
-		if authorization == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"message": "Unauthorized"}`))
+// leaderOnly redirects requests that change state to the cluster leader
+func leaderOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cluster.IsLeader() {
+			cluster.RedirectToLeader(w, r)
 			return
 		}
 
-		token := authorization[7:]
-		claims, err := jwt.ValidateToken(token)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"message": "Unauthorized"}`))
-			return
-		}
+		handler(w, r)
+	}
+}
 
-		if claims["role"] != "admin" {
-			authorized := false
-
-			if config.GetMode() != "demo" {
-				authorized = false
-			} else {
-				if !containsRoute(r.URL.Path) {
-					authorized = false
-				} else {
-					authorized = true
-				}
-			}
+// withProvider serves the provider routes under the paths of the
+// former Discord-only sign-in
+func withProvider(provider string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("provider", provider)
+		handler(w, r)
+	}
+}
 
-			if !authorized {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte(`{"message": "Unauthorized"}`))
-				return
-			}
-		}
+// adminHandler registers an endpoint that changes state, every call is
+// audited and followers redirect it to the cluster leader
+func adminHandler(path string, handler http.HandlerFunc, method string, permission rbac.Permission) {
+	action := strings.ReplaceAll(strings.TrimPrefix(path, "/api/"), "/", ".")
+	http.HandleFunc(path, requirePermission(leaderOnly(routes.Audited(action, handler)), method, permission))
+}
 
-		handler(w, r)
-	})
+func userHandler(path string, handler http.HandlerFunc, method string, permission rbac.Permission) {
+	http.HandleFunc(path, requirePermission(handler, method, permission))
 }
 
-func userHandler(path string, handler http.HandlerFunc, method string) {
-	http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+// requirePermission only lets callers through whose role grants permission,
+// an empty permission admits every authenticated caller. Handlers find the
+// caller with rbac.FromContext.
+func requirePermission(handler http.HandlerFunc, method string, permission rbac.Permission) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != method {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -212,41 +268,23 @@ func userHandler(path string, handler http.HandlerFunc, method string) {
 			return
 		}
 
-		authorization := r.Header.Get("Authorization")
-		if authorization == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"message": "Unauthorized"}`))
-			return
-		}
-
-		token := authorization[7:]
-		claims, err := jwt.ValidateToken(token)
-		if err != nil {
+		principal, ok := authenticate(r)
+		if !ok {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte(`{"message": "Unauthorized"}`))
 			return
 		}
 
-		if claims["role"] == "" {
+		if permission != "" && !principal.Has(permission) {
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"message": "Unauthorized"}`))
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message": "Forbidden", "permission": "` + string(permission) + `"}`))
 			return
 		}
 
-		if config.GetMode() == "demo" {
-			if !containsRoute(r.URL.Path) {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				w.Write([]byte(`{"message": "Unauthorized"}`))
-				return
-			}
-		}
-
-		handler(w, r)
-	})
+		handler(w, r.WithContext(rbac.WithPrincipal(r.Context(), principal)))
+	}
 }
 
 func startServer() {
@@ -268,16 +306,22 @@ func startServer() {
 
 func handleConnection(conn *protocol.Conn) {
 	key := conn.RemoteAddr().String()
+	var connId uint64            // registered once the node said hello
+	var disconnectedAt time.Time // of the previous connection of the node
 	defer func() {
 		_ = conn.Close()
 
-		_, _, ok := utils.FindClient(key)
-		if !ok {
+		// a connection the node replaced leaves the state of the new one alone
+		if connId == 0 || !utils.RemoveClient(key, connId) {
 			return
 		}
 
-		log.Printf("Node %s.%s disconnected at %s\n", key, config.GetWiredHost(), time.Now().Format("15:04:05"))
-		utils.RemoveClient(key)
+		forgetNodeAssets(key)
+		forgetPushedRoutes(key)
+		nodeDisconnected(key)
+		events.Publish("node.disconnected", map[string]string{"id": key}, rbac.NodesRead)
+
+		slog.Info("Node disconnected", "node", key)
 	}()
 
 	var pp protocol.Packet
@@ -289,174 +333,406 @@ func handleConnection(conn *protocol.Conn) {
 	var sharedSecret []byte
 	err = protocol.DecodePacket(pp.Data, &sharedSecret)
 	if err != nil {
-		log.Println("Error decoding shared secret:", err)
+		slog.Error("Error decoding shared secret", "error", err)
 		return
 	}
 
 	err = conn.EnableEncryption(sharedSecret)
 	if err != nil {
-		log.Println("Error enabling encryption:", err)
+		slog.Error("Error enabling encryption", "error", err)
 		return
 	}
 
 	err = conn.SendPacket(packet.Id_Ready, nil)
 	if err != nil {
-		log.Println("Error sending ready packet:", err)
+		slog.Error("Error sending ready packet", "error", err)
 		return
 	}
 
+	liveness := utils.NewLiveness()
+	greeted := make(chan string, 1) // id of the node once it said hello
+	done := make(chan struct{})
+	defer close(done)
+	go heartbeat(conn, liveness, greeted, done)
+
 	for {
 		var pp protocol.Packet
 		err := pp.Read(conn)
 		if err != nil {
-			if errors.Is(err, io.EOF) || strings.Contains(err.Error(), "connection reset by peer") {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || strings.Contains(err.Error(), "connection reset by peer") {
 				return
 			}
 
-			// log.Println("Error reading packet:", err)
+			// slog.Error("Error reading packet", "error", err)
 			continue
 		}
 
+		liveness.Received()
+
 		// log.Println("Received packet:", pp.ID)
 		// log.Println("Data:", string(pp.Data))
 
+		// a peer is only a node once it said hello, anything else it
+		// sends before would act on the state of an unknown node
+		if connId == 0 && pp.ID != packet.Id_Hello && pp.ID != packet.Id_Ping && pp.ID != packet.Id_Pong {
+			slog.Warn("Closing connection that sent a packet before hello", "address", conn.RemoteAddr().String(), "packet", int(pp.ID))
+			return
+		}
+
 		switch pp.ID {
 		case packet.Id_Hello:
-			log.Printf("Received hello packet at %s\n", time.Now().Format("15:04:05"))
+			slog.Debug("Received hello packet", "address", conn.RemoteAddr().String())
+			if connId != 0 {
+				slog.Warn("Node sent hello twice, ignoring it", "node", key)
+				continue
+			}
+
 			var hello packet.Hello
 			err := protocol.DecodePacket(pp.Data, &hello)
 			if err != nil {
-				log.Println("Error decoding hello packet:", err)
+				slog.Error("Error decoding hello packet", "error", err)
 				continue
 			}
 
 			key = hello.Key
-			connectingNode, ok := config.GetNode(key)
+			connectingNode, ok, err := sqlite.GetNode(key)
+			if err != nil {
+				slog.Error("Error looking up node", "error", err)
+				return
+			}
+
 			if !ok {
-				log.Printf("Unknown node %s tried to connect\n", fmt.Sprintf("%s.%s", key, config.GetWiredHost()))
+				slog.Warn("Unknown node tried to connect", "node", key, "address", conn.RemoteAddr().String())
 				return
 			}
 
 			if hello.Passphrase != connectingNode.Passphrase {
-				log.Printf("Node %s tried to connect with invalid passphrase\n", fmt.Sprintf("%s.%s", key, config.GetWiredHost()))
+				slog.Warn("Node tried to connect with invalid passphrase", "node", key, "address", conn.RemoteAddr().String())
 				return
 			}
 
-			log.Printf("Client %s.%s connected with version %s (%s)\n", hello.Key, config.GetWiredHost(), hello.Version, hello.Arch)
+			// nodes older than the platform registry only ran on linux
+			if hello.OS == "" {
+				hello.OS = "linux"
+			}
+
+			platform := utils.Platform(hello.OS, hello.Arch, hello.Variant)
+			if _, _, _, ok := utils.ParsePlatform(platform); !ok {
+				slog.Warn("Node tried to connect with invalid platform", "node", key, "platform", platform)
+				return
+			}
+
+			slog.Info("Node connected", "node", key, "version", hello.Version, "platform", platform, "address", conn.RemoteAddr().String())
+
+			err = sqlite.SetNodeLastConnection(key, time.Now().Unix())
+			if err != nil {
+				slog.Error("Error updating last connection", "error", err)
+			}
 
-			// add client to clients map
-			utils.AddClient(hello.Key, *conn, utils.Node{
-				Key:  hello.Key,
-				Arch: hello.Arch,
+			now := time.Now()
+			id, previous, replaced := utils.AddClient(key, conn, utils.Node{
+				Key:         key,
+				Version:     hello.Version,
+				Arch:        hello.Arch,
+				OS:          hello.OS,
+				Platform:    platform,
+				Address:     conn.RemoteAddr().String(),
+				ConnectedAt: now,
+				LastPing:    now,
+				Liveness:    liveness,
+				RouteDeltas: hello.RouteDeltas,
 			})
+			connId = id
+			select {
+			case greeted <- key:
+			default:
+			}
 
-			if string(hello.Hash) != config.GetCurrentNodeHash(hello.Arch) {
-				log.Println("Node hash mismatch, sending update packet")
-				sendBinaryUpdate(*conn, "updates")
+			// the node reconnected before its old connection was noticed
+			// to be dead, e.g. after a restart
+			if replaced {
+				slog.Warn("Node connected again, closing its previous connection", "node", key, "address", conn.RemoteAddr().String(), "previous_address", previous.Data.Address)
+				_ = previous.Conn.Close()
 			}
 
-			routes := config.GetRoutes()
+			disconnectedAt = nodeConnected(key)
+			upToDate := string(hello.Hash) == config.GetCurrentNodeHash(platform)
+			events.Publish("node.connected", map[string]any{
+				"id":         key,
+				"address":    conn.RemoteAddr().String(),
+				"version":    hello.Version,
+				"platform":   platform,
+				"up_to_date": upToDate,
+			}, rbac.NodesRead)
+
+			if !upToDate {
+				slog.Info("Node hash mismatch, sending update packet", "node", key)
+				sendBinaryUpdate(key, conn, "updates")
+			}
 
 			// send routes packet
-			err = conn.SendPacket(packet.Id_Routes, packet.Routes{
-				Routes: routes,
-			})
+			err = sendAllRoutes(conn, key)
+			if err != nil {
+				slog.Error("Error sending routes packet", "error", err)
+				continue
+			}
+		case packet.Id_RoutesResync:
+			var resync packet.RoutesResync
+			err := protocol.DecodePacket(pp.Data, &resync)
+			if err != nil {
+				slog.Error("Error decoding routes resync packet", "error", err)
+				continue
+			}
 
+			slog.Info("Node requested all routes", "node", key, "generation", resync.Generation)
+
+			err = sendAllRoutes(conn, key)
+			if err != nil {
+				slog.Error("Error sending routes packet", "error", err)
+				continue
+			}
+		case packet.Id_AssetReport:
+			var report packet.AssetReport
+			err := protocol.DecodePacket(pp.Data, &report)
 			if err != nil {
-				log.Println("Error sending routes packet:", err)
+				slog.Error("Error decoding asset report packet", "error", err)
 				continue
 			}
+
+			handleAssetReport(key, conn, report)
 		case packet.Id_Ping:
-			err = conn.SendPacket(packet.Id_Pong, nil)
+			utils.TouchClient(key, connId)
+
+			err = conn.SendPacket(packet.Id_Pong, packet.PongFor(pp.Data))
 			if err != nil {
-				log.Println("Error sending pong packet:", err)
+				slog.Error("Error sending pong packet", "error", err)
 				continue
 			}
 
 			// log.Println("Sent pong")
+		case packet.Id_Pong:
+			var hb packet.Heartbeat
+			if len(pp.Data) > 0 && protocol.DecodePacket(pp.Data, &hb) == nil {
+				liveness.Pong(hb.SentAt)
+			}
 		case packet.Id_PlayerAdd:
 			var player protocol.Player
 			err := protocol.DecodePacket(pp.Data, &player)
 			if err != nil {
-				log.Println("Error decoding player add packet:", err)
+				slog.Error("Error decoding player add packet", "error", err)
 				continue
 			}
 
+			// players belong to the node of the connection, whatever it claims
+			player.NodeId = key
 			utils.AddPlayer(player)
-			log.Printf("Player %s (%s) joined %s with protocol version %d on %s.%s\n", player.Name, player.UUID, player.PlayingOn, player.ProtocolVersion, player.NodeId, config.GetWiredHost())
+			publishPlayerEvent("player.joined", player)
+			slog.Info("Player joined", playerAttrs(player)...)
 		case packet.Id_PlayerRemove:
 			var player protocol.Player
 			err := protocol.DecodePacket(pp.Data, &player)
 			if err != nil {
-				log.Println("Error decoding player remove packet:", err)
+				slog.Error("Error decoding player remove packet", "error", err)
 				continue
 			}
 
-			utils.RemovePlayer(player)
-			log.Printf("Player %s (%s) left %s and played for %s on %s.%s\n", player.Name, player.UUID, player.PlayingOn, calculatePlaytime(player), player.NodeId, config.GetWiredHost())
-		}
-	}
-}
+			player.NodeId = key
+
+			// a leave the snapshot of a reconnect already accounted for
+			if !utils.RemovePlayer(player) {
+				continue
+			}
 
-func calculatePlaytime(player protocol.Player) string {
-	// hours, minutes, seconds
-	var playtime [3]int
+			recordPlayerSession(player, time.Now())
+			publishPlayerEvent("player.left", player)
+			slog.Info("Player left", append(playerAttrs(player), "playtime", playtime(player))...)
+		case packet.Id_Telemetry:
+			var report packet.Telemetry
+			err := protocol.DecodePacket(pp.Data, &report)
+			if err != nil {
+				slog.Error("Error decoding telemetry packet", "error", err)
+				continue
+			}
 
-	// calculate playtime
-	playtime[0] = int(time.Now().Unix()-player.JoinedAt) / 3600
-	playtime[1] = int(time.Now().Unix()-player.JoinedAt) / 60 % 60
-	playtime[2] = int(time.Now().Unix()-player.JoinedAt) % 60
+			utils.RecordTelemetry(key, report)
+		case packet.Id_Logs:
+			var batch packet.Logs
+			err := protocol.DecodePacket(pp.Data, &batch)
+			if err != nil {
+				slog.Error("Error decoding logs packet", "error", err)
+				continue
+			}
 
-	return fmt.Sprintf("%d hours, %d minutes, %d seconds", playtime[0], playtime[1], playtime[2])
+			logs.Append(key, batch.Entries, batch.Dropped)
+		case packet.Id_PlayerSnapshot:
+			var snapshot packet.PlayerSnapshot
+			err := protocol.DecodePacket(pp.Data, &snapshot)
+			if err != nil {
+				slog.Error("Error decoding player snapshot packet", "error", err)
+				continue
+			}
+
+			handlePlayerSnapshot(key, snapshot, disconnectedAt)
+			disconnectedAt = time.Time{}
+		}
+	}
 }
 
-func routeUpdater() {
-	for {
-		<-routes.SignalChannel
+// heartbeat pings a node every interval and closes its connection once
+// nothing was received from it for MissedHeartbeats intervals, which ends
+// the read loop of a half-open connection. Once the node said hello its
+// changes between healthy, degraded and dead are published.
+func heartbeat(conn *protocol.Conn, liveness *utils.Liveness, greeted <-chan string, done <-chan struct{}) {
+	ticker := time.NewTicker(utils.HeartbeatInterval)
+	defer ticker.Stop()
 
-		log.Println("Sending routes packet to all clients")
+	var nodeId string
+	state := utils.Healthy
+	for {
+		select {
+		case <-done:
+			return
+		case nodeId = <-greeted:
+		case <-ticker.C:
+			current := liveness.State()
+			if current != state && nodeId != "" {
+				publishHealth(nodeId, state, current, liveness.Stats())
+			}
+			state = current
 
-		pData, err := protocol.MarshalPacket(packet.Id_Routes, packet.Routes{
-			Routes: config.GetRoutes(),
-		})
-		if err != nil {
-			log.Println("Error encoding routes packet:", err)
-			continue
-		}
+			if current == utils.Dead {
+				slog.Warn("Node missed heartbeats, closing the connection", "address", conn.RemoteAddr().String(), "missed", utils.MissedHeartbeats)
+				conn.Close()
+				return
+			}
 
-		clients := utils.GetClients()
-		for _, client := range clients {
-			log.Println("Sending routes packet to", client.Address)
-			_, err = client.Write(pData)
+			// nodes before heartbeats ignore the ping and keep pinging us
+			err := conn.SendPacket(packet.Id_Ping, packet.Heartbeat{SentAt: time.Now().UnixNano()})
 			if err != nil {
-				log.Println("Error sending routes packet to", client.Address, ":", err)
-				continue
+				slog.Error("Error sending ping packet", "error", err)
 			}
 		}
 	}
 }
 
-func sendBinaryUpdate(client protocol.Conn, _folder string) {
-	log.Println("Sending update packet to", client.Address)
+func publishHealth(nodeId string, previous, current utils.LivenessState, stats utils.LivenessStats) {
+	level := slog.LevelWarn
+	if current == utils.Healthy {
+		level = slog.LevelInfo
+	}
+
+	silent := time.Since(stats.LastReceived).Round(time.Second)
+	slog.Log(context.Background(), level, "Node health changed", "node", nodeId, "health", string(current), "previous", string(previous), "silent", silent.String())
+
+	events.Publish("node.health", map[string]any{
+		"id":            nodeId,
+		"health":        current,
+		"previous":      previous,
+		"last_received": stats.LastReceived.Unix(),
+	}, rbac.NodesRead)
+}
+
+// publishPlayerEvent shows a player event to the owner of the route the
+// player connected through
+func publishPlayerEvent(eventType string, player protocol.Player) {
+	owners := []string{}
+	route, ok, err := sqlite.GetRouteByProxyDomain(player.ProxyUsed)
+	if err != nil {
+		slog.Error("Error looking up route of player", "error", err)
+	}
+
+	if ok {
+		owners = append(owners, route.Owner)
+	}
 
-	log.Println(client.RemoteAddr())
-	_, data, ok := utils.FindClient(client.RemoteAddr().String())
+	events.Publish(eventType, map[string]any{
+		"name":             player.Name,
+		"uuid":             player.UUID,
+		"node":             player.NodeId,
+		"proxy_domain":     player.ProxyUsed,
+		"server":           player.PlayingOn,
+		"protocol_version": player.ProtocolVersion,
+		"joined_at":        player.JoinedAt,
+	}, rbac.PlayersRead, owners...)
+}
+
+// playerAttrs describes a player in log entries
+func playerAttrs(player protocol.Player) []any {
+	return []any{
+		"player", player.Name,
+		"uuid", player.UUID,
+		"node", player.NodeId,
+		"proxy_domain", player.ProxyUsed,
+		"server", player.PlayingOn,
+		"client_ip", player.ClientIP,
+		"protocol_version", player.ProtocolVersion,
+	}
+}
+
+func playtime(player protocol.Player) time.Duration {
+	return time.Since(time.Unix(player.JoinedAt, 0)).Round(time.Second)
+}
+
+func sendBinaryUpdate(key string, client *protocol.Conn, _folder string) {
+	_, data, ok := utils.FindClient(key)
+	if !ok {
+		slog.Warn("Node is not connected", "node", key)
+		return
+	}
+
+	release, _, ok := config.ResolveNodeRelease(data.Platform)
 	if !ok {
-		log.Println("Client", client.Address, "not found")
+		slog.Warn("No release registered for platform", "node", key, "platform", data.Platform)
+		publishUpgrade(data, "", "failed", "no release registered for the platform")
 		return
 	}
 
-	log.Println("Sending update packet to", data.Key, "with arch", data.Arch)
+	slog.Info("Sending update packet", "node", key, "platform", data.Platform, "release", release)
 
-	filename := _folder + "/wirednode-" + data.Arch
+	filename := releaseFile(_folder, release)
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		log.Println("File", filename, "does not exist")
+		slog.Error("Release binary does not exist", "node", key, "file", filename)
+		publishUpgrade(data, release, "failed", "release binary is missing")
 		return
 	}
 
+	publishUpgrade(data, release, "sending", "")
 	err := client.SendFile("upgrade", filename, packet.Id_BinaryData, packet.Id_BinaryEnd)
 	if err != nil {
-		log.Println("Error sending update packet to", client.Address, ":", err)
+		slog.Error("Error sending update packet", "node", key, "error", err)
+		publishUpgrade(data, release, "failed", err.Error())
 		return
 	}
+
+	// the node restarts into the release and reconnects up to date
+	publishUpgrade(data, release, "sent", "")
+}
+
+func publishUpgrade(node utils.Node, release, state, reason string) {
+	events.Publish("node.upgrade", map[string]string{
+		"id":       node.Key,
+		"platform": node.Platform,
+		"release":  release,
+		"state":    state, // sending, sent or failed
+		"error":    reason,
+	}, rbac.NodesRead)
+}
+
+// releaseFile returns the path of the binary for a release, preferring
+// the platform file name over the per-arch name used by older masters
+func releaseFile(folder string, platform string) string {
+	filename := folder + "/" + utils.PlatformFileName(platform)
+	if _, err := os.Stat(filename); err == nil {
+		return filename
+	}
+
+	goos, goarch, variant, _ := utils.ParsePlatform(platform)
+	if goos == "linux" && variant == "" {
+		legacy := folder + "/wirednode-" + goarch
+		if _, err := os.Stat(legacy); err == nil {
+			return legacy
+		}
+	}
+
+	return filename
 }
@@ -0,0 +1,88 @@
+package master
+
+import (
+	"log/slog"
+	"time"
+	"wiredmaster/routes"
+
+	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/sqlite"
+)
+
+const (
+	analyticsInterval = time.Hour
+
+	// nodes reconnect after a restart and report who is online, before
+	// that open sessions would be missing from the days rolled up
+	analyticsStartDelay = 5 * time.Minute
+)
+
+// recordPlayerSession stores the visit of a player that left at leftAt
+func recordPlayerSession(player protocol.Player, leftAt time.Time) {
+	route, _, err := sqlite.GetRouteByProxyDomain(player.ProxyUsed)
+	if err != nil {
+		slog.Error("Error looking up route of player", "error", err)
+	}
+
+	err = sqlite.AddPlayerSession(sqlite.PlayerSession{
+		UUID:            player.UUID,
+		Name:            player.Name,
+		RouteId:         route.RouteId,
+		ProxyDomain:     player.ProxyUsed,
+		NodeId:          player.NodeId,
+		ClientIP:        player.ClientIP,
+		ProtocolVersion: player.ProtocolVersion,
+		JoinedAt:        player.JoinedAt,
+		LeftAt:          leftAt.Unix(),
+		BytesToServer:   player.BytesToServer,
+		BytesToClient:   player.BytesToClient,
+	})
+	if err != nil {
+		slog.Error("Error recording player session", "error", err)
+	}
+}
+
+// analyticsMaintainer rolls up finished days and drops player history past
+// its retention. Every master keeps the sessions of the nodes connected to it.
+func analyticsMaintainer() {
+	time.Sleep(analyticsStartDelay)
+
+	for {
+		maintainAnalytics(time.Now())
+		time.Sleep(analyticsInterval)
+	}
+}
+
+func maintainAnalytics(now time.Time) {
+	open, err := routes.OpenPlayerSessions()
+	if err != nil {
+		slog.Error("Error listing online players", "error", err)
+		return
+	}
+
+	// roll up before pruning, so no session is dropped unaccounted
+	err = sqlite.RollupPlayerStats(now, open)
+	if err != nil {
+		slog.Error("Error rolling up player statistics", "error", err)
+		return
+	}
+
+	retention := config.GetAnalytics()
+	if retention.SessionRetentionDays > 0 {
+		cutoff := now.AddDate(0, 0, -retention.SessionRetentionDays).Unix()
+		pruned, err := sqlite.PrunePlayerSessions(cutoff)
+		if err != nil {
+			slog.Error("Error pruning player sessions", "error", err)
+		} else if pruned > 0 {
+			slog.Info("Pruned player sessions", "sessions", pruned)
+		}
+	}
+
+	if retention.StatsRetentionDays > 0 {
+		err = sqlite.PrunePlayerStats(now.AddDate(0, 0, -retention.StatsRetentionDays))
+		if err != nil {
+			slog.Error("Error pruning player statistics", "error", err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package master
+
+import (
+	"testing"
+	"time"
+
+	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+func TestRollupCountsSessionsAcrossMidnight(t *testing.T) {
+	setupMaster(t, mockIssuer(t).URL)
+
+	day := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	err := sqlite.AddPlayerSession(sqlite.PlayerSession{UUID: "early", Name: "early", JoinedAt: day.Add(10 * time.Hour).Unix(), LeftAt: day.Add(11 * time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// online since the evening when the day is rolled up after midnight
+	late := protocol.Player{Name: "late", UUID: "late", NodeId: "node-1", ProxyUsed: "survival.wired.test", JoinedAt: day.Add(23 * time.Hour).Unix()}
+	utils.AddPlayer(late)
+	t.Cleanup(func() { utils.RemovePlayer(late) })
+
+	maintainAnalytics(day.Add(24*time.Hour + 30*time.Minute))
+
+	stats, err := sqlite.GetPlayerStats(sqlite.AllRoutes, "2026-10-17", "2026-10-17")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := sqlite.PlayerStats{Day: "2026-10-17", RouteId: sqlite.AllRoutes, UniquePlayers: 2, Sessions: 1, Playtime: 3600, PeakConcurrency: 1}
+	if len(stats) != 1 || stats[0] != want {
+		t.Fatalf("got %+v, want %+v", stats, want)
+	}
+
+	// the session ends the next day and is counted there
+	utils.RemovePlayer(late)
+	err = sqlite.AddPlayerSession(sqlite.PlayerSession{UUID: "late", Name: "late", JoinedAt: late.JoinedAt, LeftAt: day.Add(25 * time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maintainAnalytics(day.Add(48*time.Hour + 30*time.Minute))
+
+	stats, err = sqlite.GetPlayerStats(sqlite.AllRoutes, "2026-10-18", "2026-10-18")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = sqlite.PlayerStats{Day: "2026-10-18", RouteId: sqlite.AllRoutes, UniquePlayers: 1, Sessions: 1, Playtime: 7200, PeakConcurrency: 1}
+	if len(stats) != 1 || stats[0] != want {
+		t.Fatalf("got %+v, want %+v", stats, want)
+	}
+}
@@ -0,0 +1,91 @@
+package master
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"wired.rip/wiredutils/packet"
+	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/utils"
+)
+
+// players of a node that lost its connection are kept this long, a node
+// reconnecting in time sends a snapshot that replaces them
+const playerGracePeriod = 5 * time.Minute
+
+// nodes whose players await a reconnect, by when they disconnected
+var (
+	offlineNodes    = make(map[string]time.Time)
+	offlineNodesMux = &sync.Mutex{}
+)
+
+// nodeDisconnected expires the players of a node unless it is back
+// within the grace period
+func nodeDisconnected(nodeId string) {
+	offlineNodesMux.Lock()
+	defer offlineNodesMux.Unlock()
+
+	since := time.Now()
+	offlineNodes[nodeId] = since
+
+	time.AfterFunc(playerGracePeriod, func() {
+		offlineNodesMux.Lock()
+		if offlineNodes[nodeId] != since {
+			// reconnected, or disconnected again and timed anew
+			offlineNodesMux.Unlock()
+			return
+		}
+
+		delete(offlineNodes, nodeId)
+		offlineNodesMux.Unlock()
+
+		expired := utils.RemoveNodePlayers(nodeId)
+		if len(expired) > 0 {
+			slog.Info("Expired players of a node that did not reconnect", "node", nodeId, "players", len(expired))
+		}
+
+		for _, player := range expired {
+			playerLeft(player, since)
+		}
+	})
+}
+
+// nodeConnected stops the expiry of a node's players and returns when it
+// disconnected, zero if the players are not awaiting it
+func nodeConnected(nodeId string) time.Time {
+	offlineNodesMux.Lock()
+	defer offlineNodesMux.Unlock()
+
+	since := offlineNodes[nodeId]
+	delete(offlineNodes, nodeId)
+	return since
+}
+
+// handlePlayerSnapshot replaces the players of a node with the ones it
+// reported. Players that left while it was offline left at disconnectedAt.
+func handlePlayerSnapshot(nodeId string, snapshot packet.PlayerSnapshot, disconnectedAt time.Time) {
+	joined, left := utils.ReplaceNodePlayers(nodeId, snapshot.Players)
+
+	if disconnectedAt.IsZero() {
+		disconnectedAt = time.Now()
+	}
+
+	for _, player := range left {
+		playerLeft(player, disconnectedAt)
+	}
+
+	for _, player := range joined {
+		publishPlayerEvent("player.joined", player)
+	}
+
+	if len(joined) > 0 || len(left) > 0 {
+		slog.Info("Reconciled players of node", "node", nodeId, "joined", len(joined), "left", len(left))
+	}
+}
+
+// playerLeft records a player the node could not report the leave of
+func playerLeft(player protocol.Player, at time.Time) {
+	recordPlayerSession(player, at)
+	publishPlayerEvent("player.left", player)
+}
@@ -0,0 +1,234 @@
+package master
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wiredmaster/auth"
+	"wiredmaster/routes"
+
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+// userToken creates a user with a role granting permissions and returns
+// the id of the user and a personal API token following its role
+func userToken(t *testing.T, name string, permissions ...string) (string, string) {
+	t.Helper()
+
+	err := sqlite.SetRole(sqlite.Role{Name: name, Permissions: permissions})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := auth.CreateLocalUser(name, "correct horse battery", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := utils.NewSecret(sqlite.ApiTokenPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	err = sqlite.CreateApiToken(sqlite.ApiToken{
+		Id:        name + "-token",
+		Name:      name,
+		Kind:      sqlite.TokenPersonal,
+		Owner:     user.Id,
+		CreatedBy: user.Id,
+		Hash:      utils.HashSecret(token),
+		Scopes:    []string{"*"},
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user.Id, token
+}
+
+// callV2 serves a request to the v2 endpoint registered under method and
+// pattern, the path values of pattern are taken from values
+func callV2(t *testing.T, token, method, pattern string, values map[string]string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	for _, endpoint := range routes.V2 {
+		if endpoint.Method != method || endpoint.Path != pattern {
+			continue
+		}
+
+		path := pattern
+		for key, value := range values {
+			path = strings.ReplaceAll(path, "{"+key+"}", value)
+		}
+
+		req := httptest.NewRequest(method, "/api/v2"+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		for key, value := range values {
+			req.SetPathValue(key, value)
+		}
+
+		rec := httptest.NewRecorder()
+		serveApiV2(endpoint, rec, req)
+		return rec
+	}
+
+	t.Fatalf("no endpoint %s %s", method, pattern)
+	return nil
+}
+
+func TestUserManagerCannotAssignMoreThanHeld(t *testing.T) {
+	setupMaster(t, mockIssuer(t).URL)
+	userId, token := userToken(t, "support", "users:manage", "routes:read")
+
+	err := sqlite.SetRole(sqlite.Role{Name: "operator", Permissions: []string{"users:manage", "nodes:manage"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, role := range []string{"admin", "operator"} {
+		rec := callV2(t, token, http.MethodPatch, "/users/{id}", map[string]string{"id": userId}, `{"role": "`+role+`"}`)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("assigned role %s: %d %s", role, rec.Code, rec.Body.String())
+		}
+
+		rec = callV2(t, token, http.MethodPost, "/users", nil, `{"username": "sidekick-`+role+`", "password": "correct horse battery", "role": "`+role+`"}`)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("created a user with role %s: %d %s", role, rec.Code, rec.Body.String())
+		}
+	}
+
+	user, _, err := sqlite.GetUser(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Role != "support" {
+		t.Fatalf("role changed to %s", user.Role)
+	}
+
+	rec := callV2(t, token, http.MethodPatch, "/users/{id}", map[string]string{"id": userId}, `{"role": "viewer"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("assigning a lesser role: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUserManagerCannotGrantMoreThanHeld(t *testing.T) {
+	setupMaster(t, mockIssuer(t).URL)
+	_, token := userToken(t, "support", "users:manage", "routes:read")
+
+	for _, permissions := range []string{`["*"]`, `["routes:read", "nodes:manage"]`} {
+		for _, name := range []string{"escalated", "support"} {
+			rec := callV2(t, token, http.MethodPut, "/roles/{name}", map[string]string{"name": name}, `{"permissions": `+permissions+`}`)
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("role %s saved with %s: %d %s", name, permissions, rec.Code, rec.Body.String())
+			}
+		}
+	}
+
+	if _, ok, _ := sqlite.GetRole("escalated"); ok {
+		t.Fatal("role escalated created")
+	}
+
+	role, _, err := sqlite.GetRole("support")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(role.Permissions) != 2 {
+		t.Fatalf("role support changed to %v", role.Permissions)
+	}
+
+	rec := callV2(t, token, http.MethodPut, "/roles/{name}", map[string]string{"name": "reader"}, `{"permissions": ["routes:read"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("granting a held permission: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServiceTokenFollowsCreator(t *testing.T) {
+	setupMaster(t, mockIssuer(t).URL)
+	userId, token := userToken(t, "operator", "users:manage", "nodes:read")
+
+	rec := callV2(t, token, http.MethodPost, "/tokens", nil, `{"name": "monitoring", "kind": "service", "scopes": ["nodes:read"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating service token: %d %s", rec.Code, rec.Body.String())
+	}
+
+	var created routes.CreatedToken
+	err := json.Unmarshal(rec.Body.Bytes(), &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = callV2(t, created.Token, http.MethodGet, "/nodes", nil, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing nodes: %d %s", rec.Code, rec.Body.String())
+	}
+
+	err = sqlite.ChangeUserRole(userId, "viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = callV2(t, created.Token, http.MethodGet, "/nodes", nil, "")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("token kept the permission its creator lost: %d %s", rec.Code, rec.Body.String())
+	}
+
+	err = sqlite.DeleteUser(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = callV2(t, created.Token, http.MethodGet, "/nodes", nil, "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("token outlived its creator: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRoutePlacementNeedsNodesManage(t *testing.T) {
+	setupMaster(t, mockIssuer(t).URL)
+	_, token := userToken(t, "creator", "routes:read", "routes:write")
+
+	body := `{"server_host": "10.0.0.2", "server_port": "25565", "proxy_domain": "survival.wired.test", "proxy_port": "25565", "nodes": ["node-1"]}`
+	rec := callV2(t, token, http.MethodPost, "/routes", nil, body)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("placed a new route: %d %s", rec.Code, rec.Body.String())
+	}
+
+	body = `{"server_host": "10.0.0.2", "server_port": "25565", "proxy_domain": "survival.wired.test", "proxy_port": "25565"}`
+	rec = callV2(t, token, http.MethodPost, "/routes", nil, body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating a route: %d %s", rec.Code, rec.Body.String())
+	}
+
+	var route sqlite.Route
+	err := json.Unmarshal(rec.Body.Bytes(), &route)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, patch := range []string{`{"groups": ["eu"]}`, `{"nodes": ["node-1"]}`} {
+		rec = callV2(t, token, http.MethodPatch, "/routes/{id}", map[string]string{"id": route.RouteId}, patch)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("placed a route with %s: %d %s", patch, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec = callV2(t, token, http.MethodPatch, "/routes/{id}", map[string]string{"id": route.RouteId}, `{"name": "Survival", "nodes": []}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("changing a route without its placement: %d %s", rec.Code, rec.Body.String())
+	}
+
+	_, token = userToken(t, "operator", "routes:read", "routes:write", "nodes:manage")
+	rec = callV2(t, token, http.MethodPost, "/routes", nil, `{"server_host": "10.0.0.3", "server_port": "25565", "proxy_domain": "creative.wired.test", "proxy_port": "25565", "groups": ["eu"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("placing a route with nodes:manage: %d %s", rec.Code, rec.Body.String())
+	}
+}
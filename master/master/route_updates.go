@@ -0,0 +1,186 @@
+package master
+
+import (
+	"log/slog"
+	"reflect"
+	"sync"
+	"wiredmaster/routes"
+
+	"wired.rip/wiredutils/packet"
+	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+// pushedRoutes is the route set last sent to a node, deltas are
+// computed against it
+type pushedRoutes struct {
+	routes     map[string]protocol.Route
+	generation uint64
+}
+
+var (
+	pushed    = make(map[string]pushedRoutes) // by node id
+	pushedMux = &sync.Mutex{}
+)
+
+func routeUpdater() {
+	for {
+		<-routes.SignalChannel
+		pushRoutes()
+	}
+}
+
+// pushRoutes sends every node the changes of its routes since the last
+// push, nodes that do not understand deltas get all their routes. Nodes
+// without a change are skipped. A node ahead of this master, e.g. after a
+// failover to a master that missed changes, is told to replace its routes.
+// Packets are queued without waiting for the node, a node that does not
+// keep up only misses its own push and gets all routes on the next.
+func pushRoutes() {
+	pushedMux.Lock()
+	defer pushedMux.Unlock()
+
+	stored, generation, err := sqlite.GetRoutesWithGeneration()
+	if err != nil {
+		slog.Error("Error reading routes", "error", err)
+		return
+	}
+
+	nodes, err := sqlite.GetNodes()
+	if err != nil {
+		slog.Error("Error reading nodes", "error", err)
+		return
+	}
+
+	byId := make(map[string]sqlite.Node, len(nodes))
+	for _, node := range nodes {
+		byId[node.Id] = node
+	}
+
+	// signing is the slow part, nodes serving the same routes share it
+	signatures := make(map[string][]byte)
+
+	for _, client := range utils.ListClients() {
+		last, ok := pushed[client.Key]
+
+		// signals of changes an earlier push already covered
+		if ok && last.generation == generation {
+			continue
+		}
+
+		full := packet.Routes{
+			Routes:     sqlite.WireRoutes(stored, byId[client.Key]),
+			Generation: generation,
+		}
+		packet.SortRoutes(full.Routes)
+
+		var delta *packet.RoutesDelta
+		if ok && generation > last.generation {
+			delta = diffRoutes(last.routes, full.Routes)
+
+			// nothing this node serves changed, keep its generation so
+			// the next delta still starts where the node is
+			if len(delta.Upsert) == 0 && len(delta.Remove) == 0 {
+				continue
+			}
+		}
+
+		digest := string(full.Digest())
+		full.Signature = signatures[digest]
+		if full.Signature == nil {
+			full, err = signRoutes(full.Routes, generation)
+			if err != nil {
+				slog.Error("Error signing routes packet", "error", err)
+				return
+			}
+
+			signatures[digest] = full.Signature
+		}
+
+		full.Resync = !ok || generation <= last.generation
+
+		id, payload := packet.Id_Routes, any(full)
+		if delta != nil && client.Data.RouteDeltas {
+			delta.From = last.generation
+			delta.Generation = generation
+			delta.Signature = full.Signature
+			id, payload = packet.Id_RoutesDelta, delta
+
+			slog.Info("Sending routes delta", "node", client.Key, "changed", len(delta.Upsert), "removed", len(delta.Remove), "generation", generation)
+		} else {
+			slog.Info("Sending routes", "node", client.Key, "routes", len(full.Routes), "generation", generation)
+		}
+
+		err = client.Conn.QueuePacket(id, payload)
+		if err != nil {
+			slog.Error("Error sending routes packet", "node", client.Key, "error", err)
+			delete(pushed, client.Key)
+			continue
+		}
+
+		pushed[client.Key] = pushedRoutes{routesById(full.Routes), generation}
+	}
+}
+
+// sendAllRoutes sends a node every route placed on it, the base of the
+// deltas that follow. They replace the routes of the node whatever it
+// served before.
+func sendAllRoutes(conn *protocol.Conn, nodeId string) error {
+	pushedMux.Lock()
+	defer pushedMux.Unlock()
+
+	full, err := currentRoutes(nodeId)
+	if err != nil {
+		return err
+	}
+
+	full.Resync = true
+
+	err = conn.QueuePacket(packet.Id_Routes, full)
+	if err != nil {
+		return err
+	}
+
+	pushed[nodeId] = pushedRoutes{routesById(full.Routes), full.Generation}
+	return nil
+}
+
+// forgetPushedRoutes drops the state of a disconnected node
+func forgetPushedRoutes(nodeId string) {
+	pushedMux.Lock()
+	defer pushedMux.Unlock()
+
+	delete(pushed, nodeId)
+}
+
+func routesById(list []protocol.Route) map[string]protocol.Route {
+	byId := make(map[string]protocol.Route, len(list))
+	for _, route := range list {
+		byId[route.RouteId] = route
+	}
+
+	return byId
+}
+
+// diffRoutes returns the changes from before to after
+func diffRoutes(before map[string]protocol.Route, after []protocol.Route) *packet.RoutesDelta {
+	delta := &packet.RoutesDelta{}
+	seen := make(map[string]bool, len(after))
+	for _, route := range after {
+		seen[route.RouteId] = true
+
+		previous, ok := before[route.RouteId]
+		if !ok || !reflect.DeepEqual(previous, route) {
+			delta.Upsert = append(delta.Upsert, route)
+		}
+	}
+
+	for routeId := range before {
+		if !seen[routeId] {
+			delta.Remove = append(delta.Remove, routeId)
+		}
+	}
+
+	return delta
+}
@@ -0,0 +1,75 @@
+package master
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"testing"
+
+	"wired.rip/wiredutils/packet"
+	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/utils"
+)
+
+// pushedNode registers a node connection the master pushes routes to and
+// returns the end of the node
+func pushedNode(t *testing.T, key string) *protocol.Conn {
+	t.Helper()
+
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := wiredKey
+	wiredKey = signingKey
+	t.Cleanup(func() { wiredKey = previous })
+
+	a, b := net.Pipe()
+	conn := protocol.NewConn(a, nil, nil)
+	node := protocol.NewConn(b, nil, nil)
+	t.Cleanup(func() {
+		conn.Close()
+		node.Close()
+	})
+
+	connId, _, _ := utils.AddClient(key, conn, utils.Node{Key: key, RouteDeltas: true})
+	t.Cleanup(func() {
+		utils.RemoveClient(key, connId)
+		forgetPushedRoutes(key)
+	})
+
+	return node
+}
+
+func TestPushResyncsNodeAheadOfMaster(t *testing.T) {
+	setupMaster(t, mockIssuer(t).URL)
+	node := pushedNode(t, "node-1")
+
+	// the node served a later generation of a master that went down
+	pushedMux.Lock()
+	pushed["node-1"] = pushedRoutes{generation: 42}
+	pushedMux.Unlock()
+
+	go pushRoutes()
+
+	var p protocol.Packet
+	err := p.Read(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.ID != packet.Id_Routes {
+		t.Fatalf("got packet %d", p.ID)
+	}
+
+	var routes packet.Routes
+	err = protocol.DecodePacket(p.Data, &routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !routes.Resync || routes.Generation != 0 {
+		t.Fatalf("node ahead got generation %d without resync", routes.Generation)
+	}
+}
@@ -0,0 +1,185 @@
+package master
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"wiredmaster/api"
+	"wiredmaster/auth"
+	"wiredmaster/routes"
+
+	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/jwt"
+	"wired.rip/wiredutils/sqlite"
+)
+
+// mockIssuer is an OIDC provider that signs everyone in as the same account
+func mockIssuer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "provider-token"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"sub": "42", "preferred_username": "newcomer"})
+	})
+
+	return server
+}
+
+// setupMaster runs the master from an empty directory with a single
+// OIDC provider
+func setupMaster(t *testing.T, issuer string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	data, err := json.Marshal(config.SystemConfig{
+		WiredHost:     "wired.test",
+		JwtSigningKey: "test-signing-key",
+		Auth: config.AuthConfig{
+			PublicUrl: "https://master.wired.test",
+			Providers: []config.AuthProvider{{Id: "sso", Type: "oidc", Name: "SSO", ClientId: "wired", ClientSecret: "secret", Issuer: issuer}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile("config.json", data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Init()
+	jwt.Init()
+	sqlite.Init()
+	t.Cleanup(sqlite.Close)
+}
+
+// signUp goes through the OAuth flow of provider sso and returns the
+// access token of the new user
+func signUp(t *testing.T) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oauth/sso", nil)
+	req.SetPathValue("provider", "sso")
+	rec := httptest.NewRecorder()
+	routes.AuthOAuth(rec, req)
+
+	authURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || rec.Code != http.StatusSeeOther {
+		t.Fatalf("starting sign-in: %d %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/auth/oauth/sso/callback?code=code&state="+url.QueryEscape(authURL.Query().Get("state")), nil)
+	req.SetPathValue("provider", "sso")
+	rec = httptest.NewRecorder()
+	routes.AuthOAuthCallback(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("finishing sign-in: %d %s", rec.Code, rec.Body.String())
+	}
+
+	redirect, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := redirect.Query().Get("token")
+	if token == "" {
+		t.Fatalf("no access token in %s", redirect)
+	}
+
+	if redirect.Query().Has("refresh_token") {
+		t.Fatalf("refresh token in %s", redirect)
+	}
+
+	return token
+}
+
+func TestOAuthSignupCannotWriteRoutes(t *testing.T) {
+	setupMaster(t, mockIssuer(t).URL)
+	token := signUp(t)
+
+	var create api.Endpoint
+	for _, endpoint := range routes.V2 {
+		if endpoint.Method == http.MethodPost && endpoint.Path == "/routes" {
+			create = endpoint
+		}
+	}
+
+	body := `{"route_id": "survival", "server_host": "10.0.0.2", "server_port": "25565", "proxy_domain": "survival.wired.test", "proxy_port": "25565"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/routes", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	serveApiV2(create, rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("new user created a route: %d %s", rec.Code, rec.Body.String())
+	}
+
+	stored, err := sqlite.GetRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stored) != 0 {
+		t.Fatalf("%d routes stored", len(stored))
+	}
+}
+
+func TestOAuthCallbackRejectsForeignState(t *testing.T) {
+	setupMaster(t, mockIssuer(t).URL)
+
+	// a state of a sign-in with another provider
+	state, _, err := auth.Begin("discord", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{"forged", state} {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/oauth/sso/callback?code=code&state="+url.QueryEscape(s), nil)
+		req.SetPathValue("provider", "sso")
+		rec := httptest.NewRecorder()
+		routes.AuthOAuthCallback(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("state %q accepted: %d %s", s, rec.Code, rec.Body.String())
+		}
+	}
+
+	users, err := sqlite.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 0 {
+		t.Fatalf("%d users signed up", len(users))
+	}
+}
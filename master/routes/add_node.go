@@ -2,29 +2,29 @@ package routes
 
 import (
 	"net/http"
-
-	"wired.rip/wiredutils/config"
+	"wiredmaster/api"
 )
 
+// AddNode is the query string form of POST /api/v2/nodes
 func AddNode(w http.ResponseWriter, r *http.Request) {
-	// query params
-	nodeId := r.URL.Query().Get("node_id")
-	nodePassphrase := r.URL.Query().Get("node_passphrase")
+	in := NodeInput{
+		Id:         r.URL.Query().Get("node_id"),
+		Passphrase: r.URL.Query().Get("node_passphrase"),
+	}
 
-	// Create the node
-	node := config.Node{
-		Id:             nodeId,
-		Passphrase:     nodePassphrase,
-		LastConnection: 0,
+	err := api.Check(&in)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
 	}
 
-	// Add the node
-	status := config.AddNode(node)
-	if status != http.StatusOK {
-		http.Error(w, "Failed to add node", status)
+	_, err = createNode(r, in)
+	if err != nil {
+		writeLegacyError(w, err)
 		return
 	}
 
-	// Return success
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Node added"}`))
 }
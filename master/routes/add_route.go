@@ -5,48 +5,43 @@ import (
 	"encoding/hex"
 	"log"
 	"net/http"
+	"wiredmaster/api"
 
-	"wired.rip/wiredutils/config"
-	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/rbac"
 )
 
 var SignalChannel = make(chan bool, 8)
 
+// AddRoute is the query string form of POST /api/v2/routes
 func AddRoute(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	serverHost := r.URL.Query().Get("server_host")
-	serverPort := r.URL.Query().Get("server_port")
-	proxyDomain := r.URL.Query().Get("proxy_domain")
-	proxyPort := r.URL.Query().Get("proxy_port")
+	query := r.URL.Query()
+	in := RouteInput{
+		ServerHost:  query.Get("server_host"),
+		ServerPort:  query.Get("server_port"),
+		ProxyDomain: query.Get("proxy_domain"),
+		ProxyPort:   query.Get("proxy_port"),
+	}
 
-	if serverHost == "" || serverPort == "" || proxyDomain == "" || proxyPort == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"message": "server_host, server_port, proxy_domain and proxy_port are required"}`))
-		return
+	// the owner used to be ignored without routes:all
+	principal, _ := rbac.FromContext(r.Context())
+	if principal.Has(rbac.RoutesAll) && query.Has("owner") {
+		owner := query.Get("owner")
+		in.Owner = &owner
 	}
 
-	_route, ok := config.GetRouteByProxyDomain(proxyDomain)
-	if ok {
-		w.WriteHeader(http.StatusConflict)
-		w.Write([]byte(`{"message": "proxy_domain already in use", "route_id": "` + _route.RouteId + `"}`))
+	err := api.Check(&in)
+	if err != nil {
+		writeLegacyError(w, err)
 		return
 	}
 
-	// add route
-	route := protocol.Route{
-		RouteId:     randomId(),
-		ServerHost:  serverHost,
-		ServerPort:  serverPort,
-		ProxyDomain: proxyDomain,
-		ProxyPort:   proxyPort,
+	route, err := createRoute(r, in)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
 	}
 
-	status := config.AddRoute(route)
-	w.WriteHeader(status)
-
-	SignalChannel <- true
-
+	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"message": "Route added", "route_id": "` + route.RouteId + `"}`))
 }
 
@@ -0,0 +1,118 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+type auditKey struct{}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	return s.ResponseWriter.Write(b)
+}
+
+// Audited records every call of handler in the audit log. Handlers add
+// the target and the state before and after the change through Audit.
+func Audited(action string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := &sqlite.AuditEntry{
+			Action: action,
+			Ip:     ClientIP(r),
+		}
+
+		if principal, ok := rbac.FromContext(r.Context()); ok {
+			entry.Actor = principal.UserId
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler(recorder, r.WithContext(context.WithValue(r.Context(), auditKey{}, entry)))
+
+		entry.CreatedAt = time.Now().Unix()
+		entry.Status = recorder.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+
+		err := sqlite.AddAuditEntry(*entry)
+		if err != nil {
+			slog.Error("Error writing audit log", "error", err)
+		}
+	}
+}
+
+// Audit describes the change made by the current request, before and after
+// are stored as JSON and may be nil
+func Audit(r *http.Request, target string, before, after any) {
+	entry, ok := r.Context().Value(auditKey{}).(*sqlite.AuditEntry)
+	if !ok {
+		return
+	}
+
+	entry.Target = target
+	entry.Before = auditJSON(before)
+	entry.After = auditJSON(after)
+}
+
+func auditJSON(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Error encoding audit state", "error", err)
+		return nil
+	}
+
+	return data
+}
+
+// ClientIP returns the address of the client, the HTTP server only listens
+// locally so requests forwarded by a reverse proxy carry it in a header
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return host
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		// the last hop was added by our own proxy
+		parts := strings.Split(forwarded, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+
+	if realIp := r.Header.Get("X-Real-IP"); realIp != "" {
+		return realIp
+	}
+
+	return host
+}
@@ -0,0 +1,196 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+	"wiredmaster/auth"
+
+	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+// AuthOAuth sends the user to the sign-in page of the provider in the path
+func AuthOAuth(w http.ResponseWriter, r *http.Request) {
+	authURL, ok := beginOAuth(w, r, "")
+	if !ok {
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// beginOAuth returns the URL of the provider's sign-in page or writes an error
+func beginOAuth(w http.ResponseWriter, r *http.Request, linkUserId string) (string, bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	provider, ok := auth.Get(r.PathValue("provider"))
+	if !ok || provider.Type == auth.TypeLocal {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Unknown provider"}`))
+		return "", false
+	}
+
+	redirect, err := auth.Redirect(provider)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Provider is misconfigured", "error": "` + err.Error() + `"}`))
+		return "", false
+	}
+
+	state, verifier, err := auth.Begin(provider.Id, linkUserId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to start sign-in", "error": "` + err.Error() + `"}`))
+		return "", false
+	}
+
+	authURL, err := redirect.AuthURL(state, verifier)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"message": "Provider is unavailable", "error": "` + err.Error() + `"}`))
+		return "", false
+	}
+
+	return authURL, true
+}
+
+// AuthOAuthCallback finishes a sign-in, unknown identities sign up as a
+// new user unless the sign-in was started to link them to a signed in user
+func AuthOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+
+	if query.Get("error") != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Sign-in was cancelled"}`))
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "No code provided"}`))
+		return
+	}
+
+	pending, ok := auth.Finish(query.Get("state"))
+	if !ok || pending.Provider != r.PathValue("provider") {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Invalid or expired state"}`))
+		return
+	}
+
+	provider, ok := auth.Get(pending.Provider)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Unknown provider"}`))
+		return
+	}
+
+	redirect, err := auth.Redirect(provider)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Provider is misconfigured", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	identity, err := redirect.Exchange(code, pending.Verifier)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"message": "Failed to sign in with provider", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	if pending.LinkUserId != "" {
+		err = sqlite.LinkIdentity(sqlite.Identity{
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			UserId:    pending.LinkUserId,
+			Username:  identity.Username,
+			CreatedAt: time.Now().Unix(),
+		})
+		if errors.Is(err, sqlite.ErrIdentityTaken) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"message": "This account is linked to another user"}`))
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"message": "Failed to link identity", "error": "` + err.Error() + `"}`))
+			return
+		}
+
+		log.Printf("Linked %s account %s to user %s\n", provider.Id, identity.Username, pending.LinkUserId)
+		http.Redirect(w, r, config.GetDashboardUrl()+"/settings?linked="+url.QueryEscape(provider.Id), http.StatusFound)
+		return
+	}
+
+	userId, err := signInIdentity(provider, identity)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to create user", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	log.Printf("New sign-in: %s with %s (%s)\n", identity.Username, provider.Id, userId)
+
+	jwtToken, refreshToken, err := startSession(r, userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to start session", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	// the refresh token outlives the access token by weeks, it stays out
+	// of URLs that end up in the browser history and proxy logs
+	setRefreshCookie(w, refreshToken)
+	http.Redirect(w, r, config.GetDashboardUrl()+"/auth?token="+jwtToken, http.StatusFound)
+}
+
+// signInIdentity returns the user an identity is linked to, creating the
+// user on its first sign-in
+func signInIdentity(provider config.AuthProvider, identity auth.Identity) (string, error) {
+	linked, ok, err := sqlite.GetIdentity(identity.Provider, identity.Subject)
+	if err != nil {
+		return "", err
+	}
+
+	if ok {
+		return linked.UserId, sqlite.UpdateUserProfile(linked.UserId, identity.Username, identity.Avatar)
+	}
+
+	role := rbac.SignupRole
+	if config.GetMode() == "demo" {
+		role = "demo"
+	}
+
+	if provider.Id == "discord" && identity.Subject == config.GetAdminDiscordId() {
+		role = "admin"
+	}
+
+	userId, err := auth.NewUserId()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().Unix()
+	err = sqlite.CreateUserWithIdentity(sqlite.User{
+		Id:        userId,
+		Username:  identity.Username,
+		Avatar:    identity.Avatar,
+		Role:      role,
+		CreatedAt: now,
+	}, sqlite.Identity{
+		Provider:  identity.Provider,
+		Subject:   identity.Subject,
+		Username:  identity.Username,
+		CreatedAt: now,
+	})
+
+	return userId, err
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"wired.rip/wiredutils/config"
+)
+
+// GetAuthProviders lists the ways to sign in, the dashboard starts
+// redirect sign-ins at /api/auth/oauth/<id>
+func GetAuthProviders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	providers := []map[string]string{}
+	for _, p := range config.GetAuthProviders() {
+		providers = append(providers, map[string]string{
+			"id":   p.Id,
+			"type": p.Type,
+			"name": p.Name,
+		})
+	}
+
+	json.NewEncoder(w).Encode(providers)
+}
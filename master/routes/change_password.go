@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"wiredmaster/auth"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, _ := rbac.FromContext(r.Context())
+	credential, ok := localCredential(w, principal.UserId)
+	if !ok {
+		return
+	}
+
+	if !auth.CheckPassword(credential.PasswordHash, r.FormValue("current_password")) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Current password is wrong"}`))
+		return
+	}
+
+	hash, err := auth.HashPassword(r.FormValue("password"))
+	if errors.Is(err, auth.ErrWeakPassword) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "` + err.Error() + `"}`))
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to hash password", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	err = sqlite.SetPasswordHash(principal.UserId, hash)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to change password", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	Audit(r, principal.UserId, nil, nil)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Password changed"}`))
+}
+
+// localCredential looks up the password of a user or writes an error
+func localCredential(w http.ResponseWriter, userId string) (sqlite.LocalCredential, bool) {
+	credential, ok, err := sqlite.GetLocalCredential(userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to look up user", "error": "` + err.Error() + `"}`))
+		return sqlite.LocalCredential{}, false
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "User has no password"}`))
+		return sqlite.LocalCredential{}, false
+	}
+
+	return credential, true
+}
@@ -2,27 +2,30 @@ package routes
 
 import (
 	"net/http"
-
-	"wired.rip/wiredutils/sqlite"
+	"wiredmaster/api"
 )
 
+// ChangeUserRole is the query string form of PATCH /api/v2/users/{id}
 func ChangeUserRole(w http.ResponseWriter, r *http.Request) {
-	discordId := r.FormValue("discord_id")
-	role := r.FormValue("role")
+	userId := r.FormValue("user_id")
+	if userId == "" {
+		// users that signed in with Discord before identities kept their id
+		userId = r.FormValue("discord_id")
+	}
 
-	if discordId == "" || role == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"message": "Missing discord_id or role"}`))
+	role := r.FormValue("role")
+	if userId == "" || role == "" {
+		writeLegacyError(w, api.BadRequest("Missing user_id or role"))
 		return
 	}
 
-	err := sqlite.ChangeUserRole(discordId, role)
+	_, err := setUserRole(r, userId, role)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message": "Failed to change user role", "error": "` + err.Error() + `"}`))
+		writeLegacyError(w, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"message": "User role changed"}`))
 }
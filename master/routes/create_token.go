@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"wiredmaster/api"
+
+	"wired.rip/wiredutils/rbac"
+)
+
+// CreateToken is the form encoded version of POST /api/v2/tokens, the token
+// itself is only returned once. Parameters: name, scopes (comma separated
+// permissions, "*" for all of the caller's), kind (personal or service) and
+// expires_in (days).
+func CreateToken(w http.ResponseWriter, r *http.Request) {
+	in := TokenInput{
+		Name: r.FormValue("name"),
+		Kind: r.FormValue("kind"),
+	}
+
+	scopes, ok := rbac.ParsePermissions(r.FormValue("scopes"))
+	if !ok || len(scopes) == 0 {
+		writeLegacyError(w, api.BadRequest("scopes must list at least one known permission"))
+		return
+	}
+
+	in.Scopes = scopes
+
+	if value := r.FormValue("expires_in"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeLegacyError(w, api.BadRequest("expires_in must be between 1 and "+strconv.Itoa(maxTokenDays)+" days"))
+			return
+		}
+
+		in.ExpiresIn = n
+	}
+
+	err := api.Check(&in)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	t, err := createApiToken(r, in)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Token created", "id": "` + t.Id + `", "token": "` + t.Token + `", "expires_at": ` + strconv.FormatInt(t.ExpiresAt, 10) + `}`))
+}
@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"wiredmaster/api"
+)
+
+// CreateUser is the form encoded version of POST /api/v2/users, it creates
+// a user that signs in with username and password
+func CreateUser(w http.ResponseWriter, r *http.Request) {
+	in := UserInput{
+		Username: r.FormValue("username"),
+		Password: r.FormValue("password"),
+		Role:     r.FormValue("role"),
+	}
+
+	err := api.Check(&in)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	user, err := createLocalUser(r, in)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
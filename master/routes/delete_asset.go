@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"net/http"
+	"os"
+
+	"wired.rip/wiredutils/config"
+)
+
+func DeleteAsset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "name is required"}`))
+		return
+	}
+
+	asset, _ := config.GetAsset(name)
+	status := config.DeleteAsset(name)
+	w.WriteHeader(status)
+	if status == http.StatusNotFound {
+		w.Write([]byte(`{"message": "Asset not found"}`))
+		return
+	}
+
+	// name is a known asset here, so it is safe to use as a path
+	os.Remove("assets/" + name)
+	Audit(r, name, asset, nil)
+	AssetSignalChannel <- true
+
+	w.Write([]byte(`{"message": "Asset removed"}`))
+}
@@ -2,21 +2,24 @@ package routes
 
 import (
 	"net/http"
-
-	"wired.rip/wiredutils/config"
+	"wiredmaster/api"
 )
 
+// DeleteNode is the query string form of DELETE /api/v2/nodes/{id}
 func DeleteNode(w http.ResponseWriter, r *http.Request) {
-	// query params
 	nodeId := r.URL.Query().Get("node_id")
+	if nodeId == "" {
+		writeLegacyError(w, api.BadRequest("node_id is required"))
+		return
+	}
 
-	// Delete the node
-	status := config.DeleteNode(nodeId)
-	if status != http.StatusOK {
-		http.Error(w, "Failed to delete node", status)
+	err := deleteNode(r, nodeId)
+	if err != nil {
+		writeLegacyError(w, err)
 		return
 	}
 
-	// Return success
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Node deleted"}`))
 }
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"net/http"
+	"wiredmaster/api"
+)
+
+// DeleteRole is the query string form of DELETE /api/v2/roles/{name}
+func DeleteRole(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeLegacyError(w, api.BadRequest("name is required"))
+		return
+	}
+
+	err := deleteRole(r, name)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Role deleted"}`))
+}
@@ -1,10 +1,12 @@
 package routes
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 
 	"wired.rip/wiredutils/packet"
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
 	"wired.rip/wiredutils/utils"
 )
 
@@ -22,9 +24,9 @@ func DisconnectNode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// find player
-	log.Printf("Disconnecting player %s from %s", playerUUID, proxyHost)
+	slog.Info("Disconnecting player", "uuid", playerUUID, "proxy_domain", proxyHost)
 	player := utils.FindPlayer(playerUUID, proxyHost)
-	if player.Name == "" {
+	if player.Name == "" || !canAccessProxy(r, player.ProxyUsed) {
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte(`{"message": "Player not found"}`))
 		return
@@ -38,14 +40,31 @@ func DisconnectNode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// remove player from players array
-	utils.RemovePlayer(player)
-
+	// the node reports the leave once the connection is closed
 	conn.SendPacket(packet.Id_DisconnectPlayer, packet.Disconnect{
 		PlayerUUID: playerUUID,
 		ProxyHost:  proxyHost,
 	})
 
+	Audit(r, playerUUID, player, nil)
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"message": "Player disconnected"}`))
 }
+
+// canAccessProxy reports whether the caller owns the route behind a
+// proxy domain players are connected through
+func canAccessProxy(r *http.Request, proxyDomain string) bool {
+	principal, _ := rbac.FromContext(r.Context())
+	if principal.Has(rbac.RoutesAll) {
+		return true
+	}
+
+	route, ok, err := sqlite.GetRouteByProxyDomain(proxyDomain)
+	if err != nil {
+		slog.Error("Error looking up route", "error", err)
+		return false
+	}
+
+	return ok && principal.CanAccessRoute(route.Owner)
+}
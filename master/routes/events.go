@@ -0,0 +1,109 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wiredmaster/events"
+
+	"wired.rip/wiredutils/rbac"
+)
+
+const eventsKeepAlive = 15 * time.Second
+
+// Events streams the events the caller may see as server-sent events.
+// Clients resume with Last-Event-ID, a resync event tells them that events
+// were lost and the current state has to be fetched again. types limits the
+// stream to event types or their prefix, e.g. types=node,player.joined.
+func Events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Streaming is not supported"}`))
+		return
+	}
+
+	lastId := r.Header.Get("Last-Event-ID")
+	if lastId == "" {
+		lastId = r.URL.Query().Get("last_event_id")
+	}
+
+	afterId, _ := strconv.ParseUint(lastId, 10, 64)
+
+	var types []string
+	if value := r.URL.Query().Get("types"); value != "" {
+		types = strings.Split(value, ",")
+	}
+
+	sub, missed, complete := events.Subscribe(afterId)
+	defer events.Unsubscribe(sub)
+
+	principal, _ := rbac.FromContext(r.Context())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !complete {
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+	}
+
+	for _, e := range missed {
+		writeEvent(w, e, principal, types)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				// dropped for lagging behind, the client reconnects and
+				// resumes with Last-Event-ID
+				return
+			}
+
+			writeEvent(w, e, principal, types)
+		}
+
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e events.Event, principal rbac.Principal, types []string) {
+	if !e.VisibleTo(principal) || !matchesType(e.Type, types) {
+		return
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, data)
+}
+
+func matchesType(eventType string, types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+
+	for _, t := range types {
+		if eventType == t || strings.HasPrefix(eventType, t+".") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+type assetStatus struct {
+	config.Asset
+	Sync map[string]string `json:"sync"` // node id -> synced, outdated, missing or offline
+}
+
+func GetAssets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	nodes, err := sqlite.GetNodes()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get nodes", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	assets := []assetStatus{}
+	for _, asset := range config.GetAssets() {
+		status := assetStatus{
+			Asset: asset,
+			Sync:  make(map[string]string),
+		}
+
+		for _, node := range nodes {
+			if !assignedTo(asset, node.Id) {
+				continue
+			}
+
+			reported, ok := utils.GetNodeAssets(node.Id)
+			switch {
+			case !ok:
+				status.Sync[node.Id] = "offline"
+			case reported[asset.Name] == asset.Hash:
+				status.Sync[node.Id] = "synced"
+			case reported[asset.Name] == "":
+				status.Sync[node.Id] = "missing"
+			default:
+				status.Sync[node.Id] = "outdated"
+			}
+		}
+
+		assets = append(assets, status)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"assets": assets,
+	})
+}
+
+func assignedTo(asset config.Asset, nodeId string) bool {
+	if len(asset.Nodes) == 0 {
+		return true
+	}
+
+	for _, n := range asset.Nodes {
+		if n == nodeId {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"wiredmaster/api"
+
+	"wired.rip/wiredutils/sqlite"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// GetAudit lists audit entries newest first. Supports the filters actor,
+// action, target, since and until (unix seconds), pagination through limit
+// and before (the "next" value of the previous page), and format=jsonl to
+// export every matching entry as JSON lines.
+func GetAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := auditFilter(r)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	if value := query.Get("before"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			writeLegacyError(w, api.BadRequest("before must be a positive integer"))
+			return
+		}
+
+		filter.BeforeId = n
+	}
+
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			writeLegacyError(w, api.BadRequest("limit must be between 1 and "+strconv.Itoa(maxAuditLimit)))
+			return
+		}
+
+		filter.Limit = n
+	}
+
+	if query.Get("format") == "jsonl" {
+		exportAudit(w, filter)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	entries, err := sqlite.GetAuditEntries(filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get audit log", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	var next *int64
+	if len(entries) == filter.Limit {
+		next = &entries[len(entries)-1].Id
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"next":    next,
+	})
+}
+
+// auditFilter reads the filters shared by both API versions
+func auditFilter(r *http.Request) (sqlite.AuditFilter, error) {
+	query := r.URL.Query()
+
+	filter := sqlite.AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+		Limit:  defaultAuditLimit,
+	}
+
+	for name, dst := range map[string]*int64{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return sqlite.AuditFilter{}, api.BadRequest(name + " must be a positive integer")
+		}
+
+		*dst = n
+	}
+
+	return filter, nil
+}
+
+func v2ListAudit(r *http.Request) (any, error) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, cursor, err := api.PageParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.Limit = limit
+	if cursor != "" {
+		filter.BeforeId, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			return nil, api.Invalid(map[string]string{"cursor": "is not a cursor of this API"})
+		}
+	}
+
+	entries, err := sqlite.GetAuditEntries(filter)
+	if err != nil {
+		return nil, api.Internal("Failed to get audit log", err)
+	}
+
+	page := api.Page{Items: entries}
+	if len(entries) == limit {
+		page.Next = api.Cursor(strconv.FormatInt(entries[len(entries)-1].Id, 10))
+	}
+
+	return page, nil
+}
+
+// exportAudit streams all entries matching the filter, page by page
+func exportAudit(w http.ResponseWriter, filter sqlite.AuditFilter) {
+	filter.Limit = maxAuditLimit
+	entries, err := sqlite.GetAuditEntries(filter)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get audit log", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+
+	encoder := json.NewEncoder(w)
+	for {
+		if err != nil {
+			// the status line is gone already, cut the stream short
+			panic(http.ErrAbortHandler)
+		}
+
+		for _, entry := range entries {
+			encoder.Encode(entry)
+		}
+
+		if len(entries) < filter.Limit {
+			return
+		}
+
+		filter.BeforeId = entries[len(entries)-1].Id
+		entries, err = sqlite.GetAuditEntries(filter)
+	}
+}
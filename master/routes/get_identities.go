@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+// GetIdentities lists the sign-in methods of the caller
+func GetIdentities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, _ := rbac.FromContext(r.Context())
+	identities, err := sqlite.GetIdentities(principal.UserId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get identities", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	credential, ok, err := sqlite.GetLocalCredential(principal.UserId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get identities", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	var local map[string]interface{}
+	if ok {
+		local = map[string]interface{}{
+			"username":     credential.Username,
+			"totp_enabled": credential.TotpEnabled,
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"identities": identities,
+		"local":      local,
+	})
+}
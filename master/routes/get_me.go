@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+// GetMe describes the caller, access tokens no longer carry the role
+func GetMe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, _ := rbac.FromContext(r.Context())
+	user, _, _ := sqlite.GetUser(principal.UserId)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":          principal.UserId,
+		"username":    user.Username,
+		"avatar":      user.Avatar,
+		"role":        principal.Role,
+		"permissions": principal.Permissions,
+		"session_id":  principal.SessionId,
+		"token_id":    principal.TokenId,
+	})
+}
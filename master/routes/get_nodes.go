@@ -4,39 +4,78 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/sqlite"
 	"wired.rip/wiredutils/utils"
 )
 
 type Node struct {
-	Key     string `json:"key"`
-	Address string `json:"address"`
-	Online  bool   `json:"online"`
+	Key            string `json:"key"`
+	Address        string `json:"address"`
+	Online         bool   `json:"online"`
+	LastConnection int64  `json:"last_connection"`
+
+	// connection of online nodes
+	Version     string            `json:"version,omitempty"`
+	Platform    string            `json:"platform,omitempty"`
+	ConnectedAt int64             `json:"connected_at,omitempty"`
+	LastPing    int64             `json:"last_ping,omitempty"`
+	RTT         float64           `json:"rtt_ms,omitempty"`
+	Jitter      float64           `json:"jitter_ms,omitempty"`
+	RTTHistory  []utils.RTTSample `json:"rtt_history,omitempty"`
+	Health      string            `json:"health,omitempty"`
 }
 
 func GetNodes(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	storedNodes, err := sqlite.GetNodes()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get nodes", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	lastConnection := make(map[string]int64)
+	for _, node := range storedNodes {
+		lastConnection[node.Id] = node.LastConnection
+	}
+
 	var nodes []Node
 
-	// clients is make(map[string]protocol.Conn)
-	onlineNodes := utils.GetClients()
-	for key, conn := range onlineNodes {
-		nodes = append(nodes, Node{
-			Key:     fmt.Sprintf("%s.%s", key, config.GetWiredHost()),
-			Address: conn.RemoteAddr().String(),
-		})
+	onlineNodes := make(map[string]bool)
+	for _, client := range utils.ListClients() {
+		onlineNodes[client.Key] = true
+		node := Node{
+			Key:            fmt.Sprintf("%s.%s", client.Key, config.GetWiredHost()),
+			Address:        client.Data.Address,
+			Online:         true,
+			LastConnection: lastConnection[client.Key],
+			Version:        client.Data.Version,
+			Platform:       client.Data.Platform,
+			ConnectedAt:    client.Data.ConnectedAt.Unix(),
+			LastPing:       client.Data.LastPing.Unix(),
+		}
+
+		if client.Data.Liveness != nil {
+			stats := client.Data.Liveness.Stats()
+			node.RTT, node.Jitter, node.RTTHistory = milliseconds(stats.RTT), milliseconds(stats.Jitter), stats.History
+			node.Health = string(client.Data.Liveness.State())
+		}
+
+		nodes = append(nodes, node)
 	}
 
 	var offlineNodes []Node
-	configNodes := config.GetNodes()
 	// if node doesnt exist in onlineClients, add it to offlineNodes
-	for _, node := range configNodes {
+	for _, node := range storedNodes {
 		if _, ok := onlineNodes[node.Id]; !ok {
 			offlineNodes = append(offlineNodes, Node{
-				Key:     fmt.Sprintf("%s.%s", node.Id, config.GetWiredHost()),
-				Address: "",
+				Key:            fmt.Sprintf("%s.%s", node.Id, config.GetWiredHost()),
+				Address:        "",
+				LastConnection: node.LastConnection,
 			})
 		}
 	}
@@ -64,3 +103,20 @@ func GetNodes(w http.ResponseWriter, r *http.Request) {
 
 	return
 }
+
+// GetNode returns a node as GET /api/v2/nodes/{id} does, with the recent
+// reports about its host
+func GetNode(w http.ResponseWriter, r *http.Request) {
+	node, err := getNodeDetails(r.PathValue("id"))
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+func GetRoles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	roles, err := sqlite.GetRoles()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get roles", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"roles":       roles,
+		"permissions": rbac.Permissions,
+	})
+}
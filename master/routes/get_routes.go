@@ -3,14 +3,17 @@ package routes
 import (
 	"encoding/json"
 	"net/http"
-
-	"wired.rip/wiredutils/config"
 )
 
 func GetRoutes(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	routes := config.GetRoutes()
+	routes, err := listRoutes(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get routes", "error": "` + err.Error() + `"}`))
+		return
+	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"routes": routes,
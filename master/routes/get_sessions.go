@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+type sessionInfo struct {
+	sqlite.Session
+	Current bool `json:"current"`
+}
+
+// GetSessions lists the caller's active sessions
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, _ := rbac.FromContext(r.Context())
+	sessions, err := sqlite.GetSessions(principal.UserId, time.Now().Unix())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get sessions", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	list := make([]sessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		s.RefreshHash = ""
+		s.PreviousHash = ""
+		list = append(list, sessionInfo{
+			Session: s,
+			Current: s.Id == principal.SessionId,
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": list,
+	})
+}
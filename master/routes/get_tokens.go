@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// GetTokens lists the caller's API tokens, user managers see every token
+func GetTokens(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tokens, err := listApiTokens(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get tokens", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tokens": tokens,
+	})
+}
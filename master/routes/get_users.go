@@ -10,7 +10,12 @@ import (
 func GetUsers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	users := sqlite.GetUsers()
+	users, err := sqlite.GetUsers()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to get users", "error": "` + err.Error() + `"}`))
+		return
+	}
 
 	marshalledUsers, err := json.Marshal(users)
 	if err != nil {
@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"wiredmaster/api"
+)
+
+// writeLegacyError answers the former API in its {"message": ...} format,
+// details like the missing permission stay top level fields
+func writeLegacyError(w http.ResponseWriter, err error) {
+	var e *api.Error
+	if !errors.As(err, &e) {
+		e = api.Internal("Internal error", err)
+	}
+
+	status := e.Status
+	if status == http.StatusUnprocessableEntity {
+		status = http.StatusBadRequest
+	}
+
+	body := map[string]string{"message": e.Message}
+	for key, value := range e.Details {
+		body[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
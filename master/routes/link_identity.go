@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"wired.rip/wiredutils/rbac"
+)
+
+// LinkIdentity returns the URL that adds an account of the provider in the
+// path as sign-in method of the caller
+func LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	principal, _ := rbac.FromContext(r.Context())
+	if principal.SessionId == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Identities can only be linked when signed in"}`))
+		return
+	}
+
+	authURL, ok := beginOAuth(w, r, principal.UserId)
+	if !ok {
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"url": authURL,
+	})
+}
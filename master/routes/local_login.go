@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"wiredmaster/auth"
+
+	"wired.rip/wiredutils/jwt"
+	"wired.rip/wiredutils/sqlite"
+)
+
+// LocalLogin signs in with username and password, users that enabled TOTP
+// also send the current code. Accounts and addresses with too many failed
+// attempts are throttled.
+func LocalLogin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !auth.LocalEnabled() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Local sign-in is disabled"}`))
+		return
+	}
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	if username == "" || password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "username and password are required"}`))
+		return
+	}
+
+	now := time.Now()
+	clientIP := ClientIP(r)
+	if wait := auth.Throttled(username, clientIP, now); wait > 0 {
+		slog.Warn("Throttled local sign-in", "username", username, "client_ip", clientIP)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"message": "Too many failed sign-ins, try again later"}`))
+		return
+	}
+
+	credential, ok, err := sqlite.GetLocalCredentialByUsername(username)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to look up user", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	if !ok || !auth.CheckPassword(credential.PasswordHash, password) {
+		auth.FailedSignIn(username, clientIP, now)
+		slog.Warn("Failed local sign-in", "username", username, "client_ip", clientIP)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Invalid username or password"}`))
+		return
+	}
+
+	if credential.TotpEnabled {
+		code := r.FormValue("code")
+		if code == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "TOTP code required", "totp_required": true}`))
+			return
+		}
+
+		// a code that was accepted before, even concurrently, is refused
+		step, valid := auth.CheckTotp(credential.TotpSecret, code, credential.TotpLastStep, now)
+		if valid {
+			valid, err = sqlite.UseTotpStep(credential.UserId, step)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"message": "Failed to record TOTP code", "error": "` + err.Error() + `"}`))
+				return
+			}
+		}
+
+		if !valid {
+			auth.FailedSignIn(username, clientIP, now)
+			slog.Warn("Invalid TOTP code", "username", username, "client_ip", clientIP)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Invalid TOTP code", "totp_required": true}`))
+			return
+		}
+	}
+
+	auth.SignedIn(username)
+
+	log.Printf("New sign-in: %s with password (%s)\n", credential.Username, credential.UserId)
+
+	token, refreshToken, err := startSession(r, credential.UserId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to start session", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  token,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(jwt.AccessTokenLifetime.Seconds()),
+	})
+}
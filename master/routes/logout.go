@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+// Logout revokes the session of the access token used for the request
+func Logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, _ := rbac.FromContext(r.Context())
+	if principal.SessionId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Not signed in with a session, revoke API tokens instead"}`))
+		return
+	}
+
+	err := sqlite.RevokeSession(principal.SessionId, time.Now().Unix())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to revoke session", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	Audit(r, principal.SessionId, nil, nil)
+
+	clearRefreshCookie(w)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Signed out"}`))
+}
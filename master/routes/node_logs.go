@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wiredmaster/api"
+	"wiredmaster/logs"
+
+	"wired.rip/wiredutils/logging"
+	"wired.rip/wiredutils/sqlite"
+)
+
+const (
+	defaultLogLimit = 100
+	maxLogLimit     = 2000
+)
+
+// NodeLogs returns the latest log entries a node forwarded, oldest first.
+// level keeps entries of at least that level, field.<name>=<value> the ones
+// carrying a field, e.g. field.route_id=survival. With follow=true new
+// entries are streamed as server-sent events after the latest ones, clients
+// resume with Last-Event-ID.
+func NodeLogs(w http.ResponseWriter, r *http.Request) {
+	nodeId := r.PathValue("id")
+	_, ok, err := sqlite.GetNode(nodeId)
+	if err != nil {
+		writeLegacyError(w, api.Internal("Failed to look up node", err))
+		return
+	}
+
+	if !ok {
+		writeLegacyError(w, api.NotFound("Node not found"))
+		return
+	}
+
+	filter, limit, err := parseLogQuery(r)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("follow") == "true" {
+		followLogs(w, r, nodeId, filter, limit)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": logs.Tail(nodeId, filter, limit),
+	})
+}
+
+func parseLogQuery(r *http.Request) (logs.Filter, int, error) {
+	query := r.URL.Query()
+
+	level, err := logging.ParseLevel(query.Get("level"))
+	if err != nil {
+		return logs.Filter{}, 0, api.BadRequest("level must be debug, info, warn or error")
+	}
+
+	filter := logs.Filter{Level: level, Fields: map[string]string{}}
+	for key, values := range query {
+		if name, ok := strings.CutPrefix(key, "field."); ok && name != "" {
+			filter.Fields[name] = values[0]
+		}
+	}
+
+	limit := defaultLogLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLogLimit {
+			return logs.Filter{}, 0, api.BadRequest("limit must be between 1 and " + strconv.Itoa(maxLogLimit))
+		}
+
+		limit = n
+	}
+
+	return filter, limit, nil
+}
+
+func followLogs(w http.ResponseWriter, r *http.Request, nodeId string, filter logs.Filter, limit int) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeLegacyError(w, api.Internal("Streaming is not supported", nil))
+		return
+	}
+
+	lastId := r.Header.Get("Last-Event-ID")
+	if lastId == "" {
+		lastId = r.URL.Query().Get("last_event_id")
+	}
+
+	afterId, _ := strconv.ParseUint(lastId, 10, 64)
+
+	sub, missed, complete := logs.Subscribe(nodeId, afterId)
+	defer logs.Unsubscribe(sub)
+
+	matched := []logs.Entry{}
+	for _, e := range missed {
+		if filter.Matches(e) {
+			matched = append(matched, e)
+		}
+	}
+
+	// a new stream starts with the latest entries, a resumed one with
+	// everything it missed
+	if afterId == 0 && len(matched) > limit {
+		matched = matched[len(matched)-limit:]
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !complete {
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+	}
+
+	for _, e := range matched {
+		writeLogEntry(w, e)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				// dropped for lagging behind, the client reconnects and
+				// resumes with Last-Event-ID
+				return
+			}
+
+			if filter.Matches(e) {
+				writeLogEntry(w, e)
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+func writeLogEntry(w http.ResponseWriter, e logs.Entry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", e.Id, data)
+}
@@ -0,0 +1,383 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"wiredmaster/api"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+	defaultTopLimit  = 10
+	maxTopLimit      = 100
+	dayLayout        = "2006-01-02"
+)
+
+// PlayerDay are the statistics of a day with the average session length
+type PlayerDay struct {
+	sqlite.PlayerStats
+	AverageSession int64 `json:"average_session" doc:"seconds"`
+}
+
+// PlayerStatsSummary are the days of a route from since to until
+type PlayerStatsSummary struct {
+	RouteId         string      `json:"route_id" doc:"* for every route"`
+	Days            []PlayerDay `json:"days"`
+	Sessions        int         `json:"sessions"`
+	PeakConcurrency int         `json:"peak_concurrency"`
+	AverageSession  int64       `json:"average_session" doc:"seconds"`
+}
+
+var playerStatsParams = []api.Param{
+	{Name: "route", Description: "Route id, required without routes:all"},
+	{Name: "since", Description: "First day, e.g. 2024-05-01, defaults to 30 days ago"},
+	{Name: "until", Description: "Last day, defaults to today"},
+}
+
+var topPlayersParams = []api.Param{
+	{Name: "route", Description: "Route id"},
+	{Name: "days", Description: "Sessions of the last days, defaults to 30"},
+	{Name: "limit", Description: "Defaults to 10"},
+}
+
+var playerSessionsParams = []api.Param{
+	{Name: "uuid"},
+	{Name: "route", Description: "Route id"},
+	{Name: "since", Description: "Unix seconds, left at or after"},
+	{Name: "until", Description: "Unix seconds, left before"},
+}
+
+// GetPlayerSessions lists finished sessions newest first. Supports the
+// filters uuid, route, since and until (unix seconds) and pagination
+// through limit and before (the "next" value of the previous page).
+func GetPlayerSessions(w http.ResponseWriter, r *http.Request) {
+	filter, err := playerSessionFilter(r)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	if value := query.Get("before"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			writeLegacyError(w, api.BadRequest("before must be a positive integer"))
+			return
+		}
+
+		filter.BeforeId = n
+	}
+
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > api.MaxLimit {
+			writeLegacyError(w, api.BadRequest("limit must be between 1 and "+strconv.Itoa(api.MaxLimit)))
+			return
+		}
+
+		filter.Limit = n
+	}
+
+	sessions, err := sqlite.GetPlayerSessions(filter)
+	if err != nil {
+		writeLegacyError(w, api.Internal("Failed to get player sessions", err))
+		return
+	}
+
+	var next *int64
+	if len(sessions) == filter.Limit {
+		next = &sessions[len(sessions)-1].Id
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": sessions,
+		"next":     next,
+	})
+}
+
+// GetPlayerStats reports unique players, sessions, playtime and the peak
+// concurrency per day. Finished days come from the rollups, today is
+// computed from the sessions so far.
+func GetPlayerStats(w http.ResponseWriter, r *http.Request) {
+	summary, err := playerStats(r)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// GetTopPlayers ranks the players of a route by playtime
+func GetTopPlayers(w http.ResponseWriter, r *http.Request) {
+	players, err := topPlayers(r)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"players": players,
+	})
+}
+
+// historyRoutes resolves the route filter of the caller, nil matches every
+// route. Callers without routes:all only see the routes they own.
+func historyRoutes(r *http.Request) ([]string, error) {
+	if routeId := r.URL.Query().Get("route"); routeId != "" {
+		route, err := accessibleRoute(r, routeId)
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{route.RouteId}, nil
+	}
+
+	principal, _ := rbac.FromContext(r.Context())
+	if principal.Has(rbac.RoutesAll) {
+		return nil, nil
+	}
+
+	routes, err := listRoutes(r)
+	if err != nil {
+		return nil, api.Internal("Failed to get routes", err)
+	}
+
+	routeIds := make([]string, 0, len(routes))
+	for _, route := range routes {
+		routeIds = append(routeIds, route.RouteId)
+	}
+
+	return routeIds, nil
+}
+
+func playerSessionFilter(r *http.Request) (sqlite.PlayerSessionFilter, error) {
+	query := r.URL.Query()
+
+	routeIds, err := historyRoutes(r)
+	if err != nil {
+		return sqlite.PlayerSessionFilter{}, err
+	}
+
+	filter := sqlite.PlayerSessionFilter{
+		UUID:     query.Get("uuid"),
+		RouteIds: routeIds,
+		Limit:    api.DefaultLimit,
+	}
+
+	for name, dst := range map[string]*int64{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return sqlite.PlayerSessionFilter{}, api.BadRequest(name + " must be a positive integer")
+		}
+
+		*dst = n
+	}
+
+	return filter, nil
+}
+
+// OpenPlayerSessions returns the sessions of the players online on the nodes
+// of this master, they are only recorded once the players leave
+func OpenPlayerSessions() ([]sqlite.PlayerSession, error) {
+	routes, err := sqlite.GetRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	byDomain := make(map[string]string, len(routes))
+	for _, route := range routes {
+		byDomain[route.ProxyDomain] = route.RouteId
+	}
+
+	online := utils.ListPlayers()
+	sessions := make([]sqlite.PlayerSession, 0, len(online))
+	for _, player := range online {
+		sessions = append(sessions, sqlite.PlayerSession{
+			UUID:            player.UUID,
+			Name:            player.Name,
+			RouteId:         byDomain[player.ProxyUsed],
+			ProxyDomain:     player.ProxyUsed,
+			NodeId:          player.NodeId,
+			ClientIP:        player.ClientIP,
+			ProtocolVersion: player.ProtocolVersion,
+			JoinedAt:        player.JoinedAt,
+		})
+	}
+
+	return sessions, nil
+}
+
+func playerStats(r *http.Request) (PlayerStatsSummary, error) {
+	query := r.URL.Query()
+
+	// unique players can not be summed up, callers without routes:all
+	// look at one of their routes at a time
+	routeId := sqlite.AllRoutes
+	if value := query.Get("route"); value != "" {
+		route, err := accessibleRoute(r, value)
+		if err != nil {
+			return PlayerStatsSummary{}, err
+		}
+
+		routeId = route.RouteId
+	} else if principal, _ := rbac.FromContext(r.Context()); !principal.Has(rbac.RoutesAll) {
+		return PlayerStatsSummary{}, api.Invalid(map[string]string{"route": "is required without " + string(rbac.RoutesAll)})
+	}
+
+	now := time.Now().UTC()
+	today := now.Truncate(24 * time.Hour)
+	until, since := today, today.AddDate(0, 0, 1-defaultStatsDays)
+	for name, dst := range map[string]*time.Time{"since": &since, "until": &until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+
+		day, err := time.Parse(dayLayout, value)
+		if err != nil {
+			return PlayerStatsSummary{}, api.Invalid(map[string]string{name: "must be a day like 2006-01-02"})
+		}
+
+		*dst = day
+	}
+
+	if until.Before(since) || until.Sub(since) >= maxStatsDays*24*time.Hour {
+		return PlayerStatsSummary{}, api.Invalid(map[string]string{"until": "must be at most " + strconv.Itoa(maxStatsDays) + " days after since"})
+	}
+
+	stats, err := sqlite.GetPlayerStats(routeId, since.Format(dayLayout), until.Format(dayLayout))
+	if err != nil {
+		return PlayerStatsSummary{}, api.Internal("Failed to get player statistics", err)
+	}
+
+	if !until.Before(today) {
+		open, err := OpenPlayerSessions()
+		if err != nil {
+			return PlayerStatsSummary{}, api.Internal("Failed to get online players", err)
+		}
+
+		current, err := sqlite.ComputePlayerStats(now, open)
+		if err != nil {
+			return PlayerStatsSummary{}, api.Internal("Failed to compute player statistics", err)
+		}
+
+		for _, s := range current {
+			if s.RouteId == routeId {
+				stats = append(stats, s)
+			}
+		}
+	}
+
+	summary := PlayerStatsSummary{RouteId: routeId, Days: make([]PlayerDay, 0, len(stats))}
+	var playtime int64
+	for _, s := range stats {
+		day := PlayerDay{PlayerStats: s}
+		if s.Sessions > 0 {
+			day.AverageSession = s.Playtime / int64(s.Sessions)
+		}
+
+		summary.Days = append(summary.Days, day)
+		summary.Sessions += s.Sessions
+		summary.PeakConcurrency = max(summary.PeakConcurrency, s.PeakConcurrency)
+		playtime += s.Playtime
+	}
+
+	if summary.Sessions > 0 {
+		summary.AverageSession = playtime / int64(summary.Sessions)
+	}
+
+	return summary, nil
+}
+
+func topPlayers(r *http.Request) ([]sqlite.TopPlayer, error) {
+	query := r.URL.Query()
+
+	days := defaultStatsDays
+	if value := query.Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxStatsDays {
+			return nil, api.Invalid(map[string]string{"days": "must be between 1 and " + strconv.Itoa(maxStatsDays)})
+		}
+
+		days = n
+	}
+
+	limit := defaultTopLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxTopLimit {
+			return nil, api.Invalid(map[string]string{"limit": "must be between 1 and " + strconv.Itoa(maxTopLimit)})
+		}
+
+		limit = n
+	}
+
+	routeIds, err := historyRoutes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	since := time.Now().AddDate(0, 0, -days).Unix()
+	players, err := sqlite.GetTopPlayers(routeIds, since, limit)
+	if err != nil {
+		return nil, api.Internal("Failed to get top players", err)
+	}
+
+	return players, nil
+}
+
+func v2ListPlayerSessions(r *http.Request) (any, error) {
+	filter, err := playerSessionFilter(r)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, cursor, err := api.PageParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.Limit = limit
+	if cursor != "" {
+		filter.BeforeId, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			return nil, api.Invalid(map[string]string{"cursor": "is not a cursor of this API"})
+		}
+	}
+
+	sessions, err := sqlite.GetPlayerSessions(filter)
+	if err != nil {
+		return nil, api.Internal("Failed to get player sessions", err)
+	}
+
+	page := api.Page{Items: sessions}
+	if len(sessions) == limit {
+		page.Next = api.Cursor(strconv.FormatInt(sessions[len(sessions)-1].Id, 10))
+	}
+
+	return page, nil
+}
+
+func v2GetPlayerStats(r *http.Request) (any, error) {
+	return playerStats(r)
+}
+
+func v2TopPlayers(r *http.Request) (any, error) {
+	return topPlayers(r)
+}
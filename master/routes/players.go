@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wiredmaster/api"
+
+	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+// PlayerInfo is an online player as the API reports it
+type PlayerInfo struct {
+	Name            string `json:"name"`
+	UUID            string `json:"uuid"`
+	RouteId         string `json:"route_id" doc:"empty if the route was removed meanwhile"`
+	ProxyDomain     string `json:"proxy_domain"`
+	Server          string `json:"server"`
+	Node            string `json:"node"`
+	ProtocolVersion int    `json:"protocol_version"`
+	ClientIP        string `json:"client_ip"`
+	JoinedAt        int64  `json:"joined_at"`
+	ConnectedFor    int64  `json:"connected_for" doc:"seconds"`
+}
+
+// PlayerCounts counts the online players matching the filters
+type PlayerCounts struct {
+	Total  int            `json:"total"`
+	Routes map[string]int `json:"routes" doc:"players by route id"`
+}
+
+var playerFilterParams = []api.Param{
+	{Name: "route", Description: "Route id"},
+	{Name: "node", Description: "Node id"},
+	{Name: "proxy_domain"},
+	{Name: "name", Description: "Name prefix, case insensitive"},
+	{Name: "protocol_version"},
+}
+
+// GetPlayers lists the online players on routes of the caller, filtered by
+// route, node, proxy_domain, name (prefix) and protocol_version, together
+// with the number of players per route.
+func GetPlayers(w http.ResponseWriter, r *http.Request) {
+	players, err := listPlayers(r)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"players": players,
+		"counts":  countPlayers(players),
+	})
+}
+
+// listPlayers returns the online players the caller may see that match
+// the filters of the query
+func listPlayers(r *http.Request) ([]PlayerInfo, error) {
+	query := r.URL.Query()
+
+	protocolVersion := -1
+	if value := query.Get("protocol_version"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, api.Invalid(map[string]string{"protocol_version": "must be an integer"})
+		}
+
+		protocolVersion = n
+	}
+
+	routes, err := listRoutes(r)
+	if err != nil {
+		return nil, api.Internal("Failed to get routes", err)
+	}
+
+	byDomain := make(map[string]sqlite.Route, len(routes))
+	for _, route := range routes {
+		byDomain[route.ProxyDomain] = route
+	}
+
+	principal, _ := rbac.FromContext(r.Context())
+	routeId := query.Get("route")
+	proxyDomain := query.Get("proxy_domain")
+	name := strings.ToLower(query.Get("name"))
+
+	var online []protocol.Player
+	if node := query.Get("node"); node != "" {
+		online = utils.ListNodePlayers(node)
+	} else {
+		online = utils.ListPlayers()
+	}
+
+	now := time.Now().Unix()
+	players := []PlayerInfo{}
+	for _, player := range online {
+		route, ok := byDomain[player.ProxyUsed]
+		// players of removed routes are only shown to those managing every route
+		if !ok && !principal.Has(rbac.RoutesAll) {
+			continue
+		}
+
+		if routeId != "" && route.RouteId != routeId ||
+			proxyDomain != "" && player.ProxyUsed != proxyDomain ||
+			name != "" && !strings.HasPrefix(strings.ToLower(player.Name), name) ||
+			protocolVersion >= 0 && player.ProtocolVersion != protocolVersion {
+			continue
+		}
+
+		players = append(players, PlayerInfo{
+			Name:            player.Name,
+			UUID:            player.UUID,
+			RouteId:         route.RouteId,
+			ProxyDomain:     player.ProxyUsed,
+			Server:          player.PlayingOn,
+			Node:            player.NodeId,
+			ProtocolVersion: player.ProtocolVersion,
+			ClientIP:        player.ClientIP,
+			JoinedAt:        player.JoinedAt,
+			ConnectedFor:    now - player.JoinedAt,
+		})
+	}
+
+	return players, nil
+}
+
+func countPlayers(players []PlayerInfo) PlayerCounts {
+	counts := PlayerCounts{Total: len(players), Routes: map[string]int{}}
+	for _, player := range players {
+		if player.RouteId != "" {
+			counts.Routes[player.RouteId]++
+		}
+	}
+
+	return counts
+}
+
+func v2ListPlayers(r *http.Request) (any, error) {
+	players, err := listPlayers(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.Paginate(r, players, func(player PlayerInfo) string {
+		return player.UUID + "@" + player.ProxyDomain
+	})
+}
+
+func v2CountPlayers(r *http.Request) (any, error) {
+	players, err := listPlayers(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return countPlayers(players), nil
+}
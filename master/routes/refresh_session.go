@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"wired.rip/wiredutils/jwt"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+// RefreshSession exchanges a refresh token for a new access token. The
+// refresh token is rotated on every use, presenting a rotated token again
+// revokes the session as the token must have leaked. Browsers signed in
+// through a provider send it as cookie and get the rotated one as cookie.
+func RefreshSession(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	refreshToken := r.FormValue("refresh_token")
+	fromCookie := false
+	if refreshToken == "" {
+		if cookie, err := r.Cookie(refreshCookie); err == nil {
+			refreshToken, fromCookie = cookie.Value, true
+		}
+	}
+
+	if refreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "refresh_token is required"}`))
+		return
+	}
+
+	now := time.Now()
+	hash := utils.HashSecret(refreshToken)
+	session, ok, err := sqlite.GetSessionByRefreshHash(hash)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to look up session", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	if !ok {
+		reused, ok, err := sqlite.GetSessionByPreviousHash(hash)
+		if err == nil && ok && reused.RevokedAt == 0 {
+			slog.Warn("Rotated refresh token was reused, revoking the session", "session", reused.Id)
+			sqlite.RevokeSession(reused.Id, now.Unix())
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Unauthorized"}`))
+		return
+	}
+
+	if !session.Active(now.Unix()) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Unauthorized"}`))
+		return
+	}
+
+	newRefreshToken, err := utils.NewSecret(refreshTokenPrefix)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to generate token", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	rotated, err := sqlite.RotateSession(session.Id, hash, utils.HashSecret(newRefreshToken), now.Unix(), now.Add(sessionLifetime).Unix())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to refresh session", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	if !rotated {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Unauthorized"}`))
+		return
+	}
+
+	token, err := accessToken(session.UserId, session.Id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to create access token", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	res := map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(jwt.AccessTokenLifetime.Seconds()),
+	}
+
+	if fromCookie {
+		setRefreshCookie(w, newRefreshToken)
+	} else {
+		res["refresh_token"] = newRefreshToken
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
@@ -2,29 +2,23 @@ package routes
 
 import (
 	"net/http"
-
-	"wired.rip/wiredutils/config"
+	"wiredmaster/api"
 )
 
+// RemoveRoute is the query string form of DELETE /api/v2/routes/{id}
 func RemoveRoute(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
 	routeId := r.URL.Query().Get("route_id")
-
 	if routeId == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"message": "route_id is required"}`))
+		writeLegacyError(w, api.BadRequest("route_id is required"))
 		return
 	}
 
-	status := config.DeleteRoute(routeId)
-	w.WriteHeader(status)
-	if status == http.StatusNotFound {
-		w.Write([]byte(`{"message": "Route not found"}`))
+	err := deleteRoute(r, routeId)
+	if err != nil {
+		writeLegacyError(w, err)
 		return
 	}
 
-	SignalChannel <- true
-
+	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"message": "Route removed"}`))
 }
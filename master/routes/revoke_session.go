@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sessionId := r.URL.Query().Get("id")
+	if sessionId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "id is required"}`))
+		return
+	}
+
+	session, ok, err := sqlite.GetSession(sessionId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to look up session", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	// sessions of other users are reported as missing
+	principal, _ := rbac.FromContext(r.Context())
+	if !ok || session.UserId != principal.UserId && !principal.Has(rbac.UsersManage) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Session not found"}`))
+		return
+	}
+
+	err = sqlite.RevokeSession(sessionId, time.Now().Unix())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to revoke session", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	Audit(r, session.UserId, map[string]string{"session": sessionId}, nil)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Session revoked"}`))
+}
+
+// RevokeAllSessions signs the caller, or with users:manage any user given
+// as user_id, out everywhere
+func RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, _ := rbac.FromContext(r.Context())
+	userId := r.FormValue("user_id")
+	if userId == "" {
+		userId = principal.UserId
+	}
+
+	if userId != principal.UserId && !principal.Has(rbac.UsersManage) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Forbidden", "permission": "` + string(rbac.UsersManage) + `"}`))
+		return
+	}
+
+	revoked, err := sqlite.RevokeSessions(userId, time.Now().Unix())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to revoke sessions", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	Audit(r, userId, nil, map[string]int64{"revoked": revoked})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Sessions revoked", "revoked": ` + strconv.FormatInt(revoked, 10) + `}`))
+}
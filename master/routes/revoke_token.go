@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"net/http"
+	"wiredmaster/api"
+)
+
+// RevokeToken is the query string form of DELETE /api/v2/tokens/{id}
+func RevokeToken(w http.ResponseWriter, r *http.Request) {
+	tokenId := r.URL.Query().Get("id")
+	if tokenId == "" {
+		writeLegacyError(w, api.BadRequest("id is required"))
+		return
+	}
+
+	alreadyRevoked, err := revokeApiToken(r, tokenId)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if alreadyRevoked {
+		w.Write([]byte(`{"message": "Token already revoked"}`))
+		return
+	}
+
+	w.Write([]byte(`{"message": "Token revoked"}`))
+}
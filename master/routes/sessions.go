@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"wired.rip/wiredutils/jwt"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+const (
+	refreshTokenPrefix = "wiredr_"
+
+	// cookie carrying the refresh token of browser sign-ins, scripts of
+	// the dashboard never see it
+	refreshCookie = "wired_refresh"
+
+	// sessions expire when they are not refreshed for this long
+	sessionLifetime = 30 * 24 * time.Hour
+)
+
+// startSession signs a user in and returns an access and a refresh token
+func startSession(r *http.Request, userId string) (string, string, error) {
+	refreshToken, err := utils.NewSecret(refreshTokenPrefix)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	session := sqlite.Session{
+		Id:          randomId() + randomId(),
+		UserId:      userId,
+		RefreshHash: utils.HashSecret(refreshToken),
+		CreatedAt:   now.Unix(),
+		RefreshedAt: now.Unix(),
+		ExpiresAt:   now.Add(sessionLifetime).Unix(),
+		Ip:          ClientIP(r),
+		UserAgent:   r.UserAgent(),
+	}
+
+	err = sqlite.CreateSession(session)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := accessToken(userId, session.Id)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+func setRefreshCookie(w http.ResponseWriter, refreshToken string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    refreshToken,
+		Path:     "/api/auth",
+		MaxAge:   int(sessionLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearRefreshCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Path:     "/api/auth",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func accessToken(userId, sessionId string) (string, error) {
+	user, ok, err := sqlite.GetUser(userId)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return "", errors.New("user does not exist")
+	}
+
+	return jwt.CreateToken(userId, sessionId, user.Username, user.Avatar)
+}
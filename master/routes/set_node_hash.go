@@ -16,14 +16,16 @@ func SetNodeHash(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	arch := r.URL.Query().Get("arch")
-	if arch == "" {
+	platform, ok := platformFromQuery(r)
+	if !ok {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"message": "arch is required"}`))
+		w.Write([]byte(`{"message": "platform (os/arch[/variant]) or arch is required"}`))
 		return
 	}
 
-	config.SetCurrentNodeHash(hash, arch)
+	before := config.GetNodeReleases()[platform]
+	config.SetCurrentNodeHash(hash, platform)
+	Audit(r, platform, map[string]string{"hash": before}, map[string]string{"hash": hash})
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"message": "Node hash updated"}`))
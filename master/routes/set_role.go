@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"net/http"
+	"wiredmaster/api"
+
+	"wired.rip/wiredutils/rbac"
+)
+
+// SetRole is the form encoded version of PUT /api/v2/roles/{name},
+// e.g. ?name=support&permissions=routes:read,routes:all,players:kick
+func SetRole(w http.ResponseWriter, r *http.Request) {
+	permissions, ok := rbac.ParsePermissions(r.FormValue("permissions"))
+	if !ok {
+		writeLegacyError(w, api.BadRequest("Unknown permission"))
+		return
+	}
+
+	_, err := setRole(r, r.FormValue("name"), permissions)
+	if err != nil {
+		writeLegacyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Role saved"}`))
+}
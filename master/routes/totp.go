@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"wiredmaster/auth"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+// SetupTotp generates a new TOTP secret for the caller, it only protects
+// sign-ins after EnableTotp confirmed a code
+func SetupTotp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, _ := rbac.FromContext(r.Context())
+	credential, ok := localCredential(w, principal.UserId)
+	if !ok {
+		return
+	}
+
+	if credential.TotpEnabled {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message": "TOTP is already enabled"}`))
+		return
+	}
+
+	secret, err := auth.NewTotpSecret()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to generate secret", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	err = sqlite.SetTotp(principal.UserId, secret, false)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to store secret", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	Audit(r, principal.UserId, nil, nil)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"url":    auth.TotpURL(secret, credential.Username),
+	})
+}
+
+func EnableTotp(w http.ResponseWriter, r *http.Request) {
+	setTotp(w, r, true)
+}
+
+func DisableTotp(w http.ResponseWriter, r *http.Request) {
+	setTotp(w, r, false)
+}
+
+// setTotp turns TOTP on or off, both require a valid code
+func setTotp(w http.ResponseWriter, r *http.Request, enabled bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, _ := rbac.FromContext(r.Context())
+	credential, ok := localCredential(w, principal.UserId)
+	if !ok {
+		return
+	}
+
+	if credential.TotpSecret == "" || credential.TotpEnabled == enabled {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message": "TOTP is not set up or already in that state"}`))
+		return
+	}
+
+	step, valid := auth.CheckTotp(credential.TotpSecret, r.FormValue("code"), credential.TotpLastStep, time.Now())
+	if valid {
+		var err error
+		valid, err = sqlite.UseTotpStep(principal.UserId, step)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"message": "Failed to record TOTP code", "error": "` + err.Error() + `"}`))
+			return
+		}
+	}
+
+	if !valid {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message": "Invalid TOTP code"}`))
+		return
+	}
+
+	secret := credential.TotpSecret
+	if !enabled {
+		secret = ""
+	}
+
+	err := sqlite.SetTotp(principal.UserId, secret, enabled)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to update TOTP", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	Audit(r, principal.UserId, map[string]bool{"totp_enabled": credential.TotpEnabled}, map[string]bool{"totp_enabled": enabled})
+
+	w.WriteHeader(http.StatusOK)
+	if enabled {
+		w.Write([]byte(`{"message": "TOTP enabled"}`))
+	} else {
+		w.Write([]byte(`{"message": "TOTP disabled"}`))
+	}
+}
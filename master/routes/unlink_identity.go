@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+func UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	provider := r.URL.Query().Get("provider")
+	if provider == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "provider is required"}`))
+		return
+	}
+
+	principal, _ := rbac.FromContext(r.Context())
+	ok, err := sqlite.UnlinkIdentity(principal.UserId, provider)
+	if errors.Is(err, sqlite.ErrLastSignInMethod) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message": "The last sign-in method can not be removed"}`))
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Failed to unlink identity", "error": "` + err.Error() + `"}`))
+		return
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Identity not found"}`))
+		return
+	}
+
+	Audit(r, principal.UserId, map[string]string{"provider": provider}, nil)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Identity unlinked"}`))
+}
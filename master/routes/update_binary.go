@@ -8,21 +8,25 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/utils"
 )
 
 func UpdateBinary(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	archQuery := r.URL.Query().Get("arch")
-	if archQuery == "" {
+	platform, ok := platformFromQuery(r)
+	if !ok {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"message": "arch is required"}`))
+		w.Write([]byte(`{"message": "platform (os/arch[/variant]) or arch is required"}`))
 		return
 	}
 
+	fileName := "updates/" + utils.PlatformFileName(platform)
+
 	// get file from form
 	file, _, err := r.FormFile("file")
 	if err != nil {
@@ -33,7 +37,7 @@ func UpdateBinary(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	// save file
-	err = saveFile("updates/wirednode-"+archQuery, file)
+	err = saveFile(fileName, file)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(`{"message": "Error saving file"}`))
@@ -41,17 +45,41 @@ func UpdateBinary(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// get file hash
-	hash := getFileHash("updates/wirednode-" + archQuery)
+	hash := getFileHash(fileName)
 	if hash == "" {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(`{"message": "Error getting file hash"}`))
 		return
 	}
 
-	config.SetCurrentNodeHash(hash, archQuery)
+	before := config.GetNodeReleases()[platform]
+	config.SetCurrentNodeHash(hash, platform)
+	Audit(r, platform, map[string]string{"hash": before}, map[string]string{"hash": hash})
 
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"message": "Binary uploaded"}`))
+	w.Write([]byte(`{"message": "Binary uploaded", "platform": "` + platform + `"}`))
+}
+
+// platformFromQuery reads the target platform of a release, either as
+// "platform=freebsd/amd64" / "platform=linux/arm/v7" or as the legacy
+// "arch=amd64" which always meant linux
+func platformFromQuery(r *http.Request) (string, bool) {
+	platform := r.URL.Query().Get("platform")
+	if platform == "" {
+		arch := r.URL.Query().Get("arch")
+		if arch == "" {
+			return "", false
+		}
+
+		platform = utils.Platform("linux", arch, "")
+	}
+
+	goos, goarch, variant, ok := utils.ParsePlatform(platform)
+	if !ok {
+		return "", false
+	}
+
+	return utils.Platform(goos, goarch, variant), true
 }
 
 func saveFile(fileName string, file multipart.File) error {
@@ -61,20 +89,26 @@ func saveFile(fileName string, file multipart.File) error {
 		return err
 	}
 
-	// create file
-	f, err := os.Create(fileName)
+	// write next to the target and rename it into place, so readers like
+	// running transfers never see a partial file
+	f, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
 
-	// copy file
 	_, err = io.Copy(f, file)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), fileName)
 }
 
 func getFileHash(fileName string) string {
@@ -0,0 +1,101 @@
+package routes
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+var AssetSignalChannel = make(chan bool, 8)
+
+func UploadAsset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := r.URL.Query().Get("name")
+	if !utils.ValidAssetName(name) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "name is required and may only contain letters, digits, '.', '_' and '-'"}`))
+		return
+	}
+
+	// comma separated node ids, empty means every node
+	var nodes []string
+	for _, n := range strings.Split(r.URL.Query().Get("nodes"), ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+
+		_, ok, err := sqlite.GetNode(n)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"message": "Failed to look up node", "error": "` + err.Error() + `"}`))
+			return
+		}
+
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message": "Unknown node", "node_id": "` + n + `"}`))
+			return
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "Error getting file"}`))
+		return
+	}
+	defer file.Close()
+
+	err = os.MkdirAll("assets", os.ModePerm)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Error saving file"}`))
+		return
+	}
+
+	err = saveFile("assets/"+name, file)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Error saving file"}`))
+		return
+	}
+
+	hash := getFileHash("assets/" + name)
+	stat, err := os.Stat("assets/" + name)
+	if hash == "" || err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message": "Error getting file hash"}`))
+		return
+	}
+
+	asset := config.Asset{
+		Name:      name,
+		Version:   1,
+		Hash:      hash,
+		Size:      stat.Size(),
+		Nodes:     nodes,
+		UpdatedAt: time.Now().Unix(),
+	}
+
+	var before any
+	if previous, ok := config.GetAsset(name); ok {
+		asset.Version = previous.Version + 1
+		before = previous
+	}
+
+	config.SetAsset(asset)
+	Audit(r, name, before, asset)
+	AssetSignalChannel <- true
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"message": "Asset uploaded", "hash": "` + hash + `"}`))
+}
@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"net/http"
+	"wiredmaster/api"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+// V2 lists the endpoints of /api/v2. Audit actions match the former
+// endpoints so the audit log can be filtered across both versions.
+var V2 = []api.Endpoint{
+	{Method: http.MethodGet, Path: "/routes", Summary: "List routes", Permission: rbac.RoutesRead, Response: sqlite.Route{}, Paginated: true, Query: []api.Param{
+		{Name: "tag", Description: "Only routes with this tag"},
+	}, Handle: v2ListRoutes},
+	{Method: http.MethodPost, Path: "/routes", Summary: "Add a route", Permission: rbac.RoutesWrite, Action: "routes.add", Status: http.StatusCreated, Request: RouteInput{}, Response: sqlite.Route{}, Handle: v2CreateRoute},
+	{Method: http.MethodGet, Path: "/routes/{id}", Summary: "Get a route", Permission: rbac.RoutesRead, Response: sqlite.Route{}, Handle: v2GetRoute},
+	{Method: http.MethodPatch, Path: "/routes/{id}", Summary: "Change fields of a route, pass its revision to detect concurrent changes", Permission: rbac.RoutesWrite, Action: "routes.update", Request: RoutePatch{}, Response: sqlite.Route{}, Handle: v2UpdateRoute},
+	{Method: http.MethodDelete, Path: "/routes/{id}", Summary: "Remove a route", Permission: rbac.RoutesWrite, Action: "routes.remove", Handle: v2DeleteRoute},
+	{Method: http.MethodGet, Path: "/routes/{id}/nodes", Summary: "List the nodes serving a route", Permission: rbac.RoutesRead, Response: RouteNode{}, Paginated: true, Handle: v2RouteNodes},
+
+	{Method: http.MethodGet, Path: "/nodes", Summary: "List nodes", Permission: rbac.NodesRead, Response: NodeInfo{}, Paginated: true, Query: []api.Param{
+		{Name: "group", Description: "Only nodes in this group"},
+	}, Handle: v2ListNodes},
+	{Method: http.MethodPost, Path: "/nodes", Summary: "Add a node", Permission: rbac.NodesManage, Action: "node.add", Status: http.StatusCreated, Request: NodeInput{}, Response: NodeInfo{}, Handle: v2CreateNode},
+	{Method: http.MethodGet, Path: "/nodes/{id}", Summary: "Get a node", Permission: rbac.NodesRead, Response: NodeInfo{}, Handle: v2GetNode},
+	{Method: http.MethodPatch, Path: "/nodes/{id}", Summary: "Change the groups of a node", Permission: rbac.NodesManage, Action: "node.update", Request: NodePatch{}, Response: NodeInfo{}, Handle: v2UpdateNode},
+	{Method: http.MethodDelete, Path: "/nodes/{id}", Summary: "Delete a node", Permission: rbac.NodesManage, Action: "node.delete", Handle: v2DeleteNode},
+
+	{Method: http.MethodGet, Path: "/players", Summary: "List online players", Permission: rbac.PlayersRead, Response: PlayerInfo{}, Paginated: true, Query: playerFilterParams, Handle: v2ListPlayers},
+	{Method: http.MethodGet, Path: "/players/counts", Summary: "Count online players per route", Permission: rbac.PlayersRead, Response: PlayerCounts{}, Query: playerFilterParams, Handle: v2CountPlayers},
+	{Method: http.MethodGet, Path: "/players/sessions", Summary: "List finished player sessions, newest first", Permission: rbac.PlayersRead, Response: sqlite.PlayerSession{}, Paginated: true, Query: playerSessionsParams, Handle: v2ListPlayerSessions},
+	{Method: http.MethodGet, Path: "/players/stats", Summary: "Daily unique players, sessions, playtime and peak concurrency", Permission: rbac.PlayersRead, Response: PlayerStatsSummary{}, Query: playerStatsParams, Handle: v2GetPlayerStats},
+	{Method: http.MethodGet, Path: "/players/top", Summary: "Rank players by playtime", Permission: rbac.PlayersRead, Response: []sqlite.TopPlayer{}, Query: topPlayersParams, Handle: v2TopPlayers},
+
+	{Method: http.MethodGet, Path: "/users", Summary: "List users", Permission: rbac.UsersManage, Response: sqlite.User{}, Paginated: true, Handle: v2ListUsers},
+	{Method: http.MethodPost, Path: "/users", Summary: "Create a user that signs in with a password", Permission: rbac.UsersManage, Action: "users.create", Status: http.StatusCreated, Request: UserInput{}, Response: sqlite.User{}, Handle: v2CreateUser},
+	{Method: http.MethodGet, Path: "/users/{id}", Summary: "Get a user", Permission: rbac.UsersManage, Response: sqlite.User{}, Handle: v2GetUser},
+	{Method: http.MethodPatch, Path: "/users/{id}", Summary: "Change the role of a user", Permission: rbac.UsersManage, Action: "users.role", Request: UserPatch{}, Response: sqlite.User{}, Handle: v2UpdateUser},
+
+	{Method: http.MethodGet, Path: "/roles", Summary: "List roles and known permissions", Permission: rbac.UsersManage, Response: RolesResponse{}, Handle: v2ListRoles},
+	{Method: http.MethodPut, Path: "/roles/{name}", Summary: "Create a role or replace its permissions", Permission: rbac.UsersManage, Action: "roles.set", Request: RoleInput{}, Response: sqlite.Role{}, Handle: v2SetRole},
+	{Method: http.MethodDelete, Path: "/roles/{name}", Summary: "Delete a role no user holds", Permission: rbac.UsersManage, Action: "roles.delete", Handle: v2DeleteRole},
+
+	{Method: http.MethodGet, Path: "/tokens", Summary: "List API tokens", Response: sqlite.ApiToken{}, Paginated: true, Handle: v2ListTokens},
+	{Method: http.MethodPost, Path: "/tokens", Summary: "Create an API token, the token is only returned once", Action: "tokens.create", Status: http.StatusCreated, Request: TokenInput{}, Response: CreatedToken{}, Handle: v2CreateToken},
+	{Method: http.MethodDelete, Path: "/tokens/{id}", Summary: "Revoke an API token", Action: "tokens.revoke", Handle: v2RevokeToken},
+
+	{Method: http.MethodGet, Path: "/audit", Summary: "List audit entries, newest first", Permission: rbac.AuditRead, LeaderOnly: true, Response: sqlite.AuditEntry{}, Paginated: true, Query: []api.Param{
+		{Name: "actor", Description: "User id"},
+		{Name: "action", Description: "Action or action prefix, e.g. routes"},
+		{Name: "target"},
+		{Name: "since", Description: "Unix seconds"},
+		{Name: "until", Description: "Unix seconds"},
+	}, Handle: v2ListAudit},
+}
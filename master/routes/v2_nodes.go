@@ -0,0 +1,291 @@
+package routes
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"wiredmaster/api"
+	"wiredmaster/logs"
+
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+type NodeInput struct {
+	Id         string   `json:"id" required:"true" doc:"e.g. dus001, nodes connect as <id>.<wired_host>"`
+	Passphrase string   `json:"passphrase" required:"true"`
+	Groups     []string `json:"groups,omitempty" doc:"e.g. eu-shield, routes placed on a group are served by its nodes"`
+}
+
+func (in NodeInput) Validate() map[string]string {
+	fields := NodePatch{Groups: &in.Groups}.Validate()
+	if in.Id != "" && !utils.ValidAssetName(in.Id) {
+		fields["id"] = "may only contain letters, digits, '.', '_' and '-'"
+	}
+
+	return fields
+}
+
+const maxNodeGroups = 16
+
+type NodePatch struct {
+	Groups *[]string `json:"groups,omitempty" doc:"replaces all groups"`
+}
+
+func (in NodePatch) Validate() map[string]string {
+	fields := map[string]string{}
+	if in.Groups != nil && !validLabels(*in.Groups, maxNodeGroups) {
+		fields["groups"] = "must be at most " + strconv.Itoa(maxNodeGroups) + " distinct groups of lower case letters, digits, - and _"
+	}
+
+	return fields
+}
+
+// NodeInfo is a node as the v2 API reports it
+type NodeInfo struct {
+	Id             string   `json:"id"`
+	Online         bool     `json:"online"`
+	Address        string   `json:"address,omitempty"`
+	LastConnection int64    `json:"last_connection"`
+	Groups         []string `json:"groups"`
+
+	// connection of online nodes
+	Version     string            `json:"version,omitempty"`
+	Platform    string            `json:"platform,omitempty" doc:"e.g. linux/amd64/v3"`
+	ConnectedAt int64             `json:"connected_at,omitempty"`
+	LastPing    int64             `json:"last_ping,omitempty"`
+	RTT         float64           `json:"rtt_ms,omitempty" doc:"round trip time of the latest heartbeat"`
+	Jitter      float64           `json:"jitter_ms,omitempty"`
+	RTTHistory  []utils.RTTSample `json:"rtt_history,omitempty" doc:"oldest first"`
+	Health      string            `json:"health,omitempty" doc:"healthy, degraded after a missed heartbeat or dead"`
+
+	Telemetry []NodeTelemetry `json:"telemetry,omitempty" doc:"recent host reports, oldest first, only when getting a single node"`
+}
+
+// NodeTelemetry is a report of a node about its host
+type NodeTelemetry struct {
+	At              int64      `json:"at"`
+	CPU             float64    `json:"cpu_percent" doc:"of all cores, since the previous report"`
+	Cores           int        `json:"cores"`
+	MemoryTotal     uint64     `json:"memory_total_bytes"`
+	MemoryAvailable uint64     `json:"memory_available_bytes"`
+	Load            [3]float64 `json:"load_average" doc:"over 1, 5 and 15 minutes"`
+	OpenFiles       uint64     `json:"open_files"`
+	MaxOpenFiles    uint64     `json:"max_open_files" doc:"LimitNOFILE of the node, 0 if unknown"`
+	Goroutines      int        `json:"goroutines"`
+	ReceiveRate     float64    `json:"network_rx_bytes_per_second"`
+	TransmitRate    float64    `json:"network_tx_bytes_per_second"`
+	Uptime          int64      `json:"uptime_seconds" doc:"of the host"`
+	ProcessUptime   int64      `json:"process_uptime_seconds"`
+}
+
+func nodeTelemetry(nodeId string) []NodeTelemetry {
+	samples := utils.GetTelemetry(nodeId)
+	reports := make([]NodeTelemetry, 0, len(samples))
+	for _, sample := range samples {
+		reports = append(reports, NodeTelemetry{
+			At:              sample.At.Unix(),
+			CPU:             sample.CPU,
+			Cores:           sample.Cores,
+			MemoryTotal:     sample.MemTotal,
+			MemoryAvailable: sample.MemAvailable,
+			Load:            [3]float64{sample.Load1, sample.Load5, sample.Load15},
+			OpenFiles:       sample.OpenFiles,
+			MaxOpenFiles:    sample.MaxOpenFiles,
+			Goroutines:      sample.Goroutines,
+			ReceiveRate:     sample.RxRate,
+			TransmitRate:    sample.TxRate,
+			Uptime:          sample.Uptime,
+			ProcessUptime:   sample.ProcessUptime,
+		})
+	}
+
+	return reports
+}
+
+func listNodes() ([]NodeInfo, error) {
+	stored, err := sqlite.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	online := make(map[string]utils.Node)
+	for _, client := range utils.ListClients() {
+		online[client.Key] = client.Data
+	}
+
+	nodes := make([]NodeInfo, 0, len(stored))
+	for _, node := range stored {
+		info := NodeInfo{
+			Id:             node.Id,
+			LastConnection: node.LastConnection,
+			Groups:         node.Groups,
+		}
+
+		if data, ok := online[node.Id]; ok {
+			info.Online = true
+			info.Address = data.Address
+			info.Version = data.Version
+			info.Platform = data.Platform
+			info.ConnectedAt = data.ConnectedAt.Unix()
+			info.LastPing = data.LastPing.Unix()
+
+			if data.Liveness != nil {
+				stats := data.Liveness.Stats()
+				info.RTT, info.Jitter, info.RTTHistory = milliseconds(stats.RTT), milliseconds(stats.Jitter), stats.History
+				info.Health = string(data.Liveness.State())
+			}
+		}
+
+		nodes = append(nodes, info)
+	}
+
+	return nodes, nil
+}
+
+func createNode(r *http.Request, in NodeInput) (NodeInfo, error) {
+	_, exists, err := sqlite.GetNode(in.Id)
+	if err != nil {
+		return NodeInfo{}, api.Internal("Failed to look up node", err)
+	}
+
+	if exists {
+		return NodeInfo{}, api.Conflict("Node already exists")
+	}
+
+	groups := append([]string{}, in.Groups...)
+	err = sqlite.AddNode(sqlite.Node{
+		Id:         in.Id,
+		Passphrase: in.Passphrase,
+		Groups:     groups,
+	})
+	if err != nil {
+		return NodeInfo{}, api.Internal("Failed to add node", err)
+	}
+
+	// passphrases stay out of the audit log
+	Audit(r, in.Id, nil, map[string]any{"id": in.Id, "groups": groups})
+
+	return NodeInfo{Id: in.Id, Groups: groups}, nil
+}
+
+// updateNode changes the groups of a node, the routes it serves follow
+func updateNode(r *http.Request, nodeId string, in NodePatch) (NodeInfo, error) {
+	node, err := getNodeInfo(nodeId)
+	if err != nil {
+		return NodeInfo{}, err
+	}
+
+	if in.Groups == nil || slices.Equal(*in.Groups, node.Groups) {
+		return node, nil
+	}
+
+	before := map[string]any{"id": nodeId, "groups": node.Groups}
+	node.Groups = append([]string{}, *in.Groups...)
+
+	found, err := sqlite.SetNodeGroups(nodeId, node.Groups)
+	if err != nil {
+		return NodeInfo{}, api.Internal("Failed to update node", err)
+	}
+
+	if !found {
+		return NodeInfo{}, api.NotFound("Node not found")
+	}
+
+	Audit(r, nodeId, before, map[string]any{"id": nodeId, "groups": node.Groups})
+	SignalChannel <- true
+
+	return node, nil
+}
+
+func getNodeInfo(nodeId string) (NodeInfo, error) {
+	nodes, err := listNodes()
+	if err != nil {
+		return NodeInfo{}, api.Internal("Failed to get nodes", err)
+	}
+
+	for _, node := range nodes {
+		if node.Id == nodeId {
+			return node, nil
+		}
+	}
+
+	return NodeInfo{}, api.NotFound("Node not found")
+}
+
+// getNodeDetails returns a node with the recent reports about its host
+func getNodeDetails(nodeId string) (NodeInfo, error) {
+	node, err := getNodeInfo(nodeId)
+	if err != nil {
+		return NodeInfo{}, err
+	}
+
+	node.Telemetry = nodeTelemetry(nodeId)
+	return node, nil
+}
+
+func deleteNode(r *http.Request, nodeId string) error {
+	found, err := sqlite.DeleteNode(nodeId)
+	if err != nil {
+		return api.Internal("Failed to delete node", err)
+	}
+
+	if !found {
+		return api.NotFound("Node not found")
+	}
+
+	utils.RemoveTelemetry(nodeId)
+	logs.Remove(nodeId)
+	Audit(r, nodeId, map[string]string{"id": nodeId}, nil)
+	return nil
+}
+
+func v2ListNodes(r *http.Request) (any, error) {
+	nodes, err := listNodes()
+	if err != nil {
+		return nil, api.Internal("Failed to get nodes", err)
+	}
+
+	if group := r.URL.Query().Get("group"); group != "" {
+		grouped := []NodeInfo{}
+		for _, node := range nodes {
+			if slices.Contains(node.Groups, group) {
+				grouped = append(grouped, node)
+			}
+		}
+
+		nodes = grouped
+	}
+
+	return api.Paginate(r, nodes, func(node NodeInfo) string {
+		return node.Id
+	})
+}
+
+func v2GetNode(r *http.Request) (any, error) {
+	return getNodeDetails(r.PathValue("id"))
+}
+
+func v2CreateNode(r *http.Request) (any, error) {
+	var in NodeInput
+	err := api.Decode(r, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	return createNode(r, in)
+}
+
+func v2UpdateNode(r *http.Request) (any, error) {
+	var in NodePatch
+	err := api.Decode(r, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	return updateNode(r, r.PathValue("id"), in)
+}
+
+func v2DeleteNode(r *http.Request) (any, error) {
+	return nil, deleteNode(r, r.PathValue("id"))
+}
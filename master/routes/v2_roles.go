@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"wiredmaster/api"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+type RoleInput struct {
+	Permissions []rbac.Permission `json:"permissions" required:"true"`
+}
+
+func (in RoleInput) Validate() map[string]string {
+	fields := map[string]string{}
+	for _, p := range in.Permissions {
+		if !rbac.Valid(p) {
+			fields["permissions"] = "contains the unknown permission " + string(p)
+		}
+	}
+
+	return fields
+}
+
+type RolesResponse struct {
+	Roles       []sqlite.Role     `json:"roles"`
+	Permissions []rbac.Permission `json:"permissions"` // every known permission
+}
+
+// checkGrant fails unless the caller holds every permission it hands out,
+// like createApiToken nobody gains permissions through a role. Only callers
+// holding * may grant it.
+func checkGrant(r *http.Request, permissions []rbac.Permission) error {
+	principal, _ := rbac.FromContext(r.Context())
+	for _, p := range permissions {
+		if !principal.Has(p) {
+			return api.Forbidden(string(p))
+		}
+	}
+
+	return nil
+}
+
+func setRole(r *http.Request, name string, permissions []rbac.Permission) (sqlite.Role, error) {
+	if !utils.ValidAssetName(name) {
+		return sqlite.Role{}, api.Invalid(map[string]string{"name": "may only contain letters, digits, '.', '_' and '-'"})
+	}
+
+	if name == rbac.AdminRole {
+		return sqlite.Role{}, api.BadRequest("The admin role can not be changed")
+	}
+
+	err := checkGrant(r, permissions)
+	if err != nil {
+		return sqlite.Role{}, err
+	}
+
+	before, existed, err := sqlite.GetRole(name)
+	if err != nil {
+		return sqlite.Role{}, api.Internal("Failed to look up role", err)
+	}
+
+	role := sqlite.Role{
+		Name:        name,
+		Permissions: make([]string, 0, len(permissions)),
+	}
+
+	for _, p := range permissions {
+		role.Permissions = append(role.Permissions, string(p))
+	}
+
+	err = sqlite.SetRole(role)
+	if err != nil {
+		return sqlite.Role{}, api.Internal("Failed to save role", err)
+	}
+
+	if existed {
+		Audit(r, name, before, role)
+	} else {
+		Audit(r, name, nil, role)
+	}
+
+	return role, nil
+}
+
+func deleteRole(r *http.Request, name string) error {
+	if name == rbac.AdminRole {
+		return api.BadRequest("The admin role can not be deleted")
+	}
+
+	before, _, err := sqlite.GetRole(name)
+	if err != nil {
+		return api.Internal("Failed to look up role", err)
+	}
+
+	found, err := sqlite.DeleteRole(name)
+	if errors.Is(err, sqlite.ErrRoleInUse) {
+		return api.Conflict("Role is still assigned to users")
+	}
+
+	if err != nil {
+		return api.Internal("Failed to delete role", err)
+	}
+
+	if !found {
+		return api.NotFound("Role not found")
+	}
+
+	Audit(r, name, before, nil)
+	return nil
+}
+
+func v2ListRoles(r *http.Request) (any, error) {
+	roles, err := sqlite.GetRoles()
+	if err != nil {
+		return nil, api.Internal("Failed to get roles", err)
+	}
+
+	return RolesResponse{
+		Roles:       roles,
+		Permissions: rbac.Permissions,
+	}, nil
+}
+
+func v2SetRole(r *http.Request) (any, error) {
+	var in RoleInput
+	err := api.Decode(r, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	return setRole(r, r.PathValue("name"), in.Permissions)
+}
+
+func v2DeleteRole(r *http.Request) (any, error) {
+	return nil, deleteRole(r, r.PathValue("name"))
+}
@@ -0,0 +1,595 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"wiredmaster/api"
+	"wiredmaster/events"
+
+	"wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+type RouteInput struct {
+	ServerHost     string                   `json:"server_host" required:"true"`
+	ServerPort     string                   `json:"server_port" required:"true"`
+	ProxyDomain    string                   `json:"proxy_domain" required:"true"`
+	ProxyPort      string                   `json:"proxy_port" required:"true"`
+	Owner          *string                  `json:"owner,omitempty" doc:"user id, requires routes:all, defaults to the caller"`
+	Name           string                   `json:"name,omitempty"`
+	Description    string                   `json:"description,omitempty"`
+	Enabled        *bool                    `json:"enabled,omitempty" doc:"defaults to true"`
+	Tags           []string                 `json:"tags,omitempty"`
+	ConnectTimeout int                      `json:"connect_timeout,omitempty" doc:"seconds, 0 uses the node default"`
+	IdleTimeout    int                      `json:"idle_timeout,omitempty" doc:"seconds, 0 never closes idle connections"`
+	Protocols      []protocol.ProtocolRange `json:"protocols,omitempty" doc:"allowed protocol versions, all if empty"`
+	Nodes          []string                 `json:"nodes,omitempty" doc:"node ids serving the route, every node if nodes and groups are empty, requires nodes:manage or routes:all"`
+	Groups         []string                 `json:"groups,omitempty" doc:"node groups serving the route, requires nodes:manage or routes:all"`
+}
+
+func (in RouteInput) Validate() map[string]string {
+	return in.patch().Validate()
+}
+
+// patch returns the input as a patch of every field
+func (in RouteInput) patch() RoutePatch {
+	return RoutePatch{
+		ServerHost:     &in.ServerHost,
+		ServerPort:     &in.ServerPort,
+		ProxyDomain:    &in.ProxyDomain,
+		ProxyPort:      &in.ProxyPort,
+		Owner:          in.Owner,
+		Name:           &in.Name,
+		Description:    &in.Description,
+		Enabled:        in.Enabled,
+		Tags:           &in.Tags,
+		ConnectTimeout: &in.ConnectTimeout,
+		IdleTimeout:    &in.IdleTimeout,
+		Protocols:      &in.Protocols,
+		Nodes:          &in.Nodes,
+		Groups:         &in.Groups,
+	}
+}
+
+// RoutePatch changes the given fields of a route, the route id stays
+type RoutePatch struct {
+	ServerHost     *string                   `json:"server_host,omitempty"`
+	ServerPort     *string                   `json:"server_port,omitempty"`
+	ProxyDomain    *string                   `json:"proxy_domain,omitempty"`
+	ProxyPort      *string                   `json:"proxy_port,omitempty"`
+	Owner          *string                   `json:"owner,omitempty" doc:"requires routes:all"`
+	Name           *string                   `json:"name,omitempty"`
+	Description    *string                   `json:"description,omitempty"`
+	Enabled        *bool                     `json:"enabled,omitempty"`
+	Tags           *[]string                 `json:"tags,omitempty" doc:"replaces all tags"`
+	ConnectTimeout *int                      `json:"connect_timeout,omitempty"`
+	IdleTimeout    *int                      `json:"idle_timeout,omitempty"`
+	Protocols      *[]protocol.ProtocolRange `json:"protocols,omitempty" doc:"replaces all ranges"`
+	Nodes          *[]string                 `json:"nodes,omitempty" doc:"replaces the node placement, requires nodes:manage or routes:all"`
+	Groups         *[]string                 `json:"groups,omitempty" doc:"replaces the group placement, requires nodes:manage or routes:all"`
+	Revision       *int64                    `json:"revision,omitempty" doc:"revision the change is based on, the request fails with 409 if the route changed since"`
+}
+
+const (
+	maxRouteName        = 64
+	maxRouteDescription = 512
+	maxRouteTags        = 16
+	maxTagLength        = 32
+	maxConnectTimeout   = 60
+	maxIdleTimeout      = 24 * 60 * 60
+	maxProtocolRanges   = 16
+	maxPlacement        = 64
+)
+
+func (in RoutePatch) Validate() map[string]string {
+	fields := map[string]string{}
+	if in.ServerHost != nil && (*in.ServerHost == "" || strings.ContainsAny(*in.ServerHost, " /:")) {
+		fields["server_host"] = "must be a host name or IPv4 address"
+	}
+
+	if in.ProxyDomain != nil && !validDomain(*in.ProxyDomain) {
+		fields["proxy_domain"] = "must be a lower case domain name"
+	}
+
+	for name, port := range map[string]*string{"server_port": in.ServerPort, "proxy_port": in.ProxyPort} {
+		if port == nil {
+			continue
+		}
+
+		n, err := strconv.Atoi(*port)
+		if err != nil || n < 1 || n > 65535 {
+			fields[name] = "must be a port number"
+		}
+	}
+
+	if in.Name != nil && len(*in.Name) > maxRouteName {
+		fields["name"] = "must be at most " + strconv.Itoa(maxRouteName) + " bytes"
+	}
+
+	if in.Description != nil && len(*in.Description) > maxRouteDescription {
+		fields["description"] = "must be at most " + strconv.Itoa(maxRouteDescription) + " bytes"
+	}
+
+	if in.Tags != nil && !validLabels(*in.Tags, maxRouteTags) {
+		fields["tags"] = "must be at most " + strconv.Itoa(maxRouteTags) + " distinct tags of lower case letters, digits, - and _"
+	}
+
+	if in.ConnectTimeout != nil && (*in.ConnectTimeout < 0 || *in.ConnectTimeout > maxConnectTimeout) {
+		fields["connect_timeout"] = "must be between 0 and " + strconv.Itoa(maxConnectTimeout)
+	}
+
+	if in.IdleTimeout != nil && (*in.IdleTimeout < 0 || *in.IdleTimeout > maxIdleTimeout) {
+		fields["idle_timeout"] = "must be between 0 and " + strconv.Itoa(maxIdleTimeout)
+	}
+
+	if in.Protocols != nil && !validProtocols(*in.Protocols) {
+		fields["protocols"] = "must be at most " + strconv.Itoa(maxProtocolRanges) + " ranges with 0 <= min <= max"
+	}
+
+	if in.Nodes != nil && !validNodeIds(*in.Nodes) {
+		fields["nodes"] = "must be at most " + strconv.Itoa(maxPlacement) + " distinct node ids"
+	}
+
+	if in.Groups != nil && !validLabels(*in.Groups, maxPlacement) {
+		fields["groups"] = "must be at most " + strconv.Itoa(maxPlacement) + " distinct groups of lower case letters, digits, - and _"
+	}
+
+	if in.Revision != nil && *in.Revision < 1 {
+		fields["revision"] = "must be a revision of the route"
+	}
+
+	return fields
+}
+
+// apply copies the set fields onto route, permissions are checked by the caller
+func (in RoutePatch) apply(route *sqlite.Route) {
+	if in.ServerHost != nil {
+		route.ServerHost = *in.ServerHost
+	}
+
+	if in.ServerPort != nil {
+		route.ServerPort = *in.ServerPort
+	}
+
+	if in.ProxyDomain != nil {
+		route.ProxyDomain = *in.ProxyDomain
+	}
+
+	if in.ProxyPort != nil {
+		route.ProxyPort = *in.ProxyPort
+	}
+
+	if in.Owner != nil {
+		route.Owner = *in.Owner
+	}
+
+	if in.Name != nil {
+		route.Name = *in.Name
+	}
+
+	if in.Description != nil {
+		route.Description = *in.Description
+	}
+
+	if in.Enabled != nil {
+		route.Enabled = *in.Enabled
+	}
+
+	if in.Tags != nil {
+		route.Tags = append([]string{}, *in.Tags...)
+	}
+
+	if in.ConnectTimeout != nil {
+		route.ConnectTimeout = *in.ConnectTimeout
+	}
+
+	if in.IdleTimeout != nil {
+		route.IdleTimeout = *in.IdleTimeout
+	}
+
+	if in.Protocols != nil {
+		route.Protocols = nil
+		if len(*in.Protocols) > 0 {
+			route.Protocols = append(route.Protocols, *in.Protocols...)
+		}
+	}
+
+	if in.Nodes != nil {
+		route.Nodes = append([]string{}, *in.Nodes...)
+	}
+
+	if in.Groups != nil {
+		route.Groups = append([]string{}, *in.Groups...)
+	}
+}
+
+// validLabels checks tags and group names
+func validLabels(labels []string, max int) bool {
+	if len(labels) > max {
+		return false
+	}
+
+	seen := map[string]bool{}
+	for _, label := range labels {
+		if label == "" || len(label) > maxTagLength || seen[label] {
+			return false
+		}
+
+		seen[label] = true
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func validNodeIds(ids []string) bool {
+	if len(ids) > maxPlacement {
+		return false
+	}
+
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if !utils.ValidAssetName(id) || seen[id] {
+			return false
+		}
+
+		seen[id] = true
+	}
+
+	return true
+}
+
+func validProtocols(ranges []protocol.ProtocolRange) bool {
+	if len(ranges) > maxProtocolRanges {
+		return false
+	}
+
+	for _, p := range ranges {
+		if p.Min < 0 || p.Max < p.Min {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validDomain(domain string) bool {
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '*' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func listRoutes(r *http.Request) ([]sqlite.Route, error) {
+	principal, _ := rbac.FromContext(r.Context())
+	if principal.Has(rbac.RoutesAll) {
+		return sqlite.GetRoutes()
+	}
+
+	return sqlite.GetRoutesByOwner(principal.UserId)
+}
+
+// accessibleRoute looks up a route of the caller, routes of other owners
+// are reported as missing
+func accessibleRoute(r *http.Request, routeId string) (sqlite.Route, error) {
+	route, ok, err := sqlite.GetRoute(routeId)
+	if err != nil {
+		return sqlite.Route{}, api.Internal("Failed to look up route", err)
+	}
+
+	principal, _ := rbac.FromContext(r.Context())
+	if !ok || !principal.CanAccessRoute(route.Owner) {
+		return sqlite.Route{}, api.NotFound("Route not found")
+	}
+
+	return route, nil
+}
+
+// domainTaken reports a proxy domain used by another route, the id of the
+// route is only revealed to callers that may see it
+func domainTaken(r *http.Request, proxyDomain, routeId string) error {
+	existing, ok, err := sqlite.GetRouteByProxyDomain(proxyDomain)
+	if err != nil {
+		return api.Internal("Failed to look up route", err)
+	}
+
+	if !ok || existing.RouteId == routeId {
+		return nil
+	}
+
+	conflict := api.Conflict("proxy_domain already in use")
+	principal, _ := rbac.FromContext(r.Context())
+	if principal.CanAccessRoute(existing.Owner) {
+		conflict.With("route_id", existing.RouteId)
+	}
+
+	return conflict
+}
+
+func createRoute(r *http.Request, in RouteInput) (sqlite.Route, error) {
+	err := domainTaken(r, in.ProxyDomain, "")
+	if err != nil {
+		return sqlite.Route{}, err
+	}
+
+	// routes belong to their creator, only users managing every
+	// route may hand them to someone else
+	principal, _ := rbac.FromContext(r.Context())
+	if in.Owner != nil {
+		err = checkOwner(principal, *in.Owner)
+		if err != nil {
+			return sqlite.Route{}, err
+		}
+	}
+
+	if len(in.Nodes) > 0 || len(in.Groups) > 0 {
+		err = checkPlacement(principal)
+		if err != nil {
+			return sqlite.Route{}, err
+		}
+	}
+
+	route := sqlite.Route{
+		Route:    protocol.Route{RouteId: randomId()},
+		Owner:    principal.UserId,
+		Enabled:  true,
+		Tags:     []string{},
+		Nodes:    []string{},
+		Groups:   []string{},
+		Revision: 1,
+	}
+	in.patch().apply(&route)
+
+	err = sqlite.AddRoute(route)
+	if errors.Is(err, sqlite.ErrDomainTaken) {
+		return sqlite.Route{}, api.Conflict("proxy_domain already in use")
+	}
+
+	if err != nil {
+		return sqlite.Route{}, api.Internal("Failed to add route", err)
+	}
+
+	Audit(r, route.RouteId, nil, route)
+	events.Publish("route.created", route, rbac.RoutesRead, route.Owner)
+	SignalChannel <- true
+
+	return route, nil
+}
+
+func updateRoute(r *http.Request, routeId string, in RoutePatch) (sqlite.Route, error) {
+	before, err := accessibleRoute(r, routeId)
+	if err != nil {
+		return sqlite.Route{}, err
+	}
+
+	if in.Revision != nil && *in.Revision != before.Revision {
+		return sqlite.Route{}, revisionConflict(before.Revision)
+	}
+
+	if in.Owner != nil {
+		principal, _ := rbac.FromContext(r.Context())
+		err = checkOwner(principal, *in.Owner)
+		if err != nil {
+			return sqlite.Route{}, err
+		}
+	}
+
+	route := before
+	in.apply(&route)
+	if reflect.DeepEqual(route, before) {
+		return route, nil
+	}
+
+	if !slices.Equal(route.Nodes, before.Nodes) || !slices.Equal(route.Groups, before.Groups) {
+		principal, _ := rbac.FromContext(r.Context())
+		err = checkPlacement(principal)
+		if err != nil {
+			return sqlite.Route{}, err
+		}
+	}
+
+	if route.ProxyDomain != before.ProxyDomain {
+		err = domainTaken(r, route.ProxyDomain, route.RouteId)
+		if err != nil {
+			return sqlite.Route{}, err
+		}
+	}
+
+	// the update only applies if nobody else changed the route since
+	// it was read, even without a revision in the request
+	found, err := sqlite.UpdateRoute(route)
+	if errors.Is(err, sqlite.ErrRevisionMismatch) {
+		current, _, _ := sqlite.GetRoute(route.RouteId)
+		return sqlite.Route{}, revisionConflict(current.Revision)
+	}
+
+	if errors.Is(err, sqlite.ErrDomainTaken) {
+		return sqlite.Route{}, api.Conflict("proxy_domain already in use")
+	}
+
+	if err != nil {
+		return sqlite.Route{}, api.Internal("Failed to update route", err)
+	}
+
+	if !found {
+		return sqlite.Route{}, api.NotFound("Route not found")
+	}
+
+	route.Revision++
+	Audit(r, route.RouteId, before, route)
+	events.Publish("route.updated", route, rbac.RoutesRead, before.Owner, route.Owner)
+	SignalChannel <- true
+
+	return route, nil
+}
+
+// checkOwner lets callers managing every route hand a route to an
+// existing user
+func checkOwner(principal rbac.Principal, owner string) error {
+	if !principal.Has(rbac.RoutesAll) {
+		return api.Forbidden(string(rbac.RoutesAll))
+	}
+
+	_, ok, err := sqlite.GetUser(owner)
+	if err != nil {
+		return api.Internal("Failed to look up user", err)
+	}
+
+	if !ok {
+		return api.Invalid(map[string]string{"owner": "must be the id of an existing user"})
+	}
+
+	return nil
+}
+
+// checkPlacement lets only callers managing nodes or every route choose
+// the nodes serving a route
+func checkPlacement(principal rbac.Principal) error {
+	if principal.Has(rbac.NodesManage) || principal.Has(rbac.RoutesAll) {
+		return nil
+	}
+
+	return api.Forbidden(string(rbac.NodesManage))
+}
+
+func revisionConflict(current int64) error {
+	return api.Conflict("Route was changed since the given revision").With("revision", strconv.FormatInt(current, 10))
+}
+
+func deleteRoute(r *http.Request, routeId string) error {
+	route, err := accessibleRoute(r, routeId)
+	if err != nil {
+		return err
+	}
+
+	found, err := sqlite.DeleteRoute(routeId)
+	if err != nil {
+		return api.Internal("Failed to remove route", err)
+	}
+
+	if !found {
+		return api.NotFound("Route not found")
+	}
+
+	Audit(r, routeId, route, nil)
+	events.Publish("route.deleted", route, rbac.RoutesRead, route.Owner)
+	SignalChannel <- true
+
+	return nil
+}
+
+func v2ListRoutes(r *http.Request) (any, error) {
+	routes, err := listRoutes(r)
+	if err != nil {
+		return nil, api.Internal("Failed to get routes", err)
+	}
+
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		tagged := []sqlite.Route{}
+		for _, route := range routes {
+			if slices.Contains(route.Tags, tag) {
+				tagged = append(tagged, route)
+			}
+		}
+
+		routes = tagged
+	}
+
+	return api.Paginate(r, routes, func(route sqlite.Route) string {
+		return route.RouteId
+	})
+}
+
+func v2GetRoute(r *http.Request) (any, error) {
+	return accessibleRoute(r, r.PathValue("id"))
+}
+
+func v2CreateRoute(r *http.Request) (any, error) {
+	var in RouteInput
+	err := api.Decode(r, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	return createRoute(r, in)
+}
+
+func v2UpdateRoute(r *http.Request) (any, error) {
+	var in RoutePatch
+	err := api.Decode(r, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	return updateRoute(r, r.PathValue("id"), in)
+}
+
+// RouteNode is a node serving a route
+type RouteNode struct {
+	Id     string `json:"id"`
+	Online bool   `json:"online"`
+}
+
+// routeNodes returns the nodes a route is placed on
+func routeNodes(r *http.Request, routeId string) ([]RouteNode, error) {
+	route, err := accessibleRoute(r, routeId)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := sqlite.GetNodes()
+	if err != nil {
+		return nil, api.Internal("Failed to get nodes", err)
+	}
+
+	online := utils.GetClients()
+	serving := []RouteNode{}
+	for _, node := range nodes {
+		if !route.Enabled || !route.PlacedOn(node) {
+			continue
+		}
+
+		_, ok := online[node.Id]
+		serving = append(serving, RouteNode{Id: node.Id, Online: ok})
+	}
+
+	return serving, nil
+}
+
+func v2RouteNodes(r *http.Request) (any, error) {
+	nodes, err := routeNodes(r, r.PathValue("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	return api.Paginate(r, nodes, func(node RouteNode) string {
+		return node.Id
+	})
+}
+
+func v2DeleteRoute(r *http.Request) (any, error) {
+	return nil, deleteRoute(r, r.PathValue("id"))
+}
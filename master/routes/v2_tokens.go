@@ -0,0 +1,203 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"wiredmaster/api"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+	"wired.rip/wiredutils/utils"
+)
+
+const (
+	defaultTokenDays = 90
+	maxTokenDays     = 365
+)
+
+type TokenInput struct {
+	Name      string            `json:"name" required:"true"`
+	Kind      string            `json:"kind,omitempty" doc:"personal (default) or service, service tokens require users:manage"`
+	Scopes    []rbac.Permission `json:"scopes" required:"true" doc:"* grants every permission of the caller"`
+	ExpiresIn int               `json:"expires_in,omitempty" doc:"days, defaults to 90"`
+}
+
+func (in TokenInput) Validate() map[string]string {
+	fields := map[string]string{}
+	if len(in.Name) > 64 {
+		fields["name"] = "may be at most 64 characters long"
+	}
+
+	if in.Kind != "" && in.Kind != sqlite.TokenPersonal && in.Kind != sqlite.TokenService {
+		fields["kind"] = "must be personal or service"
+	}
+
+	if len(in.Scopes) == 0 {
+		fields["scopes"] = "must list at least one known permission"
+	}
+
+	for _, scope := range in.Scopes {
+		if !rbac.Valid(scope) {
+			fields["scopes"] = "must list at least one known permission"
+		}
+	}
+
+	if in.ExpiresIn < 0 || in.ExpiresIn > maxTokenDays {
+		fields["expires_in"] = "must be between 1 and " + strconv.Itoa(maxTokenDays) + " days"
+	}
+
+	return fields
+}
+
+// CreatedToken is the only response that contains the token itself
+type CreatedToken struct {
+	sqlite.ApiToken
+	Token string `json:"token"`
+}
+
+func createApiToken(r *http.Request, in TokenInput) (CreatedToken, error) {
+	principal, _ := rbac.FromContext(r.Context())
+
+	kind := in.Kind
+	if kind == "" {
+		kind = sqlite.TokenPersonal
+	}
+
+	if kind == sqlite.TokenService && !principal.Has(rbac.UsersManage) {
+		return CreatedToken{}, api.Forbidden(string(rbac.UsersManage))
+	}
+
+	// nobody hands out permissions they do not hold themselves. A personal
+	// token with every permission of its owner follows the owner's role,
+	// unless it is created through a token that is limited itself.
+	scopes := in.Scopes
+	for i, scope := range scopes {
+		if scope == rbac.Wildcard && kind == sqlite.TokenPersonal {
+			if principal.TokenId != "" {
+				scopes = append(scopes[:i:i], principal.Permissions...)
+			}
+
+			break
+		}
+
+		if !principal.Has(scope) {
+			return CreatedToken{}, api.Forbidden(string(scope))
+		}
+	}
+
+	days := in.ExpiresIn
+	if days == 0 {
+		days = defaultTokenDays
+	}
+
+	token, err := utils.NewSecret(sqlite.ApiTokenPrefix)
+	if err != nil {
+		return CreatedToken{}, api.Internal("Failed to generate token", err)
+	}
+
+	now := time.Now()
+
+	t := sqlite.ApiToken{
+		Id:        randomId(),
+		Name:      in.Name,
+		Kind:      kind,
+		Owner:     principal.UserId,
+		CreatedBy: principal.UserId,
+		Hash:      utils.HashSecret(token),
+		Scopes:    make([]string, 0, len(scopes)),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.AddDate(0, 0, days).Unix(),
+	}
+
+	if kind == sqlite.TokenService {
+		t.Owner = "service:" + t.Id
+	}
+
+	for _, scope := range scopes {
+		t.Scopes = append(t.Scopes, string(scope))
+	}
+
+	err = sqlite.CreateApiToken(t)
+	if err != nil {
+		return CreatedToken{}, api.Internal("Failed to create token", err)
+	}
+
+	t.Hash = ""
+	Audit(r, t.Id, nil, t)
+
+	return CreatedToken{ApiToken: t, Token: token}, nil
+}
+
+func listApiTokens(r *http.Request) ([]sqlite.ApiToken, error) {
+	principal, _ := rbac.FromContext(r.Context())
+	owner := principal.UserId
+	if principal.Has(rbac.UsersManage) {
+		owner = ""
+	}
+
+	tokens, err := sqlite.GetApiTokens(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tokens {
+		tokens[i].Hash = ""
+	}
+
+	return tokens, nil
+}
+
+// revokeApiToken reports whether the token was revoked before already
+func revokeApiToken(r *http.Request, tokenId string) (bool, error) {
+	t, ok, err := sqlite.GetApiToken(tokenId)
+	if err != nil {
+		return false, api.Internal("Failed to look up token", err)
+	}
+
+	// tokens of other users are reported as missing
+	principal, _ := rbac.FromContext(r.Context())
+	if !ok || t.Owner != principal.UserId && t.CreatedBy != principal.UserId && !principal.Has(rbac.UsersManage) {
+		return false, api.NotFound("Token not found")
+	}
+
+	if t.RevokedAt != 0 {
+		return true, nil
+	}
+
+	err = sqlite.RevokeApiToken(tokenId, time.Now().Unix())
+	if err != nil {
+		return false, api.Internal("Failed to revoke token", err)
+	}
+
+	t.Hash = ""
+	Audit(r, tokenId, t, map[string]bool{"revoked": true})
+
+	return false, nil
+}
+
+func v2ListTokens(r *http.Request) (any, error) {
+	tokens, err := listApiTokens(r)
+	if err != nil {
+		return nil, api.Internal("Failed to get tokens", err)
+	}
+
+	return api.Paginate(r, tokens, func(t sqlite.ApiToken) string {
+		return t.Id
+	})
+}
+
+func v2CreateToken(r *http.Request) (any, error) {
+	var in TokenInput
+	err := api.Decode(r, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	return createApiToken(r, in)
+}
+
+func v2RevokeToken(r *http.Request) (any, error) {
+	_, err := revokeApiToken(r, r.PathValue("id"))
+	return nil, err
+}
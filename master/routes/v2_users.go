@@ -0,0 +1,150 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"wiredmaster/api"
+	"wiredmaster/auth"
+
+	"wired.rip/wiredutils/rbac"
+	"wired.rip/wiredutils/sqlite"
+)
+
+type UserInput struct {
+	Username string `json:"username" required:"true"`
+	Password string `json:"password" required:"true"`
+	Role     string `json:"role,omitempty" doc:"defaults to user"`
+}
+
+type UserPatch struct {
+	Role *string `json:"role,omitempty"`
+}
+
+// checkRole fails for roles that do not exist and roles granting more
+// than the caller holds
+func checkRole(r *http.Request, role string) error {
+	if role == rbac.AdminRole {
+		return checkGrant(r, []rbac.Permission{rbac.Wildcard})
+	}
+
+	found, ok, err := sqlite.GetRole(role)
+	if err != nil {
+		return api.Internal("Failed to look up role", err)
+	}
+
+	if !ok {
+		return api.Invalid(map[string]string{"role": "is not a known role"})
+	}
+
+	permissions := make([]rbac.Permission, 0, len(found.Permissions))
+	for _, p := range found.Permissions {
+		permissions = append(permissions, rbac.Permission(p))
+	}
+
+	return checkGrant(r, permissions)
+}
+
+func createLocalUser(r *http.Request, in UserInput) (sqlite.User, error) {
+	if in.Role == "" {
+		in.Role = "user"
+	}
+
+	err := checkRole(r, in.Role)
+	if err != nil {
+		return sqlite.User{}, err
+	}
+
+	user, err := auth.CreateLocalUser(in.Username, in.Password, in.Role)
+	if errors.Is(err, auth.ErrInvalidUsername) {
+		return sqlite.User{}, api.Invalid(map[string]string{"username": "must be 3 to 32 letters, digits, dots, dashes or underscores"})
+	}
+
+	if errors.Is(err, auth.ErrWeakPassword) {
+		return sqlite.User{}, api.Invalid(map[string]string{"password": "must be at least 10 characters"})
+	}
+
+	if errors.Is(err, sqlite.ErrUsernameTaken) {
+		return sqlite.User{}, api.Conflict("Username is taken")
+	}
+
+	if err != nil {
+		return sqlite.User{}, api.Internal("Failed to create user", err)
+	}
+
+	Audit(r, user.Id, nil, user)
+	return user, nil
+}
+
+func setUserRole(r *http.Request, userId, role string) (sqlite.User, error) {
+	err := checkRole(r, role)
+	if err != nil {
+		return sqlite.User{}, err
+	}
+
+	user, ok, err := sqlite.GetUser(userId)
+	if err != nil {
+		return sqlite.User{}, api.Internal("Failed to look up user", err)
+	}
+
+	if !ok {
+		return sqlite.User{}, api.NotFound("User not found")
+	}
+
+	err = sqlite.ChangeUserRole(userId, role)
+	if err != nil {
+		return sqlite.User{}, api.Internal("Failed to change user role", err)
+	}
+
+	Audit(r, userId, map[string]string{"role": user.Role}, map[string]string{"role": role})
+
+	user.Role = role
+	return user, nil
+}
+
+func v2ListUsers(r *http.Request) (any, error) {
+	users, err := sqlite.GetUsers()
+	if err != nil {
+		return nil, api.Internal("Failed to get users", err)
+	}
+
+	return api.Paginate(r, users, func(user sqlite.User) string {
+		return user.Id
+	})
+}
+
+func v2GetUser(r *http.Request) (any, error) {
+	user, ok, err := sqlite.GetUser(r.PathValue("id"))
+	if err != nil {
+		return nil, api.Internal("Failed to look up user", err)
+	}
+
+	if !ok {
+		return nil, api.NotFound("User not found")
+	}
+
+	return user, nil
+}
+
+func v2CreateUser(r *http.Request) (any, error) {
+	var in UserInput
+	err := api.Decode(r, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	return createLocalUser(r, in)
+}
+
+func v2UpdateUser(r *http.Request) (any, error) {
+	var in UserPatch
+	err := api.Decode(r, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.Role == nil {
+		return v2GetUser(r)
+	}
+
+	return setUserRole(r, r.PathValue("id"), *in.Role)
+}
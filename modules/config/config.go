@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"maps"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 
 	"wired.rip/wiredutils/protocol"
 	"wired.rip/wiredutils/utils"
@@ -25,83 +28,121 @@ type Node struct {
 	LastConnection int64  `json:"last_connection"`
 }
 
-type SystemConfig struct {
-	WiredHost           string           `json:"wired_host"`
-	SystemKey           string           `json:"system_key"`
-	CurrentAmd64Hash    string           `json:"current_amd64_hash"`
-	CurrentArm64Hash    string           `json:"current_arm64_hash"`
-	DiscordClientId     string           `json:"discord_client_id"`
-	DiscordClientSecret string           `json:"discord_client_secret"`
-	DiscordRedirectUri  string           `json:"discord_redirect_uri"`
-	JwtSigningKey       string           `json:"jwt_signing_key"`
-	AdminDiscordId      string           `json:"admin_discord_id"`
-	Passphrase          string           `json:"passphrase"`
-	Mode                string           `json:"mode"`
-	Nodes               []Node           `json:"nodes"`
-	Routes              []protocol.Route `json:"routes"`
-}
-
-var config SystemConfig
-
-func AddRoute(route protocol.Route) int {
-	config.Routes = append(config.Routes, route)
-	saveConfigFile("config.json")
+// Asset is a named file the master distributes to nodes
+type Asset struct {
+	Name      string   `json:"name"`
+	Version   int      `json:"version"`
+	Hash      string   `json:"hash"`
+	Size      int64    `json:"size"`
+	Nodes     []string `json:"nodes"` // empty means every node
+	UpdatedAt int64    `json:"updated_at"`
+}
 
-	return http.StatusOK
+// ClusterPeer is another master sharing state with this one
+type ClusterPeer struct {
+	Id       string `json:"id"`
+	Url      string `json:"url"`      // base URL of the peer's HTTP API
+	Priority int    `json:"priority"` // lowest reachable priority leads
 }
 
-func SetRoutes(routes []protocol.Route) {
-	config.Routes = routes
-	saveConfigFile("config.json")
+type ClusterConfig struct {
+	Id       string        `json:"id"`
+	Priority int           `json:"priority"`
+	Secret   string        `json:"secret"` // shared by all masters of the cluster
+	Peers    []ClusterPeer `json:"peers"`
 }
 
-func DeleteRoute(routeId string) int {
-	for i, r := range config.Routes {
-		if r.RouteId == routeId {
-			config.Routes = append(config.Routes[:i], config.Routes[i+1:]...)
-			saveConfigFile("config.json")
-			return http.StatusOK
-		}
-	}
+// ClusterState is the part of the configuration that is replicated
+// from the leading master to its followers
+type ClusterState struct {
+	NodeReleases map[string]string `json:"node_releases"`
+	Assets       []Asset           `json:"assets"`
+}
 
-	return http.StatusNotFound
+// AuthProvider configures a way to sign in to the master
+type AuthProvider struct {
+	Id           string   `json:"id"`   // used in callback URLs and linked identities
+	Type         string   `json:"type"` // discord, github, oidc or local
+	Name         string   `json:"name"` // shown on the sign-in page
+	ClientId     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Issuer       string   `json:"issuer"` // oidc only, e.g. https://sso.example.com/realms/wired
+	Scopes       []string `json:"scopes"` // oidc only, defaults to openid profile email
 }
 
-func GetRoutes() []protocol.Route {
-	return config.Routes
+type AuthConfig struct {
+	PublicUrl    string         `json:"public_url"`    // defaults to https://master.<wired_host>, callbacks go to <public_url>/api/auth/oauth/<id>/callback
+	DashboardUrl string         `json:"dashboard_url"` // defaults to https://dash.<wired_host>
+	Providers    []AuthProvider `json:"providers"`
 }
 
-func GetNodes() []Node {
-	return config.Nodes
+// AnalyticsConfig sets how long player history is kept on the master
+type AnalyticsConfig struct {
+	SessionRetentionDays int `json:"session_retention_days"` // single sessions, defaults to 90, negative keeps them
+	StatsRetentionDays   int `json:"stats_retention_days"`   // daily rollups, 0 keeps them
 }
 
-func GetNode(nodeId string) (Node, bool) {
-	for _, n := range config.Nodes {
-		if n.Id == nodeId {
-			return n, true
-		}
-	}
+// LoggingConfig sets what master and nodes log, forwarding applies to nodes
+type LoggingConfig struct {
+	Level        string `json:"level"`         // debug, info, warn or error, defaults to info
+	Forward      bool   `json:"forward"`       // send the log of a node to its master
+	ForwardLevel string `json:"forward_level"` // least level forwarded, defaults to level
+}
 
-	return Node{}, false
+type SystemConfig struct {
+	WiredHost           string            `json:"wired_host"`
+	SystemKey           string            `json:"system_key"`
+	NodeReleases        map[string]string `json:"node_releases"`
+	CurrentAmd64Hash    string            `json:"current_amd64_hash,omitempty"` // legacy, migrated to NodeReleases
+	CurrentArm64Hash    string            `json:"current_arm64_hash,omitempty"` // legacy, migrated to NodeReleases
+	DiscordClientId     string            `json:"discord_client_id"`
+	DiscordClientSecret string            `json:"discord_client_secret"`
+	DiscordRedirectUri  string            `json:"discord_redirect_uri"`
+	JwtSigningKey       string            `json:"jwt_signing_key"`
+	AdminDiscordId      string            `json:"admin_discord_id"`
+	Passphrase          string            `json:"passphrase"`
+	Mode                string            `json:"mode"`
+	Nodes               []Node            `json:"nodes,omitempty"`             // legacy, imported into sqlite
+	Routes              []protocol.Route  `json:"routes,omitempty"`            // legacy, imported into sqlite
+	RoutesGeneration    uint64            `json:"routes_generation,omitempty"` // legacy, imported into sqlite
+	Assets              []Asset           `json:"assets"`
+	Cluster             ClusterConfig     `json:"cluster"`
+	Auth                AuthConfig        `json:"auth"`
+	Analytics           AnalyticsConfig   `json:"analytics"`
+	Logging             LoggingConfig     `json:"logging"`
 }
 
-func AddNode(node Node) int {
-	config.Nodes = append(config.Nodes, node)
-	saveConfigFile("config.json")
+var (
+	config SystemConfig
 
-	return http.StatusOK
+	// guards the parts changed while the master runs, node releases and
+	// assets are set through the API and replaced by the cluster leader
+	configMux = &sync.RWMutex{}
+	saveMux   = &sync.Mutex{}
+)
+
+// GetRoutes returns the routes older versions kept in config.json, masters
+// import them into sqlite and nodes fall back to them without a snapshot
+func GetRoutes() []protocol.Route {
+	return config.Routes
 }
 
-func DeleteNode(nodeId string) int {
-	for i, n := range config.Nodes {
-		if n.Id == nodeId {
-			config.Nodes = append(config.Nodes[:i], config.Nodes[i+1:]...)
-			saveConfigFile("config.json")
-			return http.StatusOK
-		}
-	}
+// GetRoutesGeneration returns the routes generation of older config files
+func GetRoutesGeneration() uint64 {
+	return config.RoutesGeneration
+}
 
-	return http.StatusNotFound
+// GetNodes returns the nodes older versions kept in config.json
+func GetNodes() []Node {
+	return config.Nodes
+}
+
+// ClearImported drops the routes and nodes once they live in sqlite
+func ClearImported() {
+	config.Routes = nil
+	config.RoutesGeneration = 0
+	config.Nodes = nil
+	saveConfigFile("config.json")
 }
 
 func SetSystemKey(key string) {
@@ -126,36 +167,159 @@ func GetWiredHost() string {
 	return config.WiredHost
 }
 
-func GetRouteByProxyDomain(proxyDomain string) (protocol.Route, bool) {
-	for _, r := range config.Routes {
-		if r.ProxyDomain == proxyDomain {
-			return r, true
+// SetCurrentNodeHash registers the release hash for a platform
+// as built by utils.Platform (e.g. "linux/amd64" or "linux/amd64/v3")
+func SetCurrentNodeHash(hash string, platform string) {
+	configMux.Lock()
+	if config.NodeReleases == nil {
+		config.NodeReleases = make(map[string]string)
+	}
+
+	config.NodeReleases[platform] = hash
+	configMux.Unlock()
+
+	saveConfigFile("config.json")
+}
+
+// GetCurrentNodeHash returns the release hash for a platform, falling
+// back to the variant-less release (e.g. "linux/amd64/v3" -> "linux/amd64")
+func GetCurrentNodeHash(platform string) string {
+	_, hash, _ := ResolveNodeRelease(platform)
+	return hash
+}
+
+// ResolveNodeRelease returns the registry key and hash of the release a
+// node running on the given platform should be running
+func ResolveNodeRelease(platform string) (string, string, bool) {
+	configMux.RLock()
+	defer configMux.RUnlock()
+
+	if hash, ok := config.NodeReleases[platform]; ok {
+		return platform, hash, true
+	}
+
+	base := utils.BasePlatform(platform)
+	if hash, ok := config.NodeReleases[base]; ok {
+		return base, hash, true
+	}
+
+	return "", "", false
+}
+
+func GetNodeReleases() map[string]string {
+	configMux.RLock()
+	defer configMux.RUnlock()
+
+	return maps.Clone(config.NodeReleases)
+}
+
+// SetAsset adds an asset or replaces the asset with the same name
+func SetAsset(asset Asset) {
+	configMux.Lock()
+	replaced := false
+	for i, a := range config.Assets {
+		if a.Name == asset.Name {
+			config.Assets[i] = asset
+			replaced = true
+			break
+		}
+	}
+
+	if !replaced {
+		config.Assets = append(config.Assets, asset)
+	}
+	configMux.Unlock()
+
+	saveConfigFile("config.json")
+}
+
+func GetAsset(name string) (Asset, bool) {
+	configMux.RLock()
+	defer configMux.RUnlock()
+
+	for _, a := range config.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+
+	return Asset{}, false
+}
+
+func GetAssets() []Asset {
+	configMux.RLock()
+	defer configMux.RUnlock()
+
+	return append([]Asset(nil), config.Assets...)
+}
+
+// GetAssetsForNode returns the assets that should be present on a node
+func GetAssetsForNode(nodeId string) []Asset {
+	configMux.RLock()
+	defer configMux.RUnlock()
+
+	var assets []Asset
+	for _, a := range config.Assets {
+		if len(a.Nodes) == 0 {
+			assets = append(assets, a)
+			continue
+		}
+
+		for _, n := range a.Nodes {
+			if n == nodeId {
+				assets = append(assets, a)
+				break
+			}
 		}
 	}
 
-	return protocol.Route{}, false
+	return assets
 }
 
-func SetCurrentNodeHash(hash string, arch string) {
-	switch arch {
-	case "amd64":
-		config.CurrentAmd64Hash = hash
-	case "arm64":
-		config.CurrentArm64Hash = hash
+func DeleteAsset(name string) int {
+	configMux.Lock()
+	deleted := false
+	for i, a := range config.Assets {
+		if a.Name == name {
+			// a new slice, copies handed out keep their elements
+			config.Assets = append(config.Assets[:i:i], config.Assets[i+1:]...)
+			deleted = true
+			break
+		}
+	}
+	configMux.Unlock()
+
+	if !deleted {
+		return http.StatusNotFound
 	}
 
 	saveConfigFile("config.json")
+	return http.StatusOK
 }
 
-func GetCurrentNodeHash(arch string) string {
-	switch arch {
-	case "amd64":
-		return config.CurrentAmd64Hash
-	case "arm64":
-		return config.CurrentArm64Hash
+func GetCluster() ClusterConfig {
+	return config.Cluster
+}
+
+func GetClusterState() ClusterState {
+	configMux.RLock()
+	defer configMux.RUnlock()
+
+	return ClusterState{
+		NodeReleases: maps.Clone(config.NodeReleases),
+		Assets:       append([]Asset(nil), config.Assets...),
 	}
+}
 
-	return ""
+// ApplyClusterState replaces the replicated part of the configuration
+// with the state of the leading master
+func ApplyClusterState(state ClusterState) {
+	configMux.Lock()
+	config.NodeReleases = maps.Clone(state.NodeReleases)
+	config.Assets = append([]Asset(nil), state.Assets...)
+	configMux.Unlock()
+
+	saveConfigFile("config.json")
 }
 
 func GetDiscordClientId() string {
@@ -170,6 +334,63 @@ func GetDiscordRedirectUri() string {
 	return config.DiscordRedirectUri
 }
 
+// GetAuthProviders returns the configured sign-in providers. Discord
+// credentials of older config files act as a provider with the id discord.
+func GetAuthProviders() []AuthProvider {
+	providers := config.Auth.Providers
+	if config.DiscordClientId == "" {
+		return providers
+	}
+
+	for _, p := range providers {
+		if p.Id == "discord" {
+			return providers
+		}
+	}
+
+	return append([]AuthProvider{{
+		Id:           "discord",
+		Type:         "discord",
+		Name:         "Discord",
+		ClientId:     config.DiscordClientId,
+		ClientSecret: config.DiscordClientSecret,
+	}}, providers...)
+}
+
+func GetPublicUrl() string {
+	if config.Auth.PublicUrl == "" {
+		return "https://master." + config.WiredHost
+	}
+
+	return strings.TrimSuffix(config.Auth.PublicUrl, "/")
+}
+
+func GetDashboardUrl() string {
+	if config.Auth.DashboardUrl == "" {
+		return "https://dash." + config.WiredHost
+	}
+
+	return strings.TrimSuffix(config.Auth.DashboardUrl, "/")
+}
+
+func GetAnalytics() AnalyticsConfig {
+	analytics := config.Analytics
+	if analytics.SessionRetentionDays == 0 {
+		analytics.SessionRetentionDays = 90
+	}
+
+	return analytics
+}
+
+func GetLogging() LoggingConfig {
+	logging := config.Logging
+	if logging.ForwardLevel == "" {
+		logging.ForwardLevel = logging.Level
+	}
+
+	return logging
+}
+
 func GetJwtSigningKey() string {
 	return config.JwtSigningKey
 }
@@ -186,17 +407,40 @@ func Init() {
 	// create if not exists
 	if _, err := os.Stat("config.json"); os.IsNotExist(err) {
 		config = SystemConfig{
-			WiredHost:        "wired.rip",
-			SystemKey:        fmt.Sprintf("node-%s", utils.GenerateString(8)),
-			CurrentAmd64Hash: "",
-			CurrentArm64Hash: "",
-			Routes:           []protocol.Route{},
+			WiredHost:    "wired.rip",
+			SystemKey:    fmt.Sprintf("node-%s", utils.GenerateString(8)),
+			NodeReleases: map[string]string{},
 		}
 
 		saveConfigFile("config.json")
 	}
 
 	config = readConfigFile("config.json")
+	migrateNodeReleases()
+}
+
+// migrateNodeReleases moves the per-arch hashes of older config files
+// into the platform keyed release registry
+func migrateNodeReleases() {
+	if config.CurrentAmd64Hash == "" && config.CurrentArm64Hash == "" {
+		return
+	}
+
+	if config.NodeReleases == nil {
+		config.NodeReleases = make(map[string]string)
+	}
+
+	if config.CurrentAmd64Hash != "" {
+		config.NodeReleases["linux/amd64"] = config.CurrentAmd64Hash
+	}
+
+	if config.CurrentArm64Hash != "" {
+		config.NodeReleases["linux/arm64"] = config.CurrentArm64Hash
+	}
+
+	config.CurrentAmd64Hash = ""
+	config.CurrentArm64Hash = ""
+	saveConfigFile("config.json")
 }
 
 func readConfigFile(configFile string) SystemConfig {
@@ -225,7 +469,13 @@ func readConfigFile(configFile string) SystemConfig {
 }
 
 func saveConfigFile(configFile string) {
+	// saves write in the order they marshalled
+	saveMux.Lock()
+	defer saveMux.Unlock()
+
+	configMux.RLock()
 	data, err := json.MarshalIndent(config, "", "    ")
+	configMux.RUnlock()
 	if err != nil {
 		log.Println("Error marshalling configuration file:", err)
 		return
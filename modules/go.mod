@@ -3,6 +3,6 @@ module wiredutils
 go 1.22.3
 
 require (
-	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
-	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/mattn/go-sqlite3 v1.14.22
 )
@@ -1,6 +1,7 @@
 package jwt
 
 import (
+	"errors"
 	"time"
 
 	"wired.rip/wiredutils/config"
@@ -14,14 +15,19 @@ func Init() {
 	signingKey = []byte(config.GetJwtSigningKey())
 }
 
-func CreateToken(discordId, username, discriminator, avatar, role string) (string, error) {
+// AccessTokenLifetime is kept short, clients renew access tokens through
+// their session's refresh token
+const AccessTokenLifetime = 15 * time.Minute
+
+var ErrNoSession = errors.New("token is not bound to a session")
+
+func CreateToken(userId, sessionId, username, avatar string) (string, error) {
 	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"discord_id":    discordId,
-		"username":      username,
-		"discriminator": discriminator,
-		"avatar":        avatar,
-		"role":          role,
-		"exp":           time.Now().Add(time.Hour * 24 * 7).Unix(),
+		"sub":      userId,
+		"sid":      sessionId,
+		"username": username,
+		"avatar":   avatar,
+		"exp":      time.Now().Add(AccessTokenLifetime).Unix(),
 	})
 
 	token, err := claims.SignedString(signingKey)
@@ -59,5 +65,10 @@ func ValidateToken(token string) (jwt.MapClaims, error) {
 		return nil, jwt.ErrTokenExpired
 	}
 
+	// tokens issued before sessions existed can not be revoked
+	if sid, _ := claims["sid"].(string); sid == "" {
+		return nil, ErrNoSession
+	}
+
 	return claims, nil
 }
@@ -0,0 +1,202 @@
+package logging
+
+// Structured logging for master and nodes. Records are written to stderr
+// behind the colored prefix of the binary and handed to sinks, like the
+// forwarder of a node. The log package is routed through it at info level,
+// so plain log.Println calls keep working.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"wired.rip/wiredutils/terminal"
+)
+
+// Entry is a log record as sinks receive it
+type Entry struct {
+	Time    int64 // unix nanoseconds
+	Level   slog.Level
+	Message string
+	Fields  map[string]string // e.g. route_id, player, client_ip
+}
+
+// Sink receives every entry that is logged, it must neither block nor log
+type Sink func(Entry)
+
+var (
+	level    = new(slog.LevelVar)
+	prefix   string
+	writeMux = &sync.Mutex{}
+
+	sinks    []Sink
+	sinksMux = &sync.RWMutex{}
+)
+
+// Setup makes the structured logger the default. Lines start with prefix,
+// entries below levelName (debug, info, warn or error) are dropped.
+func Setup(linePrefix string, levelName string) {
+	prefix = linePrefix
+
+	minLevel, err := ParseLevel(levelName)
+	level.Set(minLevel)
+
+	slog.SetDefault(slog.New(&handler{}))
+	// the handler writes the prefix, lines of the log package pass through it
+	log.SetPrefix("")
+
+	if err != nil {
+		slog.Warn("Unknown log level, logging from info", "level", levelName)
+	}
+}
+
+// ParseLevel reads a level name, an empty name is info
+func ParseLevel(name string) (slog.Level, error) {
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+
+	var l slog.Level
+	err := l.UnmarshalText([]byte(name))
+	if err != nil {
+		return slog.LevelInfo, err
+	}
+
+	return l, nil
+}
+
+// LevelName returns the lower case name of a level, e.g. warn
+func LevelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
+
+func AddSink(sink Sink) {
+	sinksMux.Lock()
+	defer sinksMux.Unlock()
+
+	sinks = append(sinks, sink)
+}
+
+type field struct {
+	key   string
+	value string
+}
+
+type handler struct {
+	fields []field // added with With, in order
+	group  string  // prefix of keys added from now on, e.g. "player."
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.fields = appendAttrs(slices.Clip(h.fields), h.group, attrs)
+	return &clone
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	clone := *h
+	clone.group = h.group + name + "."
+	return &clone
+}
+
+func (h *handler) Handle(_ context.Context, r slog.Record) error {
+	fields := slices.Clip(h.fields)
+	r.Attrs(func(attr slog.Attr) bool {
+		fields = appendAttrs(fields, h.group, []slog.Attr{attr})
+		return true
+	})
+
+	entry := Entry{
+		Time:    r.Time.UnixNano(),
+		Level:   r.Level,
+		Message: r.Message,
+	}
+
+	if len(fields) > 0 {
+		entry.Fields = make(map[string]string, len(fields))
+		for _, f := range fields {
+			entry.Fields[f.key] = f.value
+		}
+	}
+
+	write(entry.Level, entry.Message, fields)
+
+	sinksMux.RLock()
+	defer sinksMux.RUnlock()
+
+	for _, sink := range sinks {
+		sink(entry)
+	}
+
+	return nil
+}
+
+// appendAttrs flattens groups into dotted keys
+func appendAttrs(fields []field, group string, attrs []slog.Attr) []field {
+	for _, attr := range attrs {
+		attr.Value = attr.Value.Resolve()
+		if attr.Equal(slog.Attr{}) {
+			continue
+		}
+
+		if attr.Value.Kind() == slog.KindGroup {
+			inner := group
+			if attr.Key != "" {
+				inner += attr.Key + "."
+			}
+
+			fields = appendAttrs(fields, inner, attr.Value.Group())
+			continue
+		}
+
+		fields = append(fields, field{key: group + attr.Key, value: attr.Value.String()})
+	}
+
+	return fields
+}
+
+// write prints a line in the terminal format, info lines look like the
+// ones before structured logging
+func write(l slog.Level, message string, fields []field) {
+	var line strings.Builder
+	line.WriteString(terminal.PrefixColor + prefix + terminal.Reset)
+
+	switch {
+	case l >= slog.LevelError:
+		line.WriteString(terminal.Red + "ERROR " + terminal.Reset)
+	case l >= slog.LevelWarn:
+		line.WriteString(terminal.Yellow + "WARN " + terminal.Reset)
+	case l < slog.LevelInfo:
+		line.WriteString(terminal.Gray + "DEBUG " + terminal.Reset)
+	}
+
+	line.WriteString(message)
+
+	for _, f := range fields {
+		value := f.value
+		if value == "" || strings.ContainsAny(value, " \"=\n") {
+			value = strconv.Quote(value)
+		}
+
+		line.WriteString(" " + terminal.Gray + f.key + "=" + value + terminal.Reset)
+	}
+
+	writeMux.Lock()
+	defer writeMux.Unlock()
+
+	fmt.Fprintln(os.Stderr, line.String())
+}
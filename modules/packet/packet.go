@@ -1,6 +1,13 @@
 package packet
 
-import "wired.rip/wiredutils/protocol"
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sort"
+
+	"wired.rip/wiredutils/logging"
+	"wired.rip/wiredutils/protocol"
+)
 
 const (
 	Id_SharedSecret     protocol.VarInt = 0
@@ -14,14 +21,29 @@ const (
 	Id_PlayerAdd        protocol.VarInt = 8
 	Id_PlayerRemove     protocol.VarInt = 9
 	Id_DisconnectPlayer protocol.VarInt = 10
+	Id_AssetReport      protocol.VarInt = 11
+	Id_AssetRemove      protocol.VarInt = 12
+	Id_RoutesDelta      protocol.VarInt = 13
+	Id_RoutesResync     protocol.VarInt = 14
+	Id_PlayerSnapshot   protocol.VarInt = 15
+	Id_Telemetry        protocol.VarInt = 16
+	Id_Logs             protocol.VarInt = 17
 )
 
+// AssetLabelPrefix marks BinaryData transfers that carry a managed asset,
+// the asset name follows the prefix
+const AssetLabelPrefix = "asset:"
+
 type Hello struct {
 	Key        string
 	Version    string
 	Passphrase string
 	Arch       string
+	OS         string
+	Variant    string // microarchitecture level, e.g. "v3" for GOAMD64=v3
 	Hash       []byte
+
+	RouteDeltas bool // understands RoutesDelta packets
 }
 
 type BinaryData struct {
@@ -30,10 +52,115 @@ type BinaryData struct {
 }
 
 type Routes struct {
-	Routes []protocol.Route
+	Routes     []protocol.Route
+	Generation uint64
+	Signature  []byte // RSA PKCS#1 v1.5 signature of Digest by the master key
+
+	// Resync is set on the routes sent on hello and on request, nodes
+	// install them even if their generation is lower than the served one,
+	// e.g. after the master restored a backup or another master took over
+	Resync bool
+}
+
+// Digest returns the SHA-256 hash the master signs for a routes packet
+func (r Routes) Digest() []byte {
+	data, _ := json.Marshal(struct {
+		Generation uint64           `json:"generation"`
+		Routes     []protocol.Route `json:"routes"`
+	}{r.Generation, r.Routes})
+
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// RoutesDelta changes the routes of a node from generation From to
+// Generation. Signature signs the Digest of all routes after applying it,
+// sorted by SortRoutes, so nodes can verify and persist the result.
+type RoutesDelta struct {
+	From       uint64
+	Generation uint64
+	Upsert     []protocol.Route
+	Remove     []string // route ids
+	Signature  []byte
+}
+
+// RoutesResync asks the master for all routes, sent by nodes that
+// cannot apply a delta
+type RoutesResync struct {
+	Generation uint64 // generation the node serves
+}
+
+// SortRoutes puts routes in the order the master signs them
+func SortRoutes(routes []protocol.Route) {
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].RouteId < routes[j].RouteId
+	})
+}
+
+// Heartbeat is the payload of Ping, Pong echoes it so the sender measures
+// the round trip. Older peers send both without a payload.
+type Heartbeat struct {
+	SentAt int64 // unix nanoseconds, read by the sender only
+}
+
+// PongFor returns the payload of the Pong answering a ping
+func PongFor(ping []byte) any {
+	var hb Heartbeat
+	if len(ping) == 0 || protocol.DecodePacket(ping, &hb) != nil {
+		return nil
+	}
+
+	return hb
+}
+
+// PlayerSnapshot lists every player of a node, sent after Hello so the
+// master replaces what it still remembers from an earlier connection
+type PlayerSnapshot struct {
+	Players []protocol.Player
+}
+
+// Telemetry describes the host of a node, sent periodically. Values the
+// host does not provide are zero.
+type Telemetry struct {
+	CPU   float64 // percent of all cores busy since the previous report
+	Cores int
+
+	MemTotal     uint64 // bytes
+	MemAvailable uint64
+
+	Load1, Load5, Load15 float64
+
+	OpenFiles    uint64 // descriptors held by the node process
+	MaxOpenFiles uint64 // soft limit, LimitNOFILE under systemd
+	Goroutines   int
+
+	RxRate, TxRate float64 // bytes per second since the previous report, loopback excluded
+
+	Uptime        int64 // seconds since the host booted
+	ProcessUptime int64 // seconds since the node process started
+}
+
+// Logs carries log entries of a node to its master. Dropped counts the
+// entries lost since the previous batch, e.g. while the master was away.
+type Logs struct {
+	Entries []logging.Entry
+	Dropped uint64
 }
 
 type Disconnect struct {
 	PlayerUUID string
 	ProxyHost  string
 }
+
+type AssetState struct {
+	Name string
+	Hash string // hex encoded sha256
+}
+
+type AssetReport struct {
+	Assets []AssetState
+}
+
+type AssetRemove struct {
+	Name string
+}
@@ -27,7 +27,7 @@ func splitBytes(data []byte, size int) [][]byte {
 func (c *Conn) SendFile(label, path string, packetIdData VarInt, packetIdEnd VarInt) error {
 	file, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer file.Close()
 
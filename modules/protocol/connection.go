@@ -6,9 +6,12 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"io"
 	"net"
 	"strconv"
+	"sync"
+	"time"
 )
 
 const (
@@ -16,13 +19,43 @@ const (
 	StateReady       VarInt = 0x02 // switched to AES cipherstream
 )
 
+const (
+	// packets waiting for the writer, senders block while it is full
+	sendQueueSize = 64
+
+	// a peer that does not take a packet within this time is considered
+	// dead and the connection is closed
+	writeTimeout = 30 * time.Second
+)
+
+var (
+	ErrConnClosed = errors.New("connection closed")
+	ErrQueueFull  = errors.New("send queue full")
+)
+
+// Conn is a connection between master and node. Reads belong to a single
+// read loop, writes from any goroutine go through a queue that one writer
+// drains, so packets never interleave and the cipher stream stays intact.
 type Conn struct {
 	Address net.IP
 	Port    uint16
 	State   VarInt
 	conn    net.Conn
 	r       io.Reader
-	w       io.Writer
+	w       io.Writer // only used by the writer
+
+	queue     chan outgoing
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// outgoing is a write for the writer, either a packet, raw bytes or a
+// change of the write stream
+type outgoing struct {
+	packet *Packet
+	raw    []byte
+	apply  func()
+	done   chan error
 }
 
 type RSAStream struct {
@@ -84,34 +117,130 @@ func NewConn(c net.Conn, wiredKey *rsa.PrivateKey, wiredPub *rsa.PublicKey) *Con
 		writer = &RSAStream{pub: wiredPub, conn: c}
 	}
 
-	return &Conn{
+	conn := &Conn{
 		Address: net.ParseIP(addr),
 		Port:    uint16(port),
 		conn:    c,
 		State:   StateKeyExchange,
 		r:       reader,
 		w:       writer,
+		queue:   make(chan outgoing, sendQueueSize),
+		closed:  make(chan struct{}),
 	}
+
+	go conn.writeLoop()
+	return conn
 }
 
+// EnableEncryption switches both directions to the AES cipher stream. It is
+// called by the read loop, packets sent before it are written in plain.
 func (c *Conn) EnableEncryption(sharedSecret []byte) error {
 	block, err := aes.NewCipher(sharedSecret)
 	if err != nil {
 		return err
 	}
-	c.setCipher(NewCFB8Encrypter(block, sharedSecret), NewCFB8Decrypter(block, sharedSecret))
-	c.State = StateReady
-	return nil
-}
 
-func (c *Conn) setCipher(encStream, decStream cipher.Stream) {
+	encStream, decStream := NewCFB8Encrypter(block, sharedSecret), NewCFB8Decrypter(block, sharedSecret)
 	c.r = cipher.StreamReader{
 		S: decStream,
 		R: c.conn,
 	}
-	c.w = cipher.StreamWriter{
-		S: encStream,
-		W: c.conn,
+
+	err = c.enqueue(outgoing{apply: func() {
+		c.w = cipher.StreamWriter{
+			S: encStream,
+			W: c.conn,
+		}
+	}})
+	if err != nil {
+		return err
+	}
+
+	c.State = StateReady
+	return nil
+}
+
+func (c *Conn) writeLoop() {
+	for {
+		select {
+		case <-c.closed:
+			return
+		case out := <-c.queue:
+			if out.apply != nil {
+				out.apply()
+				out.done <- nil
+				continue
+			}
+
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+			var err error
+			if out.packet != nil {
+				_, err = out.packet.Write(c.w)
+			} else {
+				_, err = c.w.Write(out.raw)
+			}
+
+			out.done <- err
+			if err != nil {
+				// a partial write broke the framing and the cipher stream
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+// enqueue hands a write to the writer and waits until it is done. A full
+// queue blocks the caller, a closed connection fails it.
+func (c *Conn) enqueue(out outgoing) error {
+	out.done = make(chan error, 1)
+
+	select {
+	case c.queue <- out:
+	case <-c.closed:
+		return ErrConnClosed
+	}
+
+	select {
+	case err := <-out.done:
+		return err
+	case <-c.closed:
+		// the writer may have finished it right before the close
+		select {
+		case err := <-out.done:
+			return err
+		default:
+			return ErrConnClosed
+		}
+	}
+}
+
+// QueuePacket hands a packet to the writer without waiting until it is
+// written, it keeps its order with the other writes. A full queue fails it
+// right away, so a peer that does not keep up never stalls the caller.
+func (c *Conn) QueuePacket(id VarInt, packet any) error {
+	data, err := EncodePacket(packet)
+	if err != nil {
+		return err
+	}
+
+	out := outgoing{packet: &Packet{ID: id, Data: data}, done: make(chan error, 1)}
+
+	// a closed connection wins over a free slot
+	select {
+	case <-c.closed:
+		return ErrConnClosed
+	default:
+	}
+
+	select {
+	case c.queue <- out:
+		return nil
+	case <-c.closed:
+		return ErrConnClosed
+	default:
+		return ErrQueueFull
 	}
 }
 
@@ -119,12 +248,25 @@ func (c *Conn) Read(p []byte) (n int, err error) {
 	return c.r.Read(p)
 }
 
+// Write sends p as one write of the writer
 func (c *Conn) Write(p []byte) (n int, err error) {
-	return c.w.Write(p)
+	err = c.enqueue(outgoing{raw: bytes.Clone(p)})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
 }
 
+// Close closes the connection, senders waiting for the writer fail
 func (c *Conn) Close() error {
-	return c.conn.Close()
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+
+	return err
 }
 func (c *Conn) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
@@ -134,6 +276,7 @@ func (c *Conn) GetSocket() net.Conn {
 	return c.conn
 }
 
+// SendPacket writes a packet, it is safe to call from several goroutines
 func (c *Conn) SendPacket(id VarInt, packet any) error {
 	//marshal packet
 	data, err := EncodePacket(packet)
@@ -142,11 +285,10 @@ func (c *Conn) SendPacket(id VarInt, packet any) error {
 	}
 
 	//assemble and send packet
-	_, err = (&Packet{
+	return c.enqueue(outgoing{packet: &Packet{
 		ID:   id,
 		Data: data,
-	}).Write(c)
-	return err
+	}})
 }
 
 func MarshalPacket(id VarInt, packet any) ([]byte, error) {
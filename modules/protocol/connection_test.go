@@ -0,0 +1,146 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testPayload struct {
+	Sender int
+	Seq    int
+	Data   []byte
+}
+
+// encryptedPair returns both ends of an encrypted connection
+func encryptedPair(t *testing.T) (*Conn, *Conn) {
+	t.Helper()
+
+	a, b := net.Pipe()
+	left, right := NewConn(a, nil, nil), NewConn(b, nil, nil)
+	t.Cleanup(func() {
+		left.Close()
+		right.Close()
+	})
+
+	secret := []byte("0123456789abcdef")
+	if err := left.EnableEncryption(secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := right.EnableEncryption(secret); err != nil {
+		t.Fatal(err)
+	}
+
+	return left, right
+}
+
+func TestConcurrentSendersKeepFraming(t *testing.T) {
+	sender, receiver := encryptedPair(t)
+
+	const senders, packets = 16, 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for seq := 0; seq < packets; seq++ {
+				// sizes vary so writes of different senders would overlap
+				err := sender.SendPacket(3, testPayload{Sender: i, Seq: seq, Data: make([]byte, (i*seq)%2000)})
+				if err != nil {
+					t.Errorf("sender %d: %v", i, err)
+					return
+				}
+			}
+		}(i)
+	}
+
+	next := make([]int, senders)
+	for n := 0; n < senders*packets; n++ {
+		var pp Packet
+		if err := pp.Read(receiver); err != nil {
+			t.Fatalf("packet %d: %v", n, err)
+		}
+
+		var payload testPayload
+		if err := DecodePacket(pp.Data, &payload); err != nil {
+			t.Fatalf("packet %d is corrupted: %v", n, err)
+		}
+
+		// packets of one sender arrive in the order they were sent
+		if payload.Seq != next[payload.Sender] {
+			t.Fatalf("sender %d: got packet %d, want %d", payload.Sender, payload.Seq, next[payload.Sender])
+		}
+
+		next[payload.Sender]++
+	}
+
+	wg.Wait()
+}
+
+func TestCloseReleasesBlockedSenders(t *testing.T) {
+	// nobody reads the other end, the queue fills up behind the writer
+	sender, _ := encryptedPair(t)
+
+	const senders = sendQueueSize + 8
+
+	errs := make(chan error, senders)
+	for i := 0; i < senders; i++ {
+		go func() {
+			errs <- sender.SendPacket(3, testPayload{Data: make([]byte, 64)})
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	sender.Close()
+
+	for i := 0; i < senders; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Error("send on an unread connection succeeded")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("sender still blocked after close")
+		}
+	}
+
+	if err := sender.SendPacket(3, nil); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("send after close: got %v, want %v", err, ErrConnClosed)
+	}
+}
+
+func TestQueuePacketNeverBlocks(t *testing.T) {
+	// nobody reads the other end, the queue fills up behind the writer
+	sender, _ := encryptedPair(t)
+
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i <= sendQueueSize+1; i++ {
+			err := sender.QueuePacket(3, testPayload{Data: make([]byte, 64)})
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("got %v, want %v", err, ErrQueueFull)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queueing on an unread connection blocked")
+	}
+
+	sender.Close()
+	if err := sender.QueuePacket(3, nil); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("queue after close: got %v, want %v", err, ErrConnClosed)
+	}
+}
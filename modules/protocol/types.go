@@ -20,6 +20,33 @@ type Route struct {
 	ServerPort  string `json:"server_port"`
 	ProxyDomain string `json:"proxy_domain"`
 	ProxyPort   string `json:"proxy_port"`
+
+	// optional, left out of the JSON when unset so routes without them
+	// keep the digest older nodes verify
+	ConnectTimeout int             `json:"connect_timeout,omitempty"` // seconds to reach the server
+	IdleTimeout    int             `json:"idle_timeout,omitempty"`    // seconds without traffic before closing
+	Protocols      []ProtocolRange `json:"protocols,omitempty"`       // allowed client protocol versions, all if empty
+}
+
+// ProtocolRange is an inclusive range of Minecraft protocol versions
+type ProtocolRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// AllowsProtocol reports whether clients with the protocol version may use the route
+func (r Route) AllowsProtocol(version int) bool {
+	if len(r.Protocols) == 0 {
+		return true
+	}
+
+	for _, p := range r.Protocols {
+		if version >= p.Min && version <= p.Max {
+			return true
+		}
+	}
+
+	return false
 }
 
 type Packet struct {
@@ -35,6 +62,9 @@ type Player struct {
 	ProxyUsed       string
 	ProtocolVersion int
 	NodeId          string
+	ClientIP        string
+	BytesToServer   int64 // sent with the leave of the player
+	BytesToClient   int64
 	Conn            net.Conn `gob:"-"`
 }
 
@@ -0,0 +1,138 @@
+package rbac
+
+// Permission based access control. Users have a single role, a role grants
+// a set of permissions. Route scoped permissions only apply to the routes a
+// user owns unless the role also grants RoutesAll.
+
+import (
+	"context"
+	"strings"
+)
+
+type Permission string
+
+const (
+	Wildcard        Permission = "*"
+	RoutesRead      Permission = "routes:read"
+	RoutesWrite     Permission = "routes:write"
+	RoutesAll       Permission = "routes:all" // routes and players of every owner
+	NodesRead       Permission = "nodes:read"
+	NodesManage     Permission = "nodes:manage"
+	NodesLogs       Permission = "nodes:logs" // forwarded logs, they name players and their addresses
+	PlayersRead     Permission = "players:read"
+	PlayersKick     Permission = "players:kick"
+	UsersManage     Permission = "users:manage"
+	ReleasesPublish Permission = "releases:publish"
+	AssetsManage    Permission = "assets:manage"
+	AuditRead       Permission = "audit:read"
+)
+
+var Permissions = []Permission{
+	RoutesRead,
+	RoutesWrite,
+	RoutesAll,
+	NodesRead,
+	NodesManage,
+	NodesLogs,
+	PlayersRead,
+	PlayersKick,
+	UsersManage,
+	ReleasesPublish,
+	AssetsManage,
+	AuditRead,
+}
+
+// AdminRole always holds every permission and can not be changed
+const AdminRole = "admin"
+
+// SignupRole is given to users signing up through a provider, anything
+// beyond reading their routes has to be granted by an admin
+const SignupRole = "viewer"
+
+func Valid(p Permission) bool {
+	if p == Wildcard {
+		return true
+	}
+
+	for _, known := range Permissions {
+		if p == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParsePermissions reads a comma separated permission list
+func ParsePermissions(s string) ([]Permission, bool) {
+	permissions := []Permission{}
+	for _, part := range strings.Split(s, ",") {
+		p := Permission(strings.TrimSpace(part))
+		if p == "" {
+			continue
+		}
+
+		if !Valid(p) {
+			return nil, false
+		}
+
+		permissions = append(permissions, p)
+	}
+
+	return permissions, true
+}
+
+// Principal is the authenticated caller of an API request
+type Principal struct {
+	UserId      string
+	Role        string
+	Permissions []Permission
+	TokenId     string // set when authenticated with an API token
+	SessionId   string // set when authenticated with an access token
+}
+
+func (p Principal) Has(permission Permission) bool {
+	for _, granted := range p.Permissions {
+		if granted == permission || granted == Wildcard {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Restrict limits the principal to the given scopes, it never gains
+// permissions it did not hold before
+func (p Principal) Restrict(scopes []Permission) Principal {
+	restricted := p
+	restricted.Permissions = []Permission{}
+	for _, scope := range scopes {
+		if scope == Wildcard {
+			restricted.Permissions = p.Permissions
+			return restricted
+		}
+
+		if p.Has(scope) {
+			restricted.Permissions = append(restricted.Permissions, scope)
+		}
+	}
+
+	return restricted
+}
+
+// CanAccessRoute reports whether the principal may act on a route
+// owned by owner, given it holds the route scoped permission
+func (p Principal) CanAccessRoute(owner string) bool {
+	return p.Has(RoutesAll) || owner != "" && owner == p.UserId
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
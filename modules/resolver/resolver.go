@@ -7,12 +7,26 @@ import (
 )
 
 func ResolveWired(host string) (*net.TCPAddr, error) {
+	addrs, err := ResolveWiredAll(host)
+	if err != nil {
+		return nil, err
+	}
+
+	return addrs[0], nil
+}
+
+// ResolveWiredAll returns the addresses of every master behind
+// _wired._tcp.<host> in the order they should be tried: by SRV priority,
+// randomized by weight within the same priority (RFC 2782)
+func ResolveWiredAll(host string) ([]*net.TCPAddr, error) {
 	resolver := net.Resolver{
 		PreferGo: false,
 		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
 			return net.Dial(network, "1.1.1.1:53")
 		},
 	}
+
+	// LookupSRV already sorts by priority and shuffles by weight
 	_, results, err := resolver.LookupSRV(context.Background(), "", "", "_wired._tcp."+host)
 	if err != nil {
 		return nil, err
@@ -22,17 +36,30 @@ func ResolveWired(host string) (*net.TCPAddr, error) {
 		return nil, errors.New("failed to resolve " + host)
 	}
 
-	addrs, err := resolver.LookupHost(context.Background(), results[0].Target)
-	if err != nil {
-		return nil, err
+	var addrs []*net.TCPAddr
+	var lastErr error
+	for _, result := range results {
+		hosts, err := resolver.LookupHost(context.Background(), result.Target)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, h := range hosts {
+			addrs = append(addrs, &net.TCPAddr{
+				IP:   net.ParseIP(h),
+				Port: int(result.Port),
+			})
+		}
 	}
 
 	if len(addrs) < 1 {
-		return nil, errors.New("failed to resolve " + results[0].Target)
+		if lastErr != nil {
+			return nil, lastErr
+		}
+
+		return nil, errors.New("failed to resolve any target of " + host)
 	}
 
-	return &net.TCPAddr{
-		IP:   net.ParseIP(addrs[0]),
-		Port: int(results[0].Port),
-	}, nil
+	return addrs, nil
 }
@@ -0,0 +1,120 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type AuditEntry struct {
+	Id        int64           `json:"id"`
+	CreatedAt int64           `json:"created_at"`
+	Actor     string          `json:"actor"` // id of the user
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	Ip        string          `json:"ip"`
+	Status    int             `json:"status"`
+}
+
+// AuditFilter narrows down audit entries, zero values match everything.
+// Entries are returned newest first, BeforeId continues a previous page.
+type AuditFilter struct {
+	Actor    string
+	Action   string // "routes" matches "routes.add" and "routes.remove"
+	Target   string
+	Since    int64
+	Until    int64
+	BeforeId int64
+	Limit    int
+}
+
+func AddAuditEntry(entry AuditEntry) error {
+	_, err := db.Exec("INSERT INTO audit_log (created_at, actor, action, target, before, after, ip, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", entry.CreatedAt, entry.Actor, entry.Action, entry.Target, nullableJSON(entry.Before), nullableJSON(entry.After), entry.Ip, entry.Status)
+	return err
+}
+
+func GetAuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	var where []string
+	var args []any
+
+	if filter.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+
+	if filter.Action != "" {
+		where = append(where, "(action = ? OR action LIKE ? ESCAPE '\\')")
+		args = append(args, filter.Action, escapeLike(filter.Action)+".%")
+	}
+
+	if filter.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, filter.Target)
+	}
+
+	if filter.Since > 0 {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.Since)
+	}
+
+	if filter.Until > 0 {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.Until)
+	}
+
+	if filter.BeforeId > 0 {
+		where = append(where, "id < ?")
+		args = append(args, filter.BeforeId)
+	}
+
+	query := "SELECT id, created_at, actor, action, target, before, after, ip, status FROM audit_log"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var before, after *string
+		err := rows.Scan(&e.Id, &e.CreatedAt, &e.Actor, &e.Action, &e.Target, &before, &after, &e.Ip, &e.Status)
+		if err != nil {
+			return nil, err
+		}
+
+		if before != nil {
+			e.Before = json.RawMessage(*before)
+		}
+
+		if after != nil {
+			e.After = json.RawMessage(*after)
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func nullableJSON(data json.RawMessage) any {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return string(data)
+}
+
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"strconv"
+
+	"wired.rip/wiredutils/protocol"
+)
+
+// ImportConfig copies the routes and nodes older versions kept in
+// config.json into the database. It only runs once, the returned bool
+// reports whether anything was imported.
+func ImportConfig(routes []protocol.Route, generation uint64, nodes []Node) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var imported int
+	err = tx.QueryRow("SELECT COUNT(*) FROM settings WHERE key = 'config_imported'").Scan(&imported)
+	if err != nil {
+		return false, err
+	}
+
+	if imported > 0 {
+		return false, nil
+	}
+
+	for _, r := range routes {
+		_, err = tx.Exec("INSERT OR IGNORE INTO routes (route_id, server_host, server_port, proxy_domain, proxy_port) VALUES (?, ?, ?, ?, ?)", r.RouteId, r.ServerHost, r.ServerPort, r.ProxyDomain, r.ProxyPort)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for _, n := range nodes {
+		_, err = tx.Exec("INSERT OR IGNORE INTO nodes (node_id, passphrase, last_connection) VALUES (?, ?, ?)", n.Id, n.Passphrase, n.LastConnection)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// keep the generation moving forward so nodes holding a snapshot
+	// from before the import accept the routes
+	_, err = tx.Exec("UPDATE settings SET value = ? WHERE key = 'routes_generation' AND CAST(value AS INTEGER) < ?", strconv.FormatUint(generation+1, 10), generation+1)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec("INSERT INTO settings (key, value) VALUES ('config_imported', '1')")
+	if err != nil {
+		return false, err
+	}
+
+	return len(routes) > 0 || len(nodes) > 0, tx.Commit()
+}
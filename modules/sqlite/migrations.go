@@ -0,0 +1,248 @@
+package sqlite
+
+import (
+	"fmt"
+	"log"
+)
+
+// migrations are applied in order and recorded in schema_migrations,
+// never edit a released migration, append a new one instead
+var migrations = []string{
+	// 1: users
+	`CREATE TABLE IF NOT EXISTS users (
+		discord_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		discriminator TEXT NOT NULL,
+		avatar TEXT NOT NULL,
+		role TEXT NOT NULL
+	)`,
+
+	// 2: routes, nodes and settings moved out of config.json
+	`CREATE TABLE routes (
+		route_id TEXT PRIMARY KEY,
+		server_host TEXT NOT NULL,
+		server_port TEXT NOT NULL,
+		proxy_domain TEXT NOT NULL UNIQUE,
+		proxy_port TEXT NOT NULL
+	);
+	CREATE TABLE nodes (
+		node_id TEXT PRIMARY KEY,
+		passphrase TEXT NOT NULL,
+		last_connection INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	INSERT INTO settings (key, value) VALUES ('routes_generation', '0')`,
+
+	// 3: append-only audit log of administrative actions
+	`CREATE TABLE audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at INTEGER NOT NULL,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL,
+		before TEXT,
+		after TEXT,
+		ip TEXT NOT NULL,
+		status INTEGER NOT NULL
+	);
+	CREATE INDEX audit_log_actor ON audit_log (actor, id);
+	CREATE INDEX audit_log_action ON audit_log (action, id);
+	CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
+	CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END`,
+
+	// 4: roles with permissions and route owners
+	`CREATE TABLE roles (
+		name TEXT PRIMARY KEY,
+		permissions TEXT NOT NULL
+	);
+	INSERT INTO roles (name, permissions) VALUES
+		('admin', '*'),
+		('user', 'routes:read,routes:write,players:read,players:kick,nodes:read'),
+		('demo', 'routes:read,routes:write,nodes:read');
+	ALTER TABLE routes ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+	CREATE INDEX routes_owner ON routes (owner)`,
+
+	// 5: API tokens, only their sha256 is stored
+	`CREATE TABLE api_tokens (
+		token_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		owner TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		last_used_at INTEGER NOT NULL DEFAULT 0,
+		revoked_at INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX api_tokens_owner ON api_tokens (owner)`,
+
+	// 6: sign-in sessions backing short-lived access tokens
+	`CREATE TABLE sessions (
+		session_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		refresh_hash TEXT NOT NULL UNIQUE,
+		previous_hash TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		refreshed_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		revoked_at INTEGER NOT NULL DEFAULT 0,
+		ip TEXT NOT NULL,
+		user_agent TEXT NOT NULL
+	);
+	CREATE INDEX sessions_user ON sessions (user_id);
+	CREATE INDEX sessions_previous_hash ON sessions (previous_hash)`,
+
+	// 7: users keyed by an internal id with linked sign-in identities,
+	// existing users keep their discord id as id
+	`CREATE TABLE users_new (
+		user_id TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		avatar TEXT NOT NULL DEFAULT '',
+		role TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	INSERT OR IGNORE INTO users_new (user_id, username, avatar, role, created_at)
+		SELECT discord_id, username,
+			CASE WHEN avatar = '' THEN '' ELSE 'https://cdn.discordapp.com/avatars/' || discord_id || '/' || avatar || '.png' END,
+			role, strftime('%s', 'now')
+		FROM users;
+	CREATE TABLE identities (
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (provider, subject)
+	);
+	INSERT OR IGNORE INTO identities (provider, subject, user_id, username, created_at)
+		SELECT 'discord', discord_id, discord_id, username, strftime('%s', 'now') FROM users;
+	DROP TABLE users;
+	ALTER TABLE users_new RENAME TO users;
+	CREATE INDEX identities_user ON identities (user_id);
+	CREATE TABLE local_credentials (
+		user_id TEXT PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		totp_secret TEXT NOT NULL DEFAULT '',
+		totp_enabled INTEGER NOT NULL DEFAULT 0
+	)`,
+
+	// 8: route metadata, per-route limits and a revision for
+	// optimistic concurrency
+	`ALTER TABLE routes ADD COLUMN name TEXT NOT NULL DEFAULT '';
+	ALTER TABLE routes ADD COLUMN description TEXT NOT NULL DEFAULT '';
+	ALTER TABLE routes ADD COLUMN enabled INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE routes ADD COLUMN tags TEXT NOT NULL DEFAULT '';
+	ALTER TABLE routes ADD COLUMN connect_timeout INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE routes ADD COLUMN idle_timeout INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE routes ADD COLUMN protocols TEXT NOT NULL DEFAULT '';
+	ALTER TABLE routes ADD COLUMN revision INTEGER NOT NULL DEFAULT 1`,
+
+	// 9: node groups and the nodes and groups a route is placed on
+	`ALTER TABLE nodes ADD COLUMN node_groups TEXT NOT NULL DEFAULT '';
+	ALTER TABLE routes ADD COLUMN placement_nodes TEXT NOT NULL DEFAULT '';
+	ALTER TABLE routes ADD COLUMN placement_groups TEXT NOT NULL DEFAULT ''`,
+
+	// 10: finished player sessions and their daily rollups, route '*'
+	// holds the totals of every route
+	`CREATE TABLE player_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		uuid TEXT NOT NULL,
+		name TEXT NOT NULL,
+		route_id TEXT NOT NULL,
+		proxy_domain TEXT NOT NULL,
+		node_id TEXT NOT NULL,
+		client_ip TEXT NOT NULL,
+		protocol_version INTEGER NOT NULL,
+		joined_at INTEGER NOT NULL,
+		left_at INTEGER NOT NULL,
+		bytes_to_server INTEGER NOT NULL DEFAULT 0,
+		bytes_to_client INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX player_sessions_left_at ON player_sessions (left_at);
+	CREATE INDEX player_sessions_route ON player_sessions (route_id, left_at);
+	CREATE INDEX player_sessions_uuid ON player_sessions (uuid, left_at);
+	CREATE TABLE player_stats_daily (
+		day TEXT NOT NULL,
+		route_id TEXT NOT NULL,
+		unique_players INTEGER NOT NULL,
+		sessions INTEGER NOT NULL,
+		playtime INTEGER NOT NULL,
+		peak_concurrency INTEGER NOT NULL,
+		bytes INTEGER NOT NULL,
+		PRIMARY KEY (day, route_id)
+	)`,
+
+	// 11: role of users signing up through a provider, they may only
+	// read the routes an admin gave them until they are granted more
+	`INSERT OR IGNORE INTO roles (name, permissions) VALUES ('viewer', 'routes:read')`,
+
+	// 12: version of the replicated state, see ClusterVersion
+	`INSERT INTO settings (key, value) VALUES ('cluster_term', '0'), ('cluster_generation', '0'), ('cluster_hash', '')`,
+
+	// 13: last TOTP step a user signed in with, codes are single use
+	`ALTER TABLE local_credentials ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0`,
+}
+
+func migrate() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL DEFAULT (strftime('%s', 'now'))
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(migrations[i])
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+
+		_, err = tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+
+		log.Printf("Applied database migration %d\n", version)
+	}
+
+	return nil
+}
+
+func getSetting(key string) (string, error) {
+	var value string
+	err := db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	return value, err
+}
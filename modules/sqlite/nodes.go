@@ -0,0 +1,125 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+type Node struct {
+	Id             string   `json:"id"`
+	Passphrase     string   `json:"passphrase"`
+	LastConnection int64    `json:"last_connection"`
+	Groups         []string `json:"groups"`
+}
+
+const nodeColumns = "node_id, passphrase, last_connection, node_groups"
+
+func scanNode(row scanner) (Node, error) {
+	var n Node
+	var groups string
+	err := row.Scan(&n.Id, &n.Passphrase, &n.LastConnection, &groups)
+	n.Groups = splitList(groups)
+	return n, err
+}
+
+func GetNodes() ([]Node, error) {
+	rows, err := db.Query("SELECT " + nodeColumns + " FROM nodes ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nodes := []Node{}
+	for rows.Next() {
+		n, err := scanNode(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes, rows.Err()
+}
+
+func GetNode(nodeId string) (Node, bool, error) {
+	n, err := scanNode(db.QueryRow("SELECT "+nodeColumns+" FROM nodes WHERE node_id = ?", nodeId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Node{}, false, nil
+	}
+
+	return n, err == nil, err
+}
+
+func AddNode(node Node) error {
+	_, err := db.Exec("INSERT INTO nodes ("+nodeColumns+") VALUES (?, ?, ?, ?)", node.Id, node.Passphrase, node.LastConnection, strings.Join(node.Groups, ","))
+	return err
+}
+// DeleteNode removes a node and reports whether it existed
+func DeleteNode(nodeId string) (bool, error) {
+	res, err := db.Exec("DELETE FROM nodes WHERE node_id = ?", nodeId)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetNodeGroups replaces the groups of a node. Groups decide which routes
+// a node serves, so the routes generation is bumped with them.
+func SetNodeGroups(nodeId string, groups []string) (bool, error) {
+	err := routesTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec("UPDATE nodes SET node_groups = ? WHERE node_id = ?", strings.Join(groups, ","), nodeId)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return errNoChange
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, errNoChange) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func SetNodeLastConnection(nodeId string, lastConnection int64) error {
+	_, err := db.Exec("UPDATE nodes SET last_connection = ? WHERE node_id = ?", lastConnection, nodeId)
+	return err
+}
+
+// replaceNodes swaps all nodes. Nodes connect to any master, so the
+// later of both last connections is kept.
+func replaceNodes(tx *sql.Tx, nodes []Node) error {
+	connected, err := lastSeen(tx, "SELECT node_id, last_connection FROM nodes")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM nodes")
+	if err != nil {
+		return err
+	}
+
+	for _, n := range nodes {
+		n.LastConnection = max(n.LastConnection, connected[n.Id])
+		_, err = tx.Exec("INSERT INTO nodes ("+nodeColumns+") VALUES (?, ?, ?, ?)", n.Id, n.Passphrase, n.LastConnection, strings.Join(n.Groups, ","))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
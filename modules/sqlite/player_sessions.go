@@ -0,0 +1,373 @@
+package sqlite
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AllRoutes is the route id of the statistics over every route
+const AllRoutes = "*"
+
+const dayLayout = "2006-01-02"
+
+// PlayerSession is a finished visit of a player, recorded when the node
+// reports the player left
+type PlayerSession struct {
+	Id              int64  `json:"id"`
+	UUID            string `json:"uuid"`
+	Name            string `json:"name"`
+	RouteId         string `json:"route_id"` // empty if the route was removed meanwhile
+	ProxyDomain     string `json:"proxy_domain"`
+	NodeId          string `json:"node_id"`
+	ClientIP        string `json:"client_ip"`
+	ProtocolVersion int    `json:"protocol_version"`
+	JoinedAt        int64  `json:"joined_at"`
+	LeftAt          int64  `json:"left_at"`
+	BytesToServer   int64  `json:"bytes_to_server"`
+	BytesToClient   int64  `json:"bytes_to_client"`
+}
+
+// PlayerSessionFilter narrows down sessions, zero values match everything.
+// Sessions are returned newest first, BeforeId continues a previous page.
+type PlayerSessionFilter struct {
+	UUID     string
+	RouteIds []string // nil matches every route
+	Since    int64    // left at or after
+	Until    int64    // left before
+	BeforeId int64
+	Limit    int
+}
+
+// PlayerStats are the statistics of a UTC day. Sessions are counted on the
+// day they end, unique players and the peak on every day they overlap.
+type PlayerStats struct {
+	Day             string `json:"day"`
+	RouteId         string `json:"route_id"`
+	UniquePlayers   int    `json:"unique_players"`
+	Sessions        int    `json:"sessions"`
+	Playtime        int64  `json:"playtime"` // seconds of all sessions
+	PeakConcurrency int    `json:"peak_concurrency"`
+	Bytes           int64  `json:"bytes"`
+}
+
+// TopPlayer sums up the sessions of a player
+type TopPlayer struct {
+	UUID     string `json:"uuid"`
+	Name     string `json:"name"` // of the latest session
+	Sessions int    `json:"sessions"`
+	Playtime int64  `json:"playtime"`
+	LastSeen int64  `json:"last_seen"`
+}
+
+const playerSessionColumns = "id, uuid, name, route_id, proxy_domain, node_id, client_ip, protocol_version, joined_at, left_at, bytes_to_server, bytes_to_client"
+
+func AddPlayerSession(s PlayerSession) error {
+	_, err := db.Exec("INSERT INTO player_sessions (uuid, name, route_id, proxy_domain, node_id, client_ip, protocol_version, joined_at, left_at, bytes_to_server, bytes_to_client) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", s.UUID, s.Name, s.RouteId, s.ProxyDomain, s.NodeId, s.ClientIP, s.ProtocolVersion, s.JoinedAt, s.LeftAt, s.BytesToServer, s.BytesToClient)
+	return err
+}
+
+func GetPlayerSessions(filter PlayerSessionFilter) ([]PlayerSession, error) {
+	where, args := sessionWhere(filter.RouteIds, filter.Since, filter.Until)
+
+	if filter.UUID != "" {
+		where = append(where, "uuid = ?")
+		args = append(args, filter.UUID)
+	}
+
+	if filter.BeforeId > 0 {
+		where = append(where, "id < ?")
+		args = append(args, filter.BeforeId)
+	}
+
+	query := "SELECT " + playerSessionColumns + " FROM player_sessions"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []PlayerSession{}
+	for rows.Next() {
+		var s PlayerSession
+		err := rows.Scan(&s.Id, &s.UUID, &s.Name, &s.RouteId, &s.ProxyDomain, &s.NodeId, &s.ClientIP, &s.ProtocolVersion, &s.JoinedAt, &s.LeftAt, &s.BytesToServer, &s.BytesToClient)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// GetTopPlayers ranks players by playtime of the sessions that ended since
+func GetTopPlayers(routeIds []string, since int64, limit int) ([]TopPlayer, error) {
+	where, args := sessionWhere(routeIds, since, 0)
+
+	query := "SELECT uuid, (SELECT name FROM player_sessions latest WHERE latest.uuid = s.uuid ORDER BY id DESC LIMIT 1), COUNT(*), SUM(left_at - joined_at), MAX(left_at) FROM player_sessions s"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	query += " GROUP BY uuid ORDER BY 4 DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	players := []TopPlayer{}
+	for rows.Next() {
+		var p TopPlayer
+		err := rows.Scan(&p.UUID, &p.Name, &p.Sessions, &p.Playtime, &p.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+
+		players = append(players, p)
+	}
+
+	return players, rows.Err()
+}
+
+func sessionWhere(routeIds []string, since, until int64) ([]string, []any) {
+	var where []string
+	var args []any
+
+	if routeIds != nil {
+		if len(routeIds) == 0 {
+			return []string{"0"}, nil
+		}
+
+		where = append(where, "route_id IN (?"+strings.Repeat(", ?", len(routeIds)-1)+")")
+		for _, routeId := range routeIds {
+			args = append(args, routeId)
+		}
+	}
+
+	if since > 0 {
+		where = append(where, "left_at >= ?")
+		args = append(args, since)
+	}
+
+	if until > 0 {
+		where = append(where, "left_at < ?")
+		args = append(args, until)
+	}
+
+	return where, args
+}
+
+// GetPlayerStats returns the rolled up days of a route from since to until,
+// both inclusive and formatted as 2006-01-02
+func GetPlayerStats(routeId string, since, until string) ([]PlayerStats, error) {
+	rows, err := db.Query("SELECT day, route_id, unique_players, sessions, playtime, peak_concurrency, bytes FROM player_stats_daily WHERE route_id = ? AND day >= ? AND day <= ? ORDER BY day", routeId, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []PlayerStats{}
+	for rows.Next() {
+		var s PlayerStats
+		err := rows.Scan(&s.Day, &s.RouteId, &s.UniquePlayers, &s.Sessions, &s.Playtime, &s.PeakConcurrency, &s.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
+
+// ComputePlayerStats computes the statistics of a day from the recorded
+// sessions, one entry per route with sessions and one for AllRoutes. open
+// are the sessions of the players online now, they count towards unique
+// players and the peak but only end on a later day.
+func ComputePlayerStats(day time.Time, open []PlayerSession) ([]PlayerStats, error) {
+	start := day.UTC().Truncate(24 * time.Hour)
+	from, to := start.Unix(), start.Add(24*time.Hour).Unix()
+
+	rows, err := db.Query("SELECT uuid, route_id, joined_at, left_at, bytes_to_server + bytes_to_client FROM player_sessions WHERE left_at >= ? AND joined_at < ?", from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type visit struct {
+		uuid, routeId    string
+		joinedAt, leftAt int64
+		bytes            int64
+	}
+
+	byRoute := map[string][]visit{}
+	for rows.Next() {
+		var v visit
+		err := rows.Scan(&v.uuid, &v.routeId, &v.joinedAt, &v.leftAt, &v.bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		byRoute[v.routeId] = append(byRoute[v.routeId], v)
+		byRoute[AllRoutes] = append(byRoute[AllRoutes], v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, session := range open {
+		if session.JoinedAt >= to {
+			continue
+		}
+
+		v := visit{uuid: session.UUID, routeId: session.RouteId, joinedAt: session.JoinedAt, leftAt: math.MaxInt64}
+		byRoute[v.routeId] = append(byRoute[v.routeId], v)
+		byRoute[AllRoutes] = append(byRoute[AllRoutes], v)
+	}
+
+	stats := []PlayerStats{}
+	for routeId, visits := range byRoute {
+		s := PlayerStats{Day: start.Format(dayLayout), RouteId: routeId}
+		players := map[string]struct{}{}
+
+		// +1 on join, -1 on leave, both clipped to the day
+		type change struct {
+			at    int64
+			delta int
+		}
+		changes := make([]change, 0, 2*len(visits))
+
+		for _, v := range visits {
+			players[v.uuid] = struct{}{}
+			changes = append(changes, change{max(v.joinedAt, from), 1}, change{min(v.leftAt, to), -1})
+
+			if v.leftAt < to {
+				s.Sessions++
+				s.Playtime += v.leftAt - v.joinedAt
+				s.Bytes += v.bytes
+			}
+		}
+
+		// leaves sort before joins at the same second
+		sort.Slice(changes, func(i, j int) bool {
+			if changes[i].at != changes[j].at {
+				return changes[i].at < changes[j].at
+			}
+
+			return changes[i].delta < changes[j].delta
+		})
+
+		online := 0
+		for _, c := range changes {
+			online += c.delta
+			s.PeakConcurrency = max(s.PeakConcurrency, online)
+		}
+
+		s.UniquePlayers = len(players)
+		stats = append(stats, s)
+	}
+
+	return stats, nil
+}
+
+// RollupPlayerStats stores the statistics of every finished day since the
+// last rollup, days before the first recorded session are skipped. Players
+// still online since a finished day are passed as open sessions.
+func RollupPlayerStats(now time.Time, open []PlayerSession) error {
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	var last, first sql.NullString
+	err := db.QueryRow("SELECT MAX(day) FROM player_stats_daily").Scan(&last)
+	if err != nil {
+		return err
+	}
+
+	err = db.QueryRow("SELECT date(MIN(left_at), 'unixepoch') FROM player_sessions").Scan(&first)
+	if err != nil || !first.Valid {
+		return err
+	}
+
+	day, err := time.Parse(dayLayout, first.String)
+	if err != nil {
+		return err
+	}
+
+	if last.Valid {
+		rolled, err := time.Parse(dayLayout, last.String)
+		if err != nil {
+			return err
+		}
+
+		day = rolled.Add(24 * time.Hour)
+	}
+
+	for ; day.Before(today); day = day.Add(24 * time.Hour) {
+		stats, err := ComputePlayerStats(day, open)
+		if err != nil {
+			return err
+		}
+
+		// an empty day still marks the day as rolled up
+		if len(stats) == 0 {
+			stats = []PlayerStats{{Day: day.Format(dayLayout), RouteId: AllRoutes}}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		for _, s := range stats {
+			_, err = tx.Exec("INSERT OR REPLACE INTO player_stats_daily (day, route_id, unique_players, sessions, playtime, peak_concurrency, bytes) VALUES (?, ?, ?, ?, ?, ?, ?)", s.Day, s.RouteId, s.UniquePlayers, s.Sessions, s.Playtime, s.PeakConcurrency, s.Bytes)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PrunePlayerSessions deletes sessions that ended before, zero keeps them
+func PrunePlayerSessions(before int64) (int64, error) {
+	if before <= 0 {
+		return 0, nil
+	}
+
+	result, err := db.Exec("DELETE FROM player_sessions WHERE left_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// PrunePlayerStats deletes the rollups of the days before, zero keeps them
+func PrunePlayerStats(before time.Time) error {
+	if before.IsZero() {
+		return nil
+	}
+
+	_, err := db.Exec("DELETE FROM player_stats_daily WHERE day < ?", before.UTC().Format(dayLayout))
+	return err
+}
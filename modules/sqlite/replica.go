@@ -0,0 +1,164 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// ClusterVersion orders the states of the masters of a cluster. Every
+// leader starts a new term, a state of a later term is newer and within
+// a term the leader bumps the generation on every change.
+type ClusterVersion struct {
+	Term       uint64 `json:"term"`
+	Generation uint64 `json:"generation"`
+}
+
+// Older reports whether v is older than other
+func (v ClusterVersion) Older(other ClusterVersion) bool {
+	if v.Term != other.Term {
+		return v.Term < other.Term
+	}
+
+	return v.Generation < other.Generation
+}
+
+// GetClusterVersion returns the version of the local state and the hash
+// of the state it was recorded for
+func GetClusterVersion() (ClusterVersion, string, error) {
+	var v ClusterVersion
+	for key, value := range map[string]*uint64{"cluster_term": &v.Term, "cluster_generation": &v.Generation} {
+		s, err := getSetting(key)
+		if err != nil {
+			return ClusterVersion{}, "", err
+		}
+
+		*value, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return ClusterVersion{}, "", err
+		}
+	}
+
+	hash, err := getSetting("cluster_hash")
+	if err != nil {
+		return ClusterVersion{}, "", err
+	}
+
+	return v, hash, nil
+}
+
+// SetClusterVersion records the version of the local state
+func SetClusterVersion(v ClusterVersion, hash string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = setClusterVersion(tx, v, hash)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setClusterVersion(tx *sql.Tx, v ClusterVersion, hash string) error {
+	for key, value := range map[string]string{
+		"cluster_term":       strconv.FormatUint(v.Term, 10),
+		"cluster_generation": strconv.FormatUint(v.Generation, 10),
+		"cluster_hash":       hash,
+	} {
+		_, err := tx.Exec("UPDATE settings SET value = ? WHERE key = ?", value, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lastSeen reads the timestamps a follower writes itself by id, query
+// selects the id and the timestamp
+func lastSeen(tx *sql.Tx, query string) (map[string]int64, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[string]int64)
+	for rows.Next() {
+		var id string
+		var at int64
+		err = rows.Scan(&id, &at)
+		if err != nil {
+			return nil, err
+		}
+
+		seen[id] = at
+	}
+
+	return seen, rows.Err()
+}
+
+// Replica is the database state a cluster follower copies from its leader
+type Replica struct {
+	Version          ClusterVersion
+	Hash             string // of the state of the leader, recorded with Version
+	Roles            []Role
+	Tokens           []ApiToken
+	Sessions         []Session
+	Users            []User
+	Identities       []Identity
+	LocalCredentials []LocalCredential
+	Routes           []Route
+	RoutesGeneration uint64
+	Nodes            []Node
+}
+
+// ReplaceReplica swaps the replicated tables in one transaction, a
+// follower never serves half of the state of its leader
+func ReplaceReplica(r Replica) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRoles(tx, r.Roles)
+	if err != nil {
+		return err
+	}
+
+	err = replaceApiTokens(tx, r.Tokens)
+	if err != nil {
+		return err
+	}
+
+	err = replaceSessions(tx, r.Sessions)
+	if err != nil {
+		return err
+	}
+
+	err = replaceUsers(tx, r.Users, r.Identities, r.LocalCredentials)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRoutes(tx, r.Routes, r.RoutesGeneration)
+	if err != nil {
+		return err
+	}
+
+	err = replaceNodes(tx, r.Nodes)
+	if err != nil {
+		return err
+	}
+
+	err = setClusterVersion(tx, r.Version, r.Hash)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,117 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+var ErrRoleInUse = errors.New("role is assigned to users")
+
+func GetRoles() ([]Role, error) {
+	rows, err := db.Query("SELECT name, permissions FROM roles ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var name, permissions string
+		err := rows.Scan(&name, &permissions)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, Role{
+			Name:        name,
+			Permissions: splitList(permissions),
+		})
+	}
+
+	return roles, rows.Err()
+}
+
+func GetRole(name string) (Role, bool, error) {
+	var permissions string
+	err := db.QueryRow("SELECT permissions FROM roles WHERE name = ?", name).Scan(&permissions)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Role{}, false, nil
+	}
+
+	if err != nil {
+		return Role{}, false, err
+	}
+
+	return Role{
+		Name:        name,
+		Permissions: splitList(permissions),
+	}, true, nil
+}
+
+// SetRole creates a role or replaces its permissions
+func SetRole(role Role) error {
+	_, err := db.Exec("INSERT INTO roles (name, permissions) VALUES (?, ?) ON CONFLICT(name) DO UPDATE SET permissions = excluded.permissions", role.Name, strings.Join(role.Permissions, ","))
+	return err
+}
+
+// DeleteRole removes a role that no user holds anymore
+func DeleteRole(name string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var holders int
+	err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", name).Scan(&holders)
+	if err != nil {
+		return false, err
+	}
+
+	if holders > 0 {
+		return false, ErrRoleInUse
+	}
+
+	res, err := tx.Exec("DELETE FROM roles WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, tx.Commit()
+}
+
+// replaceRoles swaps all roles
+func replaceRoles(tx *sql.Tx, roles []Role) error {
+	_, err := tx.Exec("DELETE FROM roles")
+	if err != nil {
+		return err
+	}
+
+	for _, r := range roles {
+		_, err = tx.Exec("INSERT INTO roles (name, permissions) VALUES (?, ?)", r.Name, strings.Join(r.Permissions, ","))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,334 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"wired.rip/wiredutils/protocol"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrRevisionMismatch is returned when a route changed since it was read
+var ErrRevisionMismatch = errors.New("route was changed concurrently")
+
+// ErrDomainTaken is returned when another route already uses the proxy domain
+var ErrDomainTaken = errors.New("proxy domain is used by another route")
+
+// Route is a route as the master stores it, nodes only receive the
+// embedded protocol.Route of enabled routes
+type Route struct {
+	protocol.Route
+	Owner       string   `json:"owner"` // user id, empty for routes only admins manage
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Enabled     bool     `json:"enabled"`
+	Tags        []string `json:"tags"`
+	Revision    int64    `json:"revision"` // bumped on every change
+
+	// placement, a route without nodes and groups is served by every node
+	Nodes  []string `json:"nodes"`
+	Groups []string `json:"groups"`
+}
+
+// PlacedOn reports whether node serves the route
+func (r Route) PlacedOn(node Node) bool {
+	if len(r.Nodes) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+
+	if slices.Contains(r.Nodes, node.Id) {
+		return true
+	}
+
+	for _, group := range node.Groups {
+		if slices.Contains(r.Groups, group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+const routeColumns = "route_id, server_host, server_port, proxy_domain, proxy_port, owner, name, description, enabled, tags, connect_timeout, idle_timeout, protocols, placement_nodes, placement_groups, revision"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRoute(row scanner) (Route, error) {
+	var r Route
+	var tags, protocols, nodes, groups string
+	err := row.Scan(&r.RouteId, &r.ServerHost, &r.ServerPort, &r.ProxyDomain, &r.ProxyPort, &r.Owner, &r.Name, &r.Description, &r.Enabled, &tags, &r.ConnectTimeout, &r.IdleTimeout, &protocols, &nodes, &groups, &r.Revision)
+	if err != nil {
+		return r, err
+	}
+
+	r.Tags = splitList(tags)
+	r.Nodes = splitList(nodes)
+	r.Groups = splitList(groups)
+	if protocols != "" {
+		err = json.Unmarshal([]byte(protocols), &r.Protocols)
+	}
+
+	return r, err
+}
+
+// routeValues returns the columns of routeColumns after route_id
+func routeValues(r Route) []any {
+	protocols := ""
+	if len(r.Protocols) > 0 {
+		data, _ := json.Marshal(r.Protocols)
+		protocols = string(data)
+	}
+
+	return []any{r.ServerHost, r.ServerPort, r.ProxyDomain, r.ProxyPort, r.Owner, r.Name, r.Description, r.Enabled, strings.Join(r.Tags, ","), r.ConnectTimeout, r.IdleTimeout, protocols, strings.Join(r.Nodes, ","), strings.Join(r.Groups, ",")}
+}
+
+func queryRoutes(q interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, where string, args ...any) ([]Route, error) {
+	rows, err := q.Query("SELECT "+routeColumns+" FROM routes "+where+" ORDER BY rowid", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := []Route{}
+	for rows.Next() {
+		r, err := scanRoute(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, r)
+	}
+
+	return routes, rows.Err()
+}
+
+func GetRoutes() ([]Route, error) {
+	return queryRoutes(db, "")
+}
+
+func GetRoutesByOwner(owner string) ([]Route, error) {
+	return queryRoutes(db, "WHERE owner = ?", owner)
+}
+
+func GetRoute(routeId string) (Route, bool, error) {
+	return getRouteWhere("route_id = ?", routeId)
+}
+
+func GetRouteByProxyDomain(proxyDomain string) (Route, bool, error) {
+	return getRouteWhere("proxy_domain = ?", proxyDomain)
+}
+
+func getRouteWhere(where string, args ...any) (Route, bool, error) {
+	r, err := scanRoute(db.QueryRow("SELECT "+routeColumns+" FROM routes WHERE "+where, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Route{}, false, nil
+	}
+
+	return r, err == nil, err
+}
+
+func AddRoute(route Route) error {
+	err := routesTx(func(tx *sql.Tx) error {
+		return insertRoute(tx, route)
+	})
+
+	return domainConflict(err)
+}
+
+// domainConflict reports a violated unique proxy domain as ErrDomainTaken,
+// checks before writing can not see a route added at the same time
+func domainConflict(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique && strings.Contains(sqliteErr.Error(), "routes.proxy_domain") {
+		return ErrDomainTaken
+	}
+
+	return err
+}
+
+func insertRoute(tx *sql.Tx, r Route) error {
+	args := append([]any{r.RouteId}, routeValues(r)...)
+	_, err := tx.Exec("INSERT INTO routes ("+routeColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", append(args, r.Revision)...)
+	return err
+}
+
+// UpdateRoute saves the changed fields of a route if it is still at
+// route.Revision, the route id stays and the revision is bumped
+func UpdateRoute(route Route) (bool, error) {
+	found := false
+	err := routesTx(func(tx *sql.Tx) error {
+		args := append(routeValues(route), route.RouteId, route.Revision)
+		res, err := tx.Exec(`UPDATE routes SET server_host = ?, server_port = ?, proxy_domain = ?, proxy_port = ?, owner = ?,
+			name = ?, description = ?, enabled = ?, tags = ?, connect_timeout = ?, idle_timeout = ?, protocols = ?,
+			placement_nodes = ?, placement_groups = ?, revision = revision + 1 WHERE route_id = ? AND revision = ?`, args...)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n > 0 {
+			found = true
+			return nil
+		}
+
+		// tell a missing route apart from one that moved on
+		var revision int64
+		err = tx.QueryRow("SELECT revision FROM routes WHERE route_id = ?", route.RouteId).Scan(&revision)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errNoChange
+		}
+
+		if err != nil {
+			return err
+		}
+
+		found = true
+		return ErrRevisionMismatch
+	})
+
+	if errors.Is(err, errNoChange) {
+		return false, nil
+	}
+
+	return found, domainConflict(err)
+}
+
+// DeleteRoute removes a route and reports whether it existed
+func DeleteRoute(routeId string) (bool, error) {
+	found := false
+	err := routesTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec("DELETE FROM routes WHERE route_id = ?", routeId)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		found = n > 0
+		if !found {
+			return errNoChange
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, errNoChange) {
+		return false, nil
+	}
+
+	return found, err
+}
+
+// GetRoutesGeneration returns a counter that is bumped on every route change
+func GetRoutesGeneration() (uint64, error) {
+	value, err := getSetting("routes_generation")
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// GetRoutesWithGeneration reads the routes and their generation consistently
+func GetRoutesWithGeneration() ([]Route, uint64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	var value string
+	err = tx.QueryRow("SELECT value FROM settings WHERE key = 'routes_generation'").Scan(&value)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	generation, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	routes, err := queryRoutes(tx, "")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return routes, generation, nil
+}
+
+// replaceRoutes swaps all routes and sets their generation
+func replaceRoutes(tx *sql.Tx, routes []Route, generation uint64) error {
+	_, err := tx.Exec("DELETE FROM routes")
+	if err != nil {
+		return err
+	}
+
+	for _, r := range routes {
+		err = insertRoute(tx, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE settings SET value = ? WHERE key = 'routes_generation'", strconv.FormatUint(generation, 10))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var errNoChange = errors.New("no change")
+
+// routesTx runs a route mutation and bumps the routes generation
+// in the same transaction
+func routesTx(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE settings SET value = CAST(value AS INTEGER) + 1 WHERE key = 'routes_generation'")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// WireRoutes returns the routes sent to node without their master side
+// fields, disabled routes and routes placed elsewhere are left out
+func WireRoutes(routes []Route, node Node) []protocol.Route {
+	wire := make([]protocol.Route, 0, len(routes))
+	for _, r := range routes {
+		if !r.Enabled || !r.PlacedOn(node) {
+			continue
+		}
+
+		wire = append(wire, r.Route)
+	}
+
+	return wire
+}
@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+)
+
+type Session struct {
+	Id           string `json:"id"`
+	UserId       string `json:"user_id"`
+	RefreshHash  string `json:"refresh_hash,omitempty"`  // only replicated between masters, never listed
+	PreviousHash string `json:"previous_hash,omitempty"` // refresh token replaced by the last rotation
+	CreatedAt    int64  `json:"created_at"`
+	RefreshedAt  int64  `json:"refreshed_at"`
+	ExpiresAt    int64  `json:"expires_at"`
+	RevokedAt    int64  `json:"revoked_at"`
+	Ip           string `json:"ip"`
+	UserAgent    string `json:"user_agent"`
+}
+
+// Active reports whether access tokens of the session are still accepted
+func (s Session) Active(now int64) bool {
+	return s.RevokedAt == 0 && s.ExpiresAt > now
+}
+
+const sessionColumns = "session_id, user_id, refresh_hash, previous_hash, created_at, refreshed_at, expires_at, revoked_at, ip, user_agent"
+
+func scanSession(row scanner) (Session, error) {
+	var s Session
+	err := row.Scan(&s.Id, &s.UserId, &s.RefreshHash, &s.PreviousHash, &s.CreatedAt, &s.RefreshedAt, &s.ExpiresAt, &s.RevokedAt, &s.Ip, &s.UserAgent)
+	return s, err
+}
+
+func CreateSession(s Session) error {
+	_, err := db.Exec("INSERT INTO sessions ("+sessionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", s.Id, s.UserId, s.RefreshHash, s.PreviousHash, s.CreatedAt, s.RefreshedAt, s.ExpiresAt, s.RevokedAt, s.Ip, s.UserAgent)
+	return err
+}
+
+func GetSession(sessionId string) (Session, bool, error) {
+	return getSessionWhere("session_id = ?", sessionId)
+}
+
+func GetSessionByRefreshHash(hash string) (Session, bool, error) {
+	return getSessionWhere("refresh_hash = ?", hash)
+}
+
+// GetSessionByPreviousHash finds the session a rotated refresh token
+// belonged to, presenting it again means it was stolen
+func GetSessionByPreviousHash(hash string) (Session, bool, error) {
+	return getSessionWhere("previous_hash = ?", hash)
+}
+
+func getSessionWhere(where string, args ...any) (Session, bool, error) {
+	s, err := scanSession(db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE "+where, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, false, nil
+	}
+
+	return s, err == nil, err
+}
+
+// GetSessions lists the sessions of a user that are not revoked or expired
+func GetSessions(userId string, now int64) ([]Session, error) {
+	return querySessions("user_id = ? AND revoked_at = 0 AND expires_at > ?", userId, now)
+}
+
+// RotateSession replaces the refresh token of an active session, it fails
+// if the token was rotated concurrently
+func RotateSession(sessionId, oldHash, newHash string, refreshedAt, expiresAt int64) (bool, error) {
+	res, err := db.Exec("UPDATE sessions SET refresh_hash = ?, previous_hash = ?, refreshed_at = ?, expires_at = ? WHERE session_id = ? AND refresh_hash = ? AND revoked_at = 0", newHash, oldHash, refreshedAt, expiresAt, sessionId, oldHash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func RevokeSession(sessionId string, revokedAt int64) error {
+	_, err := db.Exec("UPDATE sessions SET revoked_at = ? WHERE session_id = ? AND revoked_at = 0", revokedAt, sessionId)
+	return err
+}
+
+// RevokeSessions signs a user out everywhere and returns the number of
+// revoked sessions
+func RevokeSessions(userId string, revokedAt int64) (int64, error) {
+	res, err := db.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at = 0", revokedAt, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// GetAllSessions returns the sessions that can still be used,
+// for replication to cluster followers
+func GetAllSessions(now int64) ([]Session, error) {
+	return querySessions("revoked_at = 0 AND expires_at > ?", now)
+}
+
+func querySessions(where string, args ...any) ([]Session, error) {
+	rows, err := db.Query("SELECT "+sessionColumns+" FROM sessions WHERE "+where+" ORDER BY created_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// replaceSessions swaps all sessions
+func replaceSessions(tx *sql.Tx, sessions []Session) error {
+	_, err := tx.Exec("DELETE FROM sessions")
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		_, err = tx.Exec("INSERT INTO sessions ("+sessionColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", s.Id, s.UserId, s.RefreshHash, s.PreviousHash, s.CreatedAt, s.RefreshedAt, s.ExpiresAt, s.RevokedAt, s.Ip, s.UserAgent)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
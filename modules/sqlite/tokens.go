@@ -0,0 +1,125 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// ApiTokenPrefix tells API tokens apart from JWTs and makes them easy
+// to spot for secret scanners
+const ApiTokenPrefix = "wired_"
+
+const (
+	TokenPersonal = "personal" // acts as its owner, limited to its scopes
+	TokenService  = "service"  // not bound to a user, holds only its scopes
+)
+
+type ApiToken struct {
+	Id         string   `json:"id"`
+	Name       string   `json:"name"`
+	Kind       string   `json:"kind"`
+	Owner      string   `json:"owner"` // user id, or "service:<token id>" for service tokens
+	CreatedBy  string   `json:"created_by"`
+	Hash       string   `json:"hash,omitempty"` // only replicated between masters, never listed
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+	LastUsedAt int64    `json:"last_used_at"`
+	RevokedAt  int64    `json:"revoked_at"`
+}
+
+const tokenColumns = "token_id, name, kind, owner, created_by, hash, scopes, created_at, expires_at, last_used_at, revoked_at"
+
+func scanToken(row scanner) (ApiToken, error) {
+	var t ApiToken
+	var scopes string
+	err := row.Scan(&t.Id, &t.Name, &t.Kind, &t.Owner, &t.CreatedBy, &t.Hash, &scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt)
+	t.Scopes = splitList(scopes)
+	return t, err
+}
+
+func CreateApiToken(t ApiToken) error {
+	_, err := db.Exec("INSERT INTO api_tokens ("+tokenColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", t.Id, t.Name, t.Kind, t.Owner, t.CreatedBy, t.Hash, strings.Join(t.Scopes, ","), t.CreatedAt, t.ExpiresAt, t.LastUsedAt, t.RevokedAt)
+	return err
+}
+
+func GetApiToken(tokenId string) (ApiToken, bool, error) {
+	t, err := scanToken(db.QueryRow("SELECT "+tokenColumns+" FROM api_tokens WHERE token_id = ?", tokenId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ApiToken{}, false, nil
+	}
+
+	return t, err == nil, err
+}
+
+func GetApiTokenByHash(hash string) (ApiToken, bool, error) {
+	t, err := scanToken(db.QueryRow("SELECT "+tokenColumns+" FROM api_tokens WHERE hash = ?", hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ApiToken{}, false, nil
+	}
+
+	return t, err == nil, err
+}
+
+// GetApiTokens lists the tokens of an owner, or every token if owner is empty
+func GetApiTokens(owner string) ([]ApiToken, error) {
+	query := "SELECT " + tokenColumns + " FROM api_tokens"
+	var args []any
+	if owner != "" {
+		query += " WHERE owner = ?"
+		args = append(args, owner)
+	}
+
+	rows, err := db.Query(query+" ORDER BY created_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []ApiToken{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+func RevokeApiToken(tokenId string, revokedAt int64) error {
+	_, err := db.Exec("UPDATE api_tokens SET revoked_at = ? WHERE token_id = ? AND revoked_at = 0", revokedAt, tokenId)
+	return err
+}
+
+func SetApiTokenLastUsed(tokenId string, lastUsedAt int64) error {
+	_, err := db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE token_id = ?", lastUsedAt, tokenId)
+	return err
+}
+
+// replaceApiTokens swaps all tokens. Every master tracks the uses of the
+// tokens it authenticated, so the later of both last uses is kept.
+func replaceApiTokens(tx *sql.Tx, tokens []ApiToken) error {
+	used, err := lastSeen(tx, "SELECT token_id, last_used_at FROM api_tokens")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM api_tokens")
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tokens {
+		t.LastUsedAt = max(t.LastUsedAt, used[t.Id])
+		_, err = tx.Exec("INSERT INTO api_tokens ("+tokenColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", t.Id, t.Name, t.Kind, t.Owner, t.CreatedBy, t.Hash, strings.Join(t.Scopes, ","), t.CreatedAt, t.ExpiresAt, t.LastUsedAt, t.RevokedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,385 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// User is keyed by an internal id, sign-in methods are linked to it as
+// identities and local credentials
+type User struct {
+	Id        string `json:"id"`
+	Username  string `json:"username"`
+	Avatar    string `json:"avatar"` // image URL, may be empty
+	Role      string `json:"role"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Identity links an account of an external provider to a user
+type Identity struct {
+	Provider  string `json:"provider"` // id of the configured provider
+	Subject   string `json:"subject"`  // stable account id at the provider
+	UserId    string `json:"user_id"`
+	Username  string `json:"username"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type LocalCredential struct {
+	UserId       string `json:"user_id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	TotpSecret   string `json:"totp_secret"`
+	TotpEnabled  bool   `json:"totp_enabled"`
+	TotpLastStep int64  `json:"totp_last_step"`
+}
+
+var (
+	ErrIdentityTaken    = errors.New("identity is linked to another user")
+	ErrUsernameTaken    = errors.New("username is taken")
+	ErrLastSignInMethod = errors.New("the last sign-in method of a user can not be removed")
+)
+
+const (
+	userColumns     = "user_id, username, avatar, role, created_at"
+	identityColumns = "provider, subject, user_id, username, created_at"
+	localColumns    = "user_id, username, password_hash, totp_secret, totp_enabled, totp_last_step"
+)
+
+func scanUser(row scanner) (User, error) {
+	var u User
+	err := row.Scan(&u.Id, &u.Username, &u.Avatar, &u.Role, &u.CreatedAt)
+	return u, err
+}
+
+func scanIdentity(row scanner) (Identity, error) {
+	var i Identity
+	err := row.Scan(&i.Provider, &i.Subject, &i.UserId, &i.Username, &i.CreatedAt)
+	return i, err
+}
+
+func scanLocalCredential(row scanner) (LocalCredential, error) {
+	var c LocalCredential
+	err := row.Scan(&c.UserId, &c.Username, &c.PasswordHash, &c.TotpSecret, &c.TotpEnabled, &c.TotpLastStep)
+	return c, err
+}
+
+// CreateUserWithIdentity signs up a user through an external provider
+func CreateUserWithIdentity(u User, identity Identity) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?)", u.Id, u.Username, u.Avatar, u.Role, u.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	identity.UserId = u.Id
+	err = insertIdentity(tx, identity)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateLocalUser creates a user that signs in with a username and password
+func CreateLocalUser(u User, credential LocalCredential) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var taken int
+	err = tx.QueryRow("SELECT COUNT(*) FROM local_credentials WHERE username = ?", credential.Username).Scan(&taken)
+	if err != nil {
+		return err
+	}
+
+	if taken > 0 {
+		return ErrUsernameTaken
+	}
+
+	_, err = tx.Exec("INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?)", u.Id, u.Username, u.Avatar, u.Role, u.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	credential.UserId = u.Id
+	_, err = tx.Exec("INSERT INTO local_credentials ("+localColumns+") VALUES (?, ?, ?, ?, ?, ?)", credential.UserId, credential.Username, credential.PasswordHash, credential.TotpSecret, credential.TotpEnabled, credential.TotpLastStep)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func GetUser(userId string) (User, bool, error) {
+	u, err := scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE user_id = ?", userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, false, nil
+	}
+
+	return u, err == nil, err
+}
+
+func GetUsers() ([]User, error) {
+	rows, err := db.Query("SELECT " + userColumns + " FROM users ORDER BY created_at, user_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// UpdateUserProfile refreshes the name and avatar shown for a user
+func UpdateUserProfile(userId, username, avatar string) error {
+	_, err := db.Exec("UPDATE users SET username = ?, avatar = ? WHERE user_id = ?", username, avatar, userId)
+	return err
+}
+
+func ChangeUserRole(userId, role string) error {
+	_, err := db.Exec("UPDATE users SET role = ? WHERE user_id = ?", role, userId)
+	return err
+}
+
+// DeleteUser removes a user together with its sign-in methods
+func DeleteUser(userId string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"identities", "local_credentials", "users"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func GetIdentity(provider, subject string) (Identity, bool, error) {
+	i, err := scanIdentity(db.QueryRow("SELECT "+identityColumns+" FROM identities WHERE provider = ? AND subject = ?", provider, subject))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Identity{}, false, nil
+	}
+
+	return i, err == nil, err
+}
+
+// GetIdentities lists the identities of a user, or of every user when
+// userId is empty
+func GetIdentities(userId string) ([]Identity, error) {
+	query := "SELECT " + identityColumns + " FROM identities"
+	var args []any
+	if userId != "" {
+		query += " WHERE user_id = ?"
+		args = append(args, userId)
+	}
+
+	rows, err := db.Query(query+" ORDER BY created_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, i)
+	}
+
+	return identities, rows.Err()
+}
+
+// LinkIdentity adds a sign-in method to an existing user
+func LinkIdentity(identity Identity) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertIdentity(tx, identity)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertIdentity(tx *sql.Tx, identity Identity) error {
+	var owner string
+	err := tx.QueryRow("SELECT user_id FROM identities WHERE provider = ? AND subject = ?", identity.Provider, identity.Subject).Scan(&owner)
+	if err == nil {
+		if owner != identity.UserId {
+			return ErrIdentityTaken
+		}
+
+		return nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO identities ("+identityColumns+") VALUES (?, ?, ?, ?, ?)", identity.Provider, identity.Subject, identity.UserId, identity.Username, identity.CreatedAt)
+	return err
+}
+
+// UnlinkIdentity removes a sign-in method, users always keep at least one
+func UnlinkIdentity(userId, provider string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var identities, local int
+	err = tx.QueryRow("SELECT COUNT(*) FROM identities WHERE user_id = ?", userId).Scan(&identities)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.QueryRow("SELECT COUNT(*) FROM local_credentials WHERE user_id = ?", userId).Scan(&local)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.Exec("DELETE FROM identities WHERE user_id = ? AND provider = ?", userId, provider)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if n == 0 {
+		return false, nil
+	}
+
+	if identities+local-int(n) < 1 {
+		return false, ErrLastSignInMethod
+	}
+
+	return true, tx.Commit()
+}
+
+func GetLocalCredential(userId string) (LocalCredential, bool, error) {
+	return getLocalCredentialWhere("user_id = ?", userId)
+}
+
+func GetLocalCredentialByUsername(username string) (LocalCredential, bool, error) {
+	return getLocalCredentialWhere("username = ?", username)
+}
+
+func getLocalCredentialWhere(where string, args ...any) (LocalCredential, bool, error) {
+	c, err := scanLocalCredential(db.QueryRow("SELECT "+localColumns+" FROM local_credentials WHERE "+where, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return LocalCredential{}, false, nil
+	}
+
+	return c, err == nil, err
+}
+
+func SetPasswordHash(userId, hash string) error {
+	_, err := db.Exec("UPDATE local_credentials SET password_hash = ? WHERE user_id = ?", hash, userId)
+	return err
+}
+
+// SetTotp stores the TOTP secret of a user, it only protects sign-ins
+// once enabled
+func SetTotp(userId, secret string, enabled bool) error {
+	_, err := db.Exec("UPDATE local_credentials SET totp_secret = ?, totp_enabled = ? WHERE user_id = ?", secret, enabled, userId)
+	return err
+}
+
+// UseTotpStep records the TOTP step a code of the user was accepted for.
+// It fails if that step or a later one was used before, so every code is
+// only accepted once.
+func UseTotpStep(userId string, step int64) (bool, error) {
+	res, err := db.Exec("UPDATE local_credentials SET totp_last_step = ? WHERE user_id = ? AND totp_last_step < ?", step, userId, step)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// GetAllLocalCredentials is only used to replicate the leading master
+func GetAllLocalCredentials() ([]LocalCredential, error) {
+	rows, err := db.Query("SELECT " + localColumns + " FROM local_credentials")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []LocalCredential{}
+	for rows.Next() {
+		c, err := scanLocalCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		credentials = append(credentials, c)
+	}
+
+	return credentials, rows.Err()
+}
+
+// replaceUsers swaps all users and their sign-in methods
+func replaceUsers(tx *sql.Tx, users []User, identities []Identity, credentials []LocalCredential) error {
+	for _, table := range []string{"users", "identities", "local_credentials"} {
+		_, err := tx.Exec("DELETE FROM " + table)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, u := range users {
+		_, err := tx.Exec("INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?)", u.Id, u.Username, u.Avatar, u.Role, u.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, i := range identities {
+		_, err := tx.Exec("INSERT INTO identities ("+identityColumns+") VALUES (?, ?, ?, ?, ?)", i.Provider, i.Subject, i.UserId, i.Username, i.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range credentials {
+		_, err := tx.Exec("INSERT INTO local_credentials ("+localColumns+") VALUES (?, ?, ?, ?, ?, ?)", c.UserId, c.Username, c.PasswordHash, c.TotpSecret, c.TotpEnabled, c.TotpLastStep)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+)
+
+// asset states as last reported by each node
+var (
+	NodeAssets    = make(map[string]map[string]string)
+	NodeAssetsMux = &sync.Mutex{}
+)
+
+func SetNodeAssets(nodeId string, assets map[string]string) {
+	NodeAssetsMux.Lock()
+	defer NodeAssetsMux.Unlock()
+
+	NodeAssets[nodeId] = assets
+}
+
+// GetNodeAssets returns a copy of the asset name -> hash map a node reported
+func GetNodeAssets(nodeId string) (map[string]string, bool) {
+	NodeAssetsMux.Lock()
+	defer NodeAssetsMux.Unlock()
+
+	assets, ok := NodeAssets[nodeId]
+	if !ok {
+		return nil, false
+	}
+
+	copied := make(map[string]string, len(assets))
+	for name, hash := range assets {
+		copied[name] = hash
+	}
+
+	return copied, true
+}
+
+func RemoveNodeAssets(nodeId string) {
+	NodeAssetsMux.Lock()
+	defer NodeAssetsMux.Unlock()
+
+	delete(NodeAssets, nodeId)
+}
+
+// ValidAssetName reports whether name is safe to use as a file name
+// inside the asset directory
+func ValidAssetName(name string) bool {
+	if name == "" || len(name) > 128 || strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+
+	return true
+}
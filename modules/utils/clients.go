@@ -2,45 +2,86 @@ package utils
 
 import (
 	"sync"
+	"time"
 
 	"wired.rip/wiredutils/protocol"
 )
 
 type Node struct {
-	Key  string
-	Arch string
+	Key      string
+	Version  string
+	Arch     string
+	OS       string
+	Platform string
+	Address  string // remote address of the connection
+
+	ConnectedAt time.Time
+	LastPing    time.Time
+	Liveness    *Liveness // heartbeats and round trip times
+
+	RouteDeltas bool // accepts incremental route updates
 }
 
+// Client is the connection of a node, ConnId tells apart the connections
+// of a node that reconnected
 type Client struct {
-	Key  string
-	Conn protocol.Conn
-	Data Node
+	Key    string
+	ConnId uint64
+	Conn   *protocol.Conn
+	Data   Node
 }
 
 var (
-	Clients      = make(map[string]Client)
+	Clients      = make(map[string]Client) // by node id
 	ClientsMutex = &sync.Mutex{}
+	lastConnId   uint64
 )
 
-func AddClient(key string, conn protocol.Conn, data Node) {
+// AddClient registers the connection of a node. A node has one connection,
+// an earlier one is replaced and returned so the caller can close it.
+func AddClient(key string, conn *protocol.Conn, data Node) (uint64, Client, bool) {
+	ClientsMutex.Lock()
+	defer ClientsMutex.Unlock()
+
+	previous, replaced := Clients[key]
+
+	lastConnId++
+	Clients[key] = Client{
+		Key:    key,
+		ConnId: lastConnId,
+		Conn:   conn,
+		Data:   data,
+	}
+
+	return lastConnId, previous, replaced
+}
+
+// RemoveClient removes the connection of a node and reports whether it was
+// still registered, a connection that was replaced is not
+func RemoveClient(key string, connId uint64) bool {
 	ClientsMutex.Lock()
 	defer ClientsMutex.Unlock()
 
-	Clients[conn.RemoteAddr().String()] = Client{
-		Key:  key,
-		Conn: conn,
-		Data: data,
+	if client, ok := Clients[key]; ok && client.ConnId == connId {
+		delete(Clients, key)
+		return true
 	}
+
+	return false
 }
 
-func RemoveClient(key string) {
+// TouchClient records a ping of a node
+func TouchClient(key string, connId uint64) {
 	ClientsMutex.Lock()
 	defer ClientsMutex.Unlock()
 
-	delete(Clients, key)
+	if client, ok := Clients[key]; ok && client.ConnId == connId {
+		client.Data.LastPing = time.Now()
+		Clients[key] = client
+	}
 }
 
-func FindClient(key string) (protocol.Conn, Node, bool) {
+func FindClient(key string) (*protocol.Conn, Node, bool) {
 	ClientsMutex.Lock()
 	defer ClientsMutex.Unlock()
 
@@ -48,16 +89,29 @@ func FindClient(key string) (protocol.Conn, Node, bool) {
 		return client.Conn, client.Data, true
 	}
 
-	return protocol.Conn{}, Node{}, false
+	return nil, Node{}, false
 }
 
-func GetClients() map[string]protocol.Conn {
+// ListClients returns the connected nodes with their data
+func ListClients() []Client {
 	ClientsMutex.Lock()
 	defer ClientsMutex.Unlock()
 
-	clients := make(map[string]protocol.Conn)
+	clients := make([]Client, 0, len(Clients))
 	for _, client := range Clients {
-		clients[client.Key] = client.Conn
+		clients = append(clients, client)
+	}
+
+	return clients
+}
+
+func GetClients() map[string]*protocol.Conn {
+	ClientsMutex.Lock()
+	defer ClientsMutex.Unlock()
+
+	clients := make(map[string]*protocol.Conn)
+	for key, client := range Clients {
+		clients[key] = client.Conn
 	}
 
 	return clients
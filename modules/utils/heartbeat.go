@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// both ends ping each other this often
+	HeartbeatInterval = 10 * time.Second
+
+	// a peer nothing was received from for this many intervals is dead,
+	// its connection is closed
+	MissedHeartbeats = 3
+
+	rttHistorySize = 60
+
+	// a peer nothing was received from for longer is degraded, it
+	// missed a heartbeat but may still catch up
+	degradedAfter = HeartbeatInterval + HeartbeatInterval/2
+)
+
+// LivenessState is how a peer answers heartbeats
+type LivenessState string
+
+const (
+	Healthy  LivenessState = "healthy"
+	Degraded LivenessState = "degraded"
+	Dead     LivenessState = "dead"
+)
+
+type RTTSample struct {
+	At  int64   `json:"at"`
+	RTT float64 `json:"rtt_ms"`
+}
+
+type LivenessStats struct {
+	LastReceived time.Time
+	RTT          time.Duration // of the latest pong, zero before the first
+	Jitter       time.Duration // smoothed RTT variation as in RFC 3550
+	History      []RTTSample   // oldest first
+}
+
+// Liveness tracks the heartbeats of a connection. Any packet counts as
+// a sign of life, pongs to our pings also measure the round trip.
+type Liveness struct {
+	mux          sync.Mutex
+	lastReceived time.Time
+	rtt          time.Duration
+	jitter       time.Duration
+	history      []RTTSample
+}
+
+func NewLiveness() *Liveness {
+	return &Liveness{lastReceived: time.Now()}
+}
+
+func (l *Liveness) Received() {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.lastReceived = time.Now()
+}
+
+// Pong records the answer to a ping sent at sentAt (unix nanoseconds)
+func (l *Liveness) Pong(sentAt int64) {
+	now := time.Now()
+	rtt := now.Sub(time.Unix(0, sentAt))
+	if rtt < 0 {
+		return
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if l.rtt > 0 {
+		diff := rtt - l.rtt
+		if diff < 0 {
+			diff = -diff
+		}
+
+		l.jitter += (diff - l.jitter) / 16
+	}
+
+	l.rtt = rtt
+	l.history = append(l.history, RTTSample{At: now.Unix(), RTT: float64(rtt.Microseconds()) / 1000})
+	if len(l.history) > rttHistorySize {
+		l.history = l.history[len(l.history)-rttHistorySize:]
+	}
+}
+
+// Dead reports whether the peer missed MissedHeartbeats intervals
+func (l *Liveness) Dead() bool {
+	return l.State() == Dead
+}
+
+func (l *Liveness) State() LivenessState {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	silent := time.Since(l.lastReceived)
+	switch {
+	case silent > MissedHeartbeats*HeartbeatInterval:
+		return Dead
+	case silent > degradedAfter:
+		return Degraded
+	}
+
+	return Healthy
+}
+
+func (l *Liveness) Stats() LivenessStats {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return LivenessStats{
+		LastReceived: l.lastReceived,
+		RTT:          l.rtt,
+		Jitter:       l.jitter,
+		History:      append([]RTTSample(nil), l.history...),
+	}
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// variantSettings maps a GOARCH to the build setting that selects its
+// microarchitecture level (e.g. GOAMD64=v3 or GOARM=7)
+var variantSettings = map[string]string{
+	"amd64":   "GOAMD64",
+	"arm":     "GOARM",
+	"arm64":   "GOARM64",
+	"386":     "GO386",
+	"riscv64": "GORISCV64",
+	"ppc64":   "GOPPC64",
+	"ppc64le": "GOPPC64",
+	"mips":    "GOMIPS",
+	"mipsle":  "GOMIPS",
+}
+
+// Platform builds the release registry key for a node build,
+// e.g. "linux/amd64", "linux/amd64/v3" or "freebsd/arm/v7"
+func Platform(goos, goarch, variant string) string {
+	if variant == "" {
+		return goos + "/" + goarch
+	}
+
+	return goos + "/" + goarch + "/" + variant
+}
+
+// ParsePlatform splits and validates a platform string as built by Platform.
+// Only lowercase letters, digits and dots are accepted so the result is safe
+// to use in file names.
+func ParsePlatform(platform string) (goos, goarch, variant string, ok bool) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", "", "", false
+	}
+
+	for _, part := range parts {
+		if !isPlatformPart(part) {
+			return "", "", "", false
+		}
+	}
+
+	if len(parts) == 3 {
+		variant = parts[2]
+	}
+
+	return parts[0], parts[1], variant, true
+}
+
+// BasePlatform strips the variant from a platform string
+func BasePlatform(platform string) string {
+	goos, goarch, _, ok := ParsePlatform(platform)
+	if !ok {
+		return platform
+	}
+
+	return Platform(goos, goarch, "")
+}
+
+// PlatformFileName returns the file name a release for the given platform
+// is stored under, e.g. "wirednode-linux-amd64-v3"
+func PlatformFileName(platform string) string {
+	return "wirednode-" + strings.ReplaceAll(platform, "/", "-")
+}
+
+// CurrentPlatform returns the platform the running binary was built for
+func CurrentPlatform() string {
+	return Platform(runtime.GOOS, runtime.GOARCH, BuildVariant())
+}
+
+// BuildVariant returns the microarchitecture level the running binary was
+// built with, or "" if the architecture has none. The toolchain records its
+// default level too, so a plain amd64 build reports "v1".
+func BuildVariant() string {
+	setting, ok := variantSettings[runtime.GOARCH]
+	if !ok {
+		return ""
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, s := range info.Settings {
+		if s.Key != setting {
+			continue
+		}
+
+		// GOARM may carry a float ABI suffix ("7,softfloat")
+		value := strings.ToLower(strings.Split(s.Value, ",")[0])
+		if value != "" && setting == "GOARM" {
+			value = "v" + value
+		}
+
+		if !isPlatformPart(value) {
+			return ""
+		}
+
+		return value
+	}
+
+	return ""
+}
+
+func isPlatformPart(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -6,40 +6,152 @@ import (
 	"wired.rip/wiredutils/protocol"
 )
 
+// a player is online once per proxy domain, the same account may play
+// through several routes at a time
+type playerKey struct {
+	uuid      string
+	proxyUsed string
+}
+
 var (
-	PlayersArray = make([]protocol.Player, 0)
-	PlayersMux   = &sync.Mutex{}
+	players       = make(map[playerKey]protocol.Player)
+	playersByNode = make(map[string]map[playerKey]struct{})
+	PlayersMux    = &sync.Mutex{}
 )
 
 func AddPlayer(player protocol.Player) {
 	PlayersMux.Lock()
 	defer PlayersMux.Unlock()
 
-	PlayersArray = append(PlayersArray, player)
+	key := playerKey{player.UUID, player.ProxyUsed}
+	if previous, ok := players[key]; ok {
+		unindexPlayer(key, previous.NodeId)
+	}
+
+	players[key] = player
+	if playersByNode[player.NodeId] == nil {
+		playersByNode[player.NodeId] = make(map[playerKey]struct{})
+	}
+
+	playersByNode[player.NodeId][key] = struct{}{}
+}
+
+// RemovePlayer removes the session of player and reports whether it was
+// known. A session that replaced it, like a quick rejoin seen before the
+// leave, is kept.
+func RemovePlayer(player protocol.Player) bool {
+	PlayersMux.Lock()
+	defer PlayersMux.Unlock()
+
+	key := playerKey{player.UUID, player.ProxyUsed}
+	current, ok := players[key]
+	if !ok || current.NodeId != player.NodeId || current.JoinedAt != player.JoinedAt {
+		return false
+	}
+
+	delete(players, key)
+	unindexPlayer(key, current.NodeId)
+	return true
 }
 
-func RemovePlayer(player protocol.Player) {
+// ReplaceNodePlayers makes snapshot the players of a node. It returns the
+// sessions that were not known yet and the known ones that are gone.
+func ReplaceNodePlayers(nodeId string, snapshot []protocol.Player) ([]protocol.Player, []protocol.Player) {
 	PlayersMux.Lock()
 	defer PlayersMux.Unlock()
 
-	for i, p := range PlayersArray {
-		p.Conn = nil
-		if p == player {
-			PlayersArray = append(PlayersArray[:i], PlayersArray[i+1:]...)
-			break
+	var joined, left []protocol.Player
+	current := make(map[playerKey]struct{}, len(snapshot))
+	for _, player := range snapshot {
+		player.NodeId = nodeId
+		key := playerKey{player.UUID, player.ProxyUsed}
+		current[key] = struct{}{}
+
+		previous, ok := players[key]
+		if ok && previous.NodeId == nodeId && previous.JoinedAt == player.JoinedAt {
+			continue
+		}
+
+		if ok {
+			// the player rejoined since the master last heard of them
+			if previous.NodeId == nodeId {
+				left = append(left, previous)
+			}
+
+			unindexPlayer(key, previous.NodeId)
+		}
+
+		players[key] = player
+		if playersByNode[nodeId] == nil {
+			playersByNode[nodeId] = make(map[playerKey]struct{})
+		}
+
+		playersByNode[nodeId][key] = struct{}{}
+		joined = append(joined, player)
+	}
+
+	for key := range playersByNode[nodeId] {
+		if _, ok := current[key]; !ok {
+			left = append(left, players[key])
+			delete(players, key)
+			unindexPlayer(key, nodeId)
 		}
 	}
+
+	return joined, left
+}
+
+// RemoveNodePlayers forgets every player of a node and returns them
+func RemoveNodePlayers(nodeId string) []protocol.Player {
+	PlayersMux.Lock()
+	defer PlayersMux.Unlock()
+
+	removed := make([]protocol.Player, 0, len(playersByNode[nodeId]))
+	for key := range playersByNode[nodeId] {
+		removed = append(removed, players[key])
+		delete(players, key)
+	}
+
+	delete(playersByNode, nodeId)
+	return removed
+}
+
+func unindexPlayer(key playerKey, nodeId string) {
+	delete(playersByNode[nodeId], key)
+	if len(playersByNode[nodeId]) == 0 {
+		delete(playersByNode, nodeId)
+	}
 }
 
 func FindPlayer(uuid string, proxyUsed string) protocol.Player {
 	PlayersMux.Lock()
 	defer PlayersMux.Unlock()
 
-	for _, player := range PlayersArray {
-		if player.UUID == uuid && player.ProxyUsed == proxyUsed {
-			return player
-		}
+	return players[playerKey{uuid, proxyUsed}]
+}
+
+// ListPlayers returns every online player
+func ListPlayers() []protocol.Player {
+	PlayersMux.Lock()
+	defer PlayersMux.Unlock()
+
+	list := make([]protocol.Player, 0, len(players))
+	for _, player := range players {
+		list = append(list, player)
+	}
+
+	return list
+}
+
+// ListNodePlayers returns the players connected through a node
+func ListNodePlayers(nodeId string) []protocol.Player {
+	PlayersMux.Lock()
+	defer PlayersMux.Unlock()
+
+	list := make([]protocol.Player, 0, len(playersByNode[nodeId]))
+	for key := range playersByNode[nodeId] {
+		list = append(list, players[key])
 	}
 
-	return protocol.Player{}
+	return list
 }
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"wired.rip/wiredutils/packet"
+)
+
+// an hour of reports at the interval nodes send them
+const telemetryHistorySize = 120
+
+type TelemetrySample struct {
+	At time.Time
+	packet.Telemetry
+}
+
+// reports are kept after a node disconnects, they show how it was doing
+var (
+	telemetry    = make(map[string][]TelemetrySample) // by node id
+	telemetryMux = &sync.Mutex{}
+)
+
+func RecordTelemetry(nodeId string, report packet.Telemetry) {
+	telemetryMux.Lock()
+	defer telemetryMux.Unlock()
+
+	samples := append(telemetry[nodeId], TelemetrySample{At: time.Now(), Telemetry: report})
+	if len(samples) > telemetryHistorySize {
+		samples = samples[len(samples)-telemetryHistorySize:]
+	}
+
+	telemetry[nodeId] = samples
+}
+
+// GetTelemetry returns the recent reports of a node, oldest first
+func GetTelemetry(nodeId string) []TelemetrySample {
+	telemetryMux.Lock()
+	defer telemetryMux.Unlock()
+
+	return append([]TelemetrySample(nil), telemetry[nodeId]...)
+}
+
+func RemoveTelemetry(nodeId string) {
+	telemetryMux.Lock()
+	defer telemetryMux.Unlock()
+
+	delete(telemetry, nodeId)
+}
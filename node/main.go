@@ -11,7 +11,8 @@ import (
 	"wirednode/node"
 
 	"wired.rip/wiredutils/config"
-	"wired.rip/wiredutils/terminal"
+	"wired.rip/wiredutils/logging"
+	"wired.rip/wiredutils/utils"
 )
 
 //go:embed wirednode.service
@@ -19,9 +20,7 @@ var wiredService string
 
 func main() {
 	config.Init()
-	log.SetFlags(0)
-	prefix := fmt.Sprintf("%s.%s » ", config.GetSystemKey(), config.GetWiredHost())
-	log.SetPrefix(terminal.PrefixColor + prefix + terminal.Reset)
+	logging.Setup(fmt.Sprintf("%s.%s » ", config.GetSystemKey(), config.GetWiredHost()), config.GetLogging().Level)
 
 	if len(os.Args) > 1 {
 		args := os.Args[1:]
@@ -33,7 +32,7 @@ func main() {
 		case "setup":
 			setup(args[1:])
 		case "debug":
-			log.Println(runtime.GOARCH)
+			log.Println(utils.CurrentPlatform())
 		}
 	} else {
 		node.Run(getFileHash())
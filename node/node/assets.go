@@ -0,0 +1,121 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"wired.rip/wiredutils/packet"
+	"wired.rip/wiredutils/utils"
+)
+
+// managed assets are only ever written inside this directory
+const assetDir = "assets"
+
+func writeAsset(name string, data *[][]byte) error {
+	if !utils.ValidAssetName(name) {
+		return errors.New("invalid asset name " + name)
+	}
+
+	err := os.MkdirAll(assetDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so readers never see a partial asset
+	tmp, err := os.CreateTemp(assetDir, ".tmp-"+name+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	for _, chunk := range *data {
+		_, err = tmp.Write(chunk)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(assetDir, name))
+}
+
+func removeAsset(name string) error {
+	if !utils.ValidAssetName(name) {
+		return errors.New("invalid asset name " + name)
+	}
+
+	err := os.Remove(filepath.Join(assetDir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// assetReport lists the assets on disk together with their checksums
+func assetReport() packet.AssetReport {
+	report := packet.AssetReport{
+		Assets: []packet.AssetState{},
+	}
+
+	entries, err := os.ReadDir(assetDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Error reading asset directory", "error", err)
+		}
+
+		return report
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !utils.ValidAssetName(entry.Name()) {
+			continue
+		}
+
+		hash, err := hashFile(filepath.Join(assetDir, entry.Name()))
+		if err != nil {
+			slog.Error("Error hashing asset", "error", err)
+			continue
+		}
+
+		report.Assets = append(report.Assets, packet.AssetState{
+			Name: entry.Name(),
+			Hash: hash,
+		})
+	}
+
+	return report
+}
+
+func sendAssetReport() {
+	err := sendToMaster(packet.Id_AssetReport, assetReport())
+	if err != nil {
+		slog.Error("Error sending asset report", "error", err)
+	}
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
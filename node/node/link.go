@@ -0,0 +1,175 @@
+package node
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"wired.rip/wiredutils/config"
+	prtcl "wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/utils"
+)
+
+type linkState int
+
+const (
+	linkConnecting linkState = iota
+	linkConnected
+	linkDisconnected
+)
+
+func (s linkState) String() string {
+	switch s {
+	case linkConnecting:
+		return "connecting"
+	case linkConnected:
+		return "connected"
+	default:
+		return "disconnected"
+	}
+}
+
+const (
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 2 * time.Minute
+
+	// a connection that lasted this long resets the backoff
+	stableLinkDuration = time.Minute
+)
+
+var errMasterOffline = errors.New("not connected to master")
+
+// state of the master connection, guarded by linkMux
+var (
+	linkMux             = &sync.RWMutex{}
+	master              *prtcl.Conn
+	currentLinkState    = linkConnecting
+	linkStateChangedAt  = time.Now()
+	linkConnects        int64
+	linkConnectFailures int64
+	masterLiveness      *utils.Liveness
+)
+
+type linkStats struct {
+	State     linkState
+	ChangedAt time.Time
+	Connects  int64
+	Failures  int64
+	RTT       time.Duration
+	Jitter    time.Duration
+}
+
+func getLinkStats() linkStats {
+	linkMux.RLock()
+	defer linkMux.RUnlock()
+
+	stats := linkStats{
+		State:     currentLinkState,
+		ChangedAt: linkStateChangedAt,
+		Connects:  linkConnects,
+		Failures:  linkConnectFailures,
+	}
+
+	if masterLiveness != nil {
+		heartbeats := masterLiveness.Stats()
+		stats.RTT, stats.Jitter = heartbeats.RTT, heartbeats.Jitter
+	}
+
+	return stats
+}
+
+// setMasterLiveness tracks the heartbeats of a new master connection
+func setMasterLiveness(liveness *utils.Liveness) {
+	linkMux.Lock()
+	defer linkMux.Unlock()
+
+	masterLiveness = liveness
+}
+
+func setLinkState(state linkState, conn *prtcl.Conn, reason error) {
+	linkMux.Lock()
+	defer linkMux.Unlock()
+
+	master = conn
+	if state == linkConnected {
+		linkConnects++
+	}
+
+	if state == currentLinkState {
+		return
+	}
+
+	if reason != nil {
+		slog.Info("Master link changed", "from", currentLinkState.String(), "to", state.String(), "reason", reason)
+	} else {
+		slog.Info("Master link changed", "from", currentLinkState.String(), "to", state.String())
+	}
+
+	currentLinkState = state
+	linkStateChangedAt = time.Now()
+}
+
+func currentMaster() *prtcl.Conn {
+	linkMux.RLock()
+	defer linkMux.RUnlock()
+
+	if currentLinkState != linkConnected {
+		return nil
+	}
+
+	return master
+}
+
+// sendToMaster sends a packet over the current master connection,
+// proxying keeps working without one so callers only log the error
+func sendToMaster(id prtcl.VarInt, packet any) error {
+	conn := currentMaster()
+	if conn == nil {
+		return errMasterOffline
+	}
+
+	return conn.SendPacket(id, packet)
+}
+
+// maintainMasterLink keeps (re)connecting to the master with jittered
+// exponential backoff until the process starts draining
+func maintainMasterLink() {
+	delay := minReconnectDelay
+	for !draining.Load() {
+		conn, err := dialMaster()
+		if err == nil {
+			connectedAt := time.Now()
+			err = handleMasterConnection(conn)
+			conn.Close()
+
+			if draining.Load() {
+				return
+			}
+
+			// a link that was up for a while reconnects right away
+			if time.Since(connectedAt) >= stableLinkDuration {
+				setLinkState(linkDisconnected, nil, err)
+				delay = minReconnectDelay
+				continue
+			}
+		} else {
+			linkMux.Lock()
+			linkConnectFailures++
+			linkMux.Unlock()
+		}
+
+		setLinkState(linkDisconnected, nil, err)
+
+		// jitter: sleep somewhere in [delay/2, delay)
+		sleep := delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+		slog.Info("Reconnecting to master", "host", "master."+config.GetWiredHost(), "in", sleep.Round(time.Millisecond), "error", err)
+		time.Sleep(sleep)
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package node
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/logging"
+	"wired.rip/wiredutils/packet"
+	prtcl "wired.rip/wiredutils/protocol"
+)
+
+const (
+	forwardQueueSize = 1024 // entries kept while the master is away
+	forwardBatchSize = 100
+	forwardInterval  = time.Second
+)
+
+var (
+	forwardQueue   = make(chan logging.Entry, forwardQueueSize)
+	forwardDropped atomic.Uint64
+	forwarding     bool
+)
+
+// startLogForwarding queues the log of the node for its master when the
+// config asks for it
+func startLogForwarding() {
+	settings := config.GetLogging()
+	if !settings.Forward {
+		return
+	}
+
+	minLevel, err := logging.ParseLevel(settings.ForwardLevel)
+	if err != nil {
+		slog.Warn("Unknown forward level, forwarding from info", "level", settings.ForwardLevel)
+	}
+
+	forwarding = true
+	logging.AddSink(func(entry logging.Entry) {
+		if entry.Level < minLevel {
+			return
+		}
+
+		select {
+		case forwardQueue <- entry:
+		default:
+			forwardDropped.Add(1)
+		}
+	})
+
+	slog.Info("Forwarding logs to the master", "level", logging.LevelName(minLevel))
+}
+
+// forwardLogs sends queued entries to the master in batches until done.
+// Entries that can not be sent are counted as dropped, logging the failure
+// would only queue more.
+func forwardLogs(conn *prtcl.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(forwardInterval)
+	defer ticker.Stop()
+
+	batch := make([]logging.Entry, 0, forwardBatchSize)
+	flush := func() {
+		dropped := forwardDropped.Swap(0)
+		if len(batch) == 0 && dropped == 0 {
+			return
+		}
+
+		// masters before log forwarding ignore the packet
+		err := conn.SendPacket(packet.Id_Logs, packet.Logs{Entries: batch, Dropped: dropped})
+		if err != nil {
+			forwardDropped.Add(dropped + uint64(len(batch)))
+		}
+
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-done:
+			forwardDropped.Add(uint64(len(batch)))
+			return
+		case entry := <-forwardQueue:
+			batch = append(batch, entry)
+			if len(batch) >= forwardBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package node
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// metricsAddress only listens locally, scrape it from the host or through
+// a reverse proxy
+const metricsAddress = "127.0.0.1:37422"
+
+var metricsServer atomic.Pointer[http.Server]
+
+// startMetricsServer serves node metrics in the Prometheus text format.
+// During a graceful restart the previous process still holds the port, so
+// binding is retried until it is released.
+func startMetricsServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	for !draining.Load() {
+		listener, err := net.Listen("tcp", metricsAddress)
+		if err != nil {
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		server := &http.Server{Handler: mux}
+		metricsServer.Store(server)
+
+		// a restart that began while binding already stopped the server
+		if draining.Load() {
+			listener.Close()
+			return
+		}
+
+		slog.Info("Metrics server listening", "address", metricsAddress)
+		err = server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("Metrics server stopped", "error", err)
+		}
+
+		return
+	}
+}
+
+func stopMetricsServer() {
+	if server := metricsServer.Load(); server != nil {
+		server.Close()
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	stats := getLinkStats()
+	up := 0
+	if stats.State == linkConnected {
+		up = 1
+	}
+
+	fmt.Fprintln(w, "# HELP wired_master_link_up Whether the node is connected to the master.")
+	fmt.Fprintln(w, "# TYPE wired_master_link_up gauge")
+	fmt.Fprintf(w, "wired_master_link_up %d\n", up)
+
+	fmt.Fprintln(w, "# HELP wired_master_link_state Current state of the master link.")
+	fmt.Fprintln(w, "# TYPE wired_master_link_state gauge")
+	for _, state := range []linkState{linkConnecting, linkConnected, linkDisconnected} {
+		value := 0
+		if stats.State == state {
+			value = 1
+		}
+
+		fmt.Fprintf(w, "wired_master_link_state{state=%q} %d\n", state.String(), value)
+	}
+
+	fmt.Fprintln(w, "# HELP wired_master_link_state_changed_timestamp_seconds Time of the last link state change.")
+	fmt.Fprintln(w, "# TYPE wired_master_link_state_changed_timestamp_seconds gauge")
+	fmt.Fprintf(w, "wired_master_link_state_changed_timestamp_seconds %d\n", stats.ChangedAt.Unix())
+
+	fmt.Fprintln(w, "# HELP wired_master_link_connects_total Successful connections to the master.")
+	fmt.Fprintln(w, "# TYPE wired_master_link_connects_total counter")
+	fmt.Fprintf(w, "wired_master_link_connects_total %d\n", stats.Connects)
+
+	fmt.Fprintln(w, "# HELP wired_master_link_failures_total Failed connection attempts to the master.")
+	fmt.Fprintln(w, "# TYPE wired_master_link_failures_total counter")
+	fmt.Fprintf(w, "wired_master_link_failures_total %d\n", stats.Failures)
+
+	fmt.Fprintln(w, "# HELP wired_master_rtt_seconds Round trip time of the latest heartbeat.")
+	fmt.Fprintln(w, "# TYPE wired_master_rtt_seconds gauge")
+	fmt.Fprintf(w, "wired_master_rtt_seconds %g\n", stats.RTT.Seconds())
+
+	fmt.Fprintln(w, "# HELP wired_master_rtt_jitter_seconds Smoothed variation of the heartbeat round trip time.")
+	fmt.Fprintln(w, "# TYPE wired_master_rtt_jitter_seconds gauge")
+	fmt.Fprintf(w, "wired_master_rtt_jitter_seconds %g\n", stats.Jitter.Seconds())
+
+	fmt.Fprintln(w, "# HELP wired_proxy_sessions Currently proxied connections.")
+	fmt.Fprintln(w, "# TYPE wired_proxy_sessions gauge")
+	fmt.Fprintf(w, "wired_proxy_sessions %d\n", activeSessions())
+}
@@ -9,14 +9,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 	"wirednode/protocol"
 
@@ -30,128 +30,229 @@ import (
 var (
 	nodeHash       string
 	wiredPub       *rsa.PublicKey
-	master         *prtcl.Conn
-	failedAttempts = 0
-	binaryDataMux  = &sync.Mutex{}
-	binaryData     = make(map[string]*[][]byte)
+	binaryDataMux = &sync.Mutex{}
+	binaryData    = make(map[string]*[][]byte)
+
+	playerReportMux = &sync.Mutex{}
 )
 
 func Run(detectedHash string) {
 	nodeHash = detectedHash
 
-	config.SetCurrentNodeHash(nodeHash, runtime.GOARCH)
-	log.Printf("Trying to connect to master.%s...\n", config.GetWiredHost())
+	config.SetCurrentNodeHash(nodeHash, utils.CurrentPlatform())
+	slog.Info("Trying to connect to the master", "master", "master."+config.GetWiredHost())
+
+	loadRoutesSnapshot()
+	inheritPlayers()
+	startLogForwarding()
 
+	go handleRestartSignals()
+	go startMetricsServer()
 	connectToMaster()
+
+	if draining.Load() {
+		drain()
+		os.Exit(0)
+	}
 }
 
+// connectToMaster serves the proxy right away and keeps the master
+// connection up in the background, routes are updated once it is
 func connectToMaster() {
-	loadPublicKey()
-	go handleMasterConnection()
+	go maintainMasterLink()
 	startProxyServer()
 }
 
-func dialMaster() {
-	var c net.Conn
-	var err error
-
-	remoteAddr, err := resolver.ResolveWired(config.GetWiredHost())
+// dialMaster connects to the first master that answers, trying every
+// SRV target of the wired host in priority order
+func dialMaster() (*prtcl.Conn, error) {
+	pub, err := requestPublicKey()
 	if err != nil {
-		panic("Error resolving wired addr:" + err.Error()) // expected to recover
+		// all masters share one key pair, so the cached key stays valid
+		// while master.<host> points at a master that is down
+		cached, cacheErr := loadCachedPublicKey()
+		if cacheErr != nil {
+			return nil, fmt.Errorf("requesting public key: %w", err)
+		}
+
+		slog.Error("Error requesting public key, using cached key", "error", err)
+		pub = cached
+	} else {
+		cachePublicKey(pub)
 	}
 
-	for {
-		c, err = net.Dial("tcp", remoteAddr.String())
-		// c, err = net.Dial("tcp", "127.0.0.1:37420")
-		if err == nil {
-			failedAttempts = 0
-			break
-		}
+	wiredPub = pub
 
-		failedAttempts++
-		if failedAttempts < 10 {
-			log.Println(err)
-			log.Printf("Failed to connect to master.%s, retrying in 5 seconds...\n", config.GetWiredHost())
-		} else {
-			log.Fatalf("failed to connect to master.%s after 10 attempts, exiting...\n", config.GetWiredHost())
+	remoteAddrs, err := resolver.ResolveWiredAll(config.GetWiredHost())
+	if err != nil {
+		return nil, fmt.Errorf("resolving wired addr: %w", err)
+	}
+
+	for _, remoteAddr := range remoteAddrs {
+		c, dialErr := net.DialTimeout("tcp", remoteAddr.String(), 10*time.Second)
+		// c, dialErr := net.Dial("tcp", "127.0.0.1:37420")
+		if dialErr != nil {
+			slog.Warn("Failed to connect to master", "address", remoteAddr.String(), "error", dialErr)
+			err = dialErr
+			continue
 		}
 
-		time.Sleep(5 * time.Second)
+		slog.Info("Connected to master", "address", remoteAddr.String())
+		return prtcl.NewConn(c, nil, wiredPub), nil
 	}
 
-	master = prtcl.NewConn(c, nil, wiredPub)
+	return nil, err
 }
 
-func handleMasterConnection() {
+// handleMasterConnection runs the protocol on an established connection
+// and returns once it breaks
+func handleMasterConnection(conn *prtcl.Conn) error {
 	sharedSecret := []byte(utils.GenerateString(16))
-	err := master.SendPacket(packet.Id_SharedSecret, sharedSecret)
+	err := conn.SendPacket(packet.Id_SharedSecret, sharedSecret)
+	if err != nil {
+		return fmt.Errorf("sending shared secret: %w", err)
+	}
+
+	err = conn.EnableEncryption(sharedSecret)
 	if err != nil {
-		log.Fatalf("error sending shared secret: %s\n", err)
+		return fmt.Errorf("enabling encryption: %w", err)
 	}
 
-	master.EnableEncryption(sharedSecret)
-	log.Println("Secure connection established")
+	slog.Info("Secure connection established")
 
-	master.SendPacket(packet.Id_Hello, packet.Hello{
+	err = conn.SendPacket(packet.Id_Hello, packet.Hello{
 		Key:        config.GetSystemKey(),
 		Version:    "1.0.0",
 		Passphrase: config.GetPassphrase(),
 		Arch:       runtime.GOARCH,
+		OS:         runtime.GOOS,
+		Variant:    utils.BuildVariant(),
 		Hash:       []byte(nodeHash),
+
+		RouteDeltas: true,
 	})
+	if err != nil {
+		return fmt.Errorf("sending hello: %w", err)
+	}
+
+	setLinkState(linkConnected, conn, nil)
+	sendAssetReport()
+	sendPlayerSnapshot()
+
+	liveness := utils.NewLiveness()
+	setMasterLiveness(liveness)
+
+	done := make(chan struct{})
+	defer close(done)
 
 	go func() {
+		ticker := time.NewTicker(utils.HeartbeatInterval)
+		defer ticker.Stop()
+
 		for {
-			err := master.SendPacket(packet.Id_Ping, nil)
-			if err != nil {
-				log.Println("Error sending ping:", err)
-			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// a half-open connection never fails a read, closing it
+				// ends the read loop and reconnects
+				if liveness.Dead() {
+					slog.Warn("Master missed heartbeats, closing the connection", "missed", utils.MissedHeartbeats)
+					conn.Close()
+					return
+				}
 
-			time.Sleep(10 * time.Second)
+				err := conn.SendPacket(packet.Id_Ping, packet.Heartbeat{SentAt: time.Now().UnixNano()})
+				if err != nil {
+					slog.Error("Error sending ping", "error", err)
+				}
+			}
 		}
 	}()
 
+	go reportTelemetry(conn, done)
+	if forwarding {
+		go forwardLogs(conn, done)
+	}
+
 	for {
 		var pp prtcl.Packet
-		err := pp.Read(master)
+		err := pp.Read(conn)
 		if err != nil {
-			if errors.Is(err, io.EOF) || strings.Contains(err.Error(), "failed to get reader: use of closed network connection") {
-				log.Println("Master connection closed")
-
-				ok := loadPublicKey()
-				if ok {
-					go handleMasterConnection()
-				}
+			// the cipher stream can not recover from a broken read
+			if errors.Is(err, io.EOF) {
+				return errors.New("master connection closed")
+			}
 
-				return
+			if liveness.Dead() {
+				return errors.New("master stopped answering heartbeats")
 			}
 
-			// log.Println("Error reading packet:", err)
-			continue
+			return err
 		}
 
+		liveness.Received()
+
 		switch pp.ID {
 		case packet.Id_Ready:
-			log.Printf("Received ready packet at %s\n", time.Now().Format("15:04:05"))
+			slog.Debug("Received ready packet")
+		case packet.Id_Ping:
+			err := conn.SendPacket(packet.Id_Pong, packet.PongFor(pp.Data))
+			if err != nil {
+				slog.Error("Error sending pong", "error", err)
+			}
 		case packet.Id_Pong:
-			// log.Printf("Received pong packet at %s\n", time.Now())
+			var hb packet.Heartbeat
+			if len(pp.Data) > 0 && prtcl.DecodePacket(pp.Data, &hb) == nil {
+				liveness.Pong(hb.SentAt)
+			}
 		case packet.Id_Routes:
 			// log.Printf("Received routes packet at %s\n", time.Now())
 
 			var routes packet.Routes
-			prtcl.DecodePacket(pp.Data, &routes)
+			err := prtcl.DecodePacket(pp.Data, &routes)
+			if err != nil {
+				slog.Error("Error decoding routes packet", "error", err)
+				continue
+			}
+
+			err = applyRoutes(routes)
+			if err != nil {
+				slog.Warn("Rejected routes packet", "error", err)
+				continue
+			}
 
 			for _, route := range routes.Routes {
-				log.Printf("Received route: %s:%s pointing to %s:%s (%s)\n", route.ProxyDomain, route.ProxyPort, route.ServerHost, route.ServerPort, route.RouteId)
+				slog.Info("Received route", "route_id", route.RouteId, "proxy_domain", route.ProxyDomain+":"+route.ProxyPort, "server", route.ServerHost+":"+route.ServerPort)
 			}
+		case packet.Id_RoutesDelta:
+			var delta packet.RoutesDelta
+			err := prtcl.DecodePacket(pp.Data, &delta)
+			if err != nil {
+				slog.Error("Error decoding routes delta packet", "error", err)
+				continue
+			}
+
+			err = applyRoutesDelta(delta)
+			if err != nil {
+				// a missed or broken delta is repaired by fetching all routes
+				slog.Warn("Rejected routes delta, requesting all routes", "error", err)
 
-			config.SetRoutes(routes.Routes)
+				err = conn.SendPacket(packet.Id_RoutesResync, packet.RoutesResync{Generation: routesGeneration()})
+				if err != nil {
+					slog.Error("Error sending routes resync packet", "error", err)
+				}
+
+				continue
+			}
+
+			slog.Info("Applied routes delta", "changed", len(delta.Upsert), "removed", len(delta.Remove), "generation", delta.Generation)
 		case packet.Id_BinaryData:
-			log.Printf("Received binary data packet at %s\n", time.Now().Format("15:04:05"))
+			slog.Debug("Received binary data packet")
 			var bd prtcl.BinaryData
 			err := prtcl.DecodePacket(pp.Data, &bd)
 			if err != nil {
-				log.Println("Error decoding binary data:", err)
+				slog.Error("Error decoding binary data", "error", err)
 				continue
 			}
 
@@ -166,11 +267,11 @@ func handleMasterConnection() {
 			*binaryData[bd.Label] = append(*data, bd.Data)
 			binaryDataMux.Unlock()
 		case packet.Id_BinaryEnd:
-			log.Printf("Received binary end packet at %s\n", time.Now().Format("15:04:05"))
+			slog.Debug("Received binary end packet")
 			var bd prtcl.BinaryData
 			err := prtcl.DecodePacket(pp.Data, &bd)
 			if err != nil {
-				log.Println("Error decoding binary data:", err)
+				slog.Error("Error decoding binary data", "error", err)
 				continue
 			}
 
@@ -180,7 +281,7 @@ func handleMasterConnection() {
 
 				data, ok := binaryData[bd.Label]
 				if !ok {
-					log.Println("Error label is not available:", bd.Label)
+					slog.Error("Label of binary data is not available", "label", bd.Label)
 					return
 				}
 				defer delete(binaryData, bd.Label)
@@ -188,15 +289,28 @@ func handleMasterConnection() {
 				if bd.Label == "upgrade" {
 					err = upgrade(data)
 					if err != nil {
-						log.Println("Error upgrading binary:", err)
+						slog.Error("Error upgrading binary", "error", err)
+					}
+
+					return
+				}
+
+				if strings.HasPrefix(bd.Label, packet.AssetLabelPrefix) {
+					name := strings.TrimPrefix(bd.Label, packet.AssetLabelPrefix)
+					err = writeAsset(name, data)
+					if err != nil {
+						slog.Error("Error writing asset", "asset", name, "error", err)
+					} else {
+						slog.Info("Updated asset", "asset", name)
 					}
 
+					go sendAssetReport()
 					return
 				}
 
 				file, err := os.Create("BD_" + bd.Label)
 				if err != nil {
-					log.Println("Error creating file:", err)
+					slog.Error("Error creating file", "error", err)
 					return
 				}
 				defer file.Close()
@@ -205,30 +319,45 @@ func handleMasterConnection() {
 					file.Write(data)
 				}
 
-				log.Printf("Wrote binary data to %s\n", file.Name())
+				slog.Info("Wrote binary data", "file", file.Name())
 			}()
+		case packet.Id_AssetRemove:
+			var remove packet.AssetRemove
+			err := prtcl.DecodePacket(pp.Data, &remove)
+			if err != nil {
+				slog.Error("Error decoding asset remove packet", "error", err)
+				continue
+			}
+
+			err = removeAsset(remove.Name)
+			if err != nil {
+				slog.Error("Error removing asset", "asset", remove.Name, "error", err)
+			} else {
+				slog.Info("Removed asset", "asset", remove.Name)
+			}
+
+			sendAssetReport()
 		case packet.Id_DisconnectPlayer:
-			log.Printf("Received disconnect player packet at %s\n", time.Now().Format("15:04:05"))
+			slog.Debug("Received disconnect player packet")
 			var disconnect packet.Disconnect
 			err := prtcl.DecodePacket(pp.Data, &disconnect)
 			if err != nil {
-				log.Println("Error decoding disconnect packet:", err)
+				slog.Error("Error decoding disconnect packet", "error", err)
 				continue
 			}
 
 			player := utils.FindPlayer(disconnect.PlayerUUID, disconnect.ProxyHost)
 			if player.Name == "" {
-				log.Println("Received disconnect packet for unknown player")
+				slog.Warn("Received disconnect packet for unknown player", "uuid", disconnect.PlayerUUID, "proxy_domain", disconnect.ProxyHost)
 				continue
 			}
 
-			log.Println(player)
 			err = player.Conn.Close()
 			if err != nil {
-				log.Println("Error closing player connection:", err)
+				slog.Error("Error closing player connection", "player", player.Name, "error", err)
 			}
 
-			log.Printf("Disconnected player %s from %s\n", player.Name, player.PlayingOn)
+			slog.Info("Disconnected player", "player", player.Name, "uuid", player.UUID, "proxy_domain", player.ProxyUsed, "client_ip", player.ClientIP)
 		}
 	}
 }
@@ -236,8 +365,7 @@ func handleMasterConnection() {
 func upgrade(data *[][]byte) error {
 	exePath, err := os.Executable()
 	if err != nil {
-		log.Fatalf("Failed to get executable path: %s", err)
-		return err
+		return fmt.Errorf("getting executable path: %w", err)
 	}
 
 	fileInfo, err := os.Stat(exePath)
@@ -252,60 +380,28 @@ func upgrade(data *[][]byte) error {
 
 	file, err := os.OpenFile(exePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileInfo.Mode().Perm())
 	if err != nil {
-		log.Fatalf("Failed to create file: %s", err)
-		return err
+		return fmt.Errorf("creating binary: %w", err)
 	}
 	defer file.Close()
 
 	for _, slice := range *data {
 		_, err := file.Write(slice)
 		if err != nil {
-			log.Printf("Failed to write to file: %s\n", err)
+			slog.Error("Failed to write to file", "error", err)
 		}
 	}
 
-	log.Println("Replaced binary")
+	slog.Info("Replaced binary", "path", exePath)
 	file.Close()
 	err = restartSelf()
 	if err != nil {
-		log.Printf("Failed to restart self: %s", err)
+		slog.Error("Failed to restart self", "error", err)
 		return err
 	}
 
 	return nil
 }
 
-func restartSelf() error {
-	log.Println("Restarting ...")
-	self, err := os.Executable()
-	if err != nil {
-		log.Println("Error getting executable path:", err)
-		return err
-	}
-
-	args := os.Args
-	env := os.Environ()
-
-	return syscall.Exec(self, args, env)
-}
-
-func loadPublicKey() bool {
-	var err error
-	for {
-		wiredPub, err = requestPublicKey()
-		if err != nil {
-			log.Println("Error requesting public key:", err)
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		dialMaster()
-		break
-	}
-
-	return true
-}
-
 func requestPublicKey() (*rsa.PublicKey, error) {
 	req, err := http.NewRequest("GET", fmt.Sprintf("https://master.%s/api/connect/publickey", config.GetWiredHost()), nil)
 	// req, err := http.NewRequest("GET", "http://127.0.0.1:37421/api/connect/publickey", nil)
@@ -313,13 +409,18 @@ func requestPublicKey() (*rsa.PublicKey, error) {
 		return nil, err
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	res, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		return nil, err
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
 	}
 
 	body, err := io.ReadAll(res.Body)
@@ -334,25 +435,32 @@ func requestPublicKey() (*rsa.PublicKey, error) {
 func decodePEMToPublicKey(pemKey string) (*rsa.PublicKey, error) {
 	block, _ := pem.Decode([]byte(pemKey))
 	if block == nil || block.Type != "PUBLIC KEY" {
-		log.Fatalf("failed to decode PEM block containing public key")
+		return nil, errors.New("failed to decode PEM block containing public key")
 	}
 
 	return x509.ParsePKCS1PublicKey(block.Bytes)
 }
 
 func startProxyServer() {
-	listener, err := net.Listen("tcp", ":25565")
+	listener, err := listenProxy(":25565")
 	if err != nil {
-		log.Fatal("error starting minecraft proxy server:", err)
+		slog.Error("Error starting minecraft proxy server", "error", err)
+		os.Exit(1)
 	}
 	defer listener.Close()
 
-	log.Println("Minecraft proxy server listening on :25565")
+	setProxyListener(listener)
+	slog.Info("Minecraft proxy server listening", "address", ":25565")
+	signalReady()
 
 	for {
 		clientConn, err := listener.Accept()
 		if err != nil {
-			log.Println("error accepting connection:", err)
+			if draining.Load() {
+				return
+			}
+
+			slog.Error("Error accepting connection", "error", err)
 			return
 		}
 
@@ -361,10 +469,18 @@ func startProxyServer() {
 }
 
 func handleMinecraftConnection(clientConn net.Conn) {
+	logger := slog.With("client_ip", clientIP(clientConn))
+
+	endSession, ok := trackSession()
+	if !ok {
+		clientConn.Close()
+		return
+	}
+	defer endSession()
 	defer func() {
 		r := recover()
 		if r != nil {
-			log.Println("recovered from:", r)
+			logger.Error("Recovered from panic", "panic", r)
 		}
 
 		clientConn.Close()
@@ -373,7 +489,7 @@ func handleMinecraftConnection(clientConn net.Conn) {
 	var handshakePacket protocol.HandshakePacket
 	err := handshakePacket.ReadFrom(clientConn)
 	if err != nil {
-		log.Println("error reading handshake packet:", err)
+		logger.Debug("Error reading handshake packet", "error", err)
 		sendErrorScreen(clientConn, 2)
 		return
 	}
@@ -384,9 +500,11 @@ func handleMinecraftConnection(clientConn net.Conn) {
 		handshakePacket.Hostname = protocol.String(split[0])
 	}
 
-	route, ok := config.GetRouteByProxyDomain(string(handshakePacket.Hostname))
+	logger = logger.With("proxy_domain", string(handshakePacket.Hostname))
+
+	route, ok := getRoute(string(handshakePacket.Hostname))
 	if !ok {
-		log.Printf("Route not found for %s (Client IP: %s)\n", handshakePacket.Hostname, clientConn.RemoteAddr().String())
+		logger.Info("Route not found")
 		if handshakePacket.NextState == 1 {
 			sendErrorScreen(clientConn, 1)
 		} else {
@@ -396,12 +514,27 @@ func handleMinecraftConnection(clientConn net.Conn) {
 		return
 	}
 
+	logger = logger.With("route_id", route.RouteId)
+
+	if !route.AllowsProtocol(int(handshakePacket.Version)) {
+		logger.Info("Protocol version not allowed", "protocol_version", int(handshakePacket.Version))
+		if handshakePacket.NextState == 1 {
+			sendErrorScreen(clientConn, 3)
+		} else {
+			sendDisconnectScreen(clientConn, "§8[§7Wired§8] §cUnsupported Minecraft version")
+		}
+
+		return
+	}
+
 	originalHostname := string(handshakePacket.Hostname)
 	handshakePacket.Hostname = protocol.String(route.ServerHost)
 
-	serverConn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", route.ServerHost, route.ServerPort))
+	// a zero timeout leaves it to the operating system
+	dialer := net.Dialer{Timeout: time.Duration(route.ConnectTimeout) * time.Second}
+	serverConn, err := dialer.Dial("tcp", fmt.Sprintf("%s:%s", route.ServerHost, route.ServerPort))
 	if err != nil {
-		log.Println("error connecting to server:", err)
+		logger.Warn("Error connecting to server", "server", route.ServerHost+":"+route.ServerPort, "error", err)
 		if handshakePacket.NextState == 1 {
 			sendErrorScreen(clientConn, 0)
 		} else {
@@ -419,7 +552,7 @@ func handleMinecraftConnection(clientConn net.Conn) {
 	// Send handshake packet to server
 	err = handshakePacket.WriteTo(serverConn)
 	if err != nil {
-		log.Println("error writing handshake packet to server:", err)
+		logger.Warn("Error writing handshake packet to server", "error", err)
 		if handshakePacket.NextState == 1 {
 			sendErrorScreen(clientConn, 0)
 		} else {
@@ -429,41 +562,60 @@ func handleMinecraftConnection(clientConn net.Conn) {
 		return
 	}
 
+	// traffic of the player, reported to the master when they leave
+	var toServer, toClient atomic.Int64
+
 	if handshakePacket.NextState == 2 {
 		var loginPacket protocol.LoginPacket
 		err = loginPacket.ReadFrom(clientConn)
 		if err != nil {
-			log.Println("error reading login packet:", err)
+			logger.Debug("Error reading login packet", "error", err)
 			return
 		}
 
-		log.Printf("Player %s (%x) connected to %s:%s\n", loginPacket.Name, loginPacket.UUID, route.ServerHost, route.ServerPort)
+		logger = logger.With("player", string(loginPacket.Name), "uuid", fmt.Sprintf("%x", loginPacket.UUID))
+		logger.Info("Player connected", "server", route.ServerHost+":"+route.ServerPort, "protocol_version", int(handshakePacket.Version))
 
 		playingOn := fmt.Sprintf("%s:%s", route.ServerHost, route.ServerPort)
 		player := newPlayer(string(loginPacket.Name), fmt.Sprintf("%x", loginPacket.UUID), playingOn, originalHostname, int(handshakePacket.Version), clientConn)
 
 		addPlayer(player)
-		defer removePlayer(player)
+		defer func() {
+			player.BytesToServer = toServer.Load()
+			player.BytesToClient = toClient.Load()
+			removePlayer(player)
+		}()
 
 		err = loginPacket.WriteTo(serverConn)
 		if err != nil {
-			log.Println("error writing login packet to server:", err)
+			logger.Warn("Error writing login packet to server", "error", err)
 			return
 		}
 	}
 
+	idle := time.Duration(route.IdleTimeout) * time.Second
+
 	// C->S
-	go copyData(clientConn, serverConn)
+	go copyData(clientConn, serverConn, idle, &toServer)
 
 	// S->C
-	copyData(serverConn, clientConn)
+	copyData(serverConn, clientConn, idle, &toClient)
 }
 
-func copyData(src net.Conn, dst net.Conn) {
+// copyData copies src to dst and counts the bytes written. With an idle
+// timeout, traffic in either direction pushes the read deadline of both
+// connections.
+func copyData(src net.Conn, dst net.Conn, idle time.Duration, written *atomic.Int64) {
 	// copy and log data
 	buf := make([]byte, 4096)
 
 	for {
+		if idle > 0 {
+			deadline := time.Now().Add(idle)
+			src.SetReadDeadline(deadline)
+			dst.SetReadDeadline(deadline)
+		}
+
 		// src conn
 		n, err := src.Read(buf)
 		if err != nil {
@@ -471,7 +623,8 @@ func copyData(src net.Conn, dst net.Conn) {
 		}
 
 		// dst conn
-		_, err = dst.Write(buf[:n])
+		n, err = dst.Write(buf[:n])
+		written.Add(int64(n))
 		if err != nil {
 			return
 		}
@@ -493,6 +646,9 @@ func sendErrorScreen(clientConn net.Conn, errorType int) {
 	} else if errorType == 1 {
 		text += "Route not found"
 		versionName += "Not found"
+	} else if errorType == 3 {
+		text += "Unsupported Minecraft version"
+		versionName += "Unsupported"
 	} else {
 		text += "Network failure"
 		versionName += "Failure"
@@ -533,6 +689,16 @@ func sendDisconnectScreen(clientConn net.Conn, reason string) {
 	pp.Write(clientConn)
 }
 
+// clientIP returns the address of a connection without its port
+func clientIP(conn net.Conn) string {
+	ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+
+	return ip
+}
+
 func newPlayer(name string, uuid string, playingOn string, proxyUsed string, protocolVersion int, conn net.Conn) prtcl.Player {
 	return prtcl.Player{
 		Name:            name,
@@ -542,31 +708,64 @@ func newPlayer(name string, uuid string, playingOn string, proxyUsed string, pro
 		ProxyUsed:       proxyUsed,
 		ProtocolVersion: protocolVersion,
 		NodeId:          config.GetSystemKey(),
+		ClientIP:        clientIP(conn),
 		Conn:            conn,
 	}
 }
 
 func addPlayer(p prtcl.Player) {
+	// a snapshot listed before the add must not be sent after it
+	playerReportMux.Lock()
+	defer playerReportMux.Unlock()
+
 	utils.AddPlayer(p)
 	p.Conn = nil
 
-	err := master.SendPacket(packet.Id_PlayerAdd, p)
+	err := sendToMaster(packet.Id_PlayerAdd, p)
 	if err != nil {
-		log.Println("Error sending player add packet:", err)
+		slog.Error("Error sending player add packet", "error", err)
+	}
+}
+
+// sendPlayerSnapshot tells a master the node (re)connected to which
+// players are online, it replaces whatever the master remembers
+func sendPlayerSnapshot() {
+	<-inherited
+
+	playerReportMux.Lock()
+	defer playerReportMux.Unlock()
+
+	players := utils.ListPlayers()
+	for i := range players {
+		players[i].Conn = nil
+	}
+
+	err := sendToMaster(packet.Id_PlayerSnapshot, packet.PlayerSnapshot{Players: players})
+	if err != nil {
+		slog.Error("Error sending player snapshot", "error", err)
 	}
 }
 
 func removePlayer(p prtcl.Player) {
 	p.Conn = nil
-	utils.RemovePlayer(p)
+	removed, handedOff := dropPlayer(p)
+	if !removed {
+		return
+	}
+
+	logger := slog.With("player", p.Name, "uuid", p.UUID, "proxy_domain", p.ProxyUsed, "client_ip", p.ClientIP)
+	if handedOff {
+		logger.Info("Player disconnected", "server", p.PlayingOn)
+		return
+	}
 
 	go func() {
 		time.Sleep(500 * time.Millisecond)
-		err := master.SendPacket(packet.Id_PlayerRemove, p)
+		err := sendToMaster(packet.Id_PlayerRemove, p)
 		if err != nil {
-			log.Println("Error sending player remove packet:", err)
+			logger.Error("Error sending player remove packet", "error", err)
 		}
 
-		log.Printf("Player %s (%s) disconnected from %s\n", p.Name, p.UUID, p.PlayingOn)
+		logger.Info("Player disconnected", "server", p.PlayingOn)
 	}()
 }
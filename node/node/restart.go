@@ -0,0 +1,415 @@
+package node
+
+// Zero-downtime restarts: the running process hands its proxy listener to a
+// freshly started copy of the binary (fd inheritance), waits until the new
+// process reports that it took over, then stops accepting and keeps serving
+// existing sessions until they drain or maxDrainTime passes. The master
+// link moves to the new process, which takes over the players of the old
+// one and reports their leaves while it drains.
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	prtcl "wired.rip/wiredutils/protocol"
+	"wired.rip/wiredutils/utils"
+)
+
+const (
+	listenFdEnv  = "WIRED_LISTEN_FD"
+	readyFdEnv   = "WIRED_READY_FD"
+	handoffFdEnv = "WIRED_HANDOFF_FD"
+
+	handoffTimeout = 30 * time.Second
+	maxDrainTime   = 15 * time.Minute
+)
+
+var (
+	proxyListener net.Listener // set once the proxy listens, guarded by listenerMux
+	listenerMux   = &sync.Mutex{}
+	draining      atomic.Bool // only set under sessionsMux
+	restartMux    = &sync.Mutex{}
+	sessionsMux   = &sync.Mutex{}
+	sessions      int
+	drained       = make(chan struct{}) // closed once draining and no session is left
+	handoffMux    = &sync.Mutex{}
+	successor     *gob.Encoder          // leaves of our players once draining, guarded by handoffMux
+	inherited     = make(chan struct{}) // closed once the players of the previous process are known
+)
+
+func setProxyListener(listener net.Listener) {
+	listenerMux.Lock()
+	defer listenerMux.Unlock()
+
+	proxyListener = listener
+}
+
+func currentProxyListener() net.Listener {
+	listenerMux.Lock()
+	defer listenerMux.Unlock()
+
+	return proxyListener
+}
+
+// listenProxy returns the listener handed over by the previous process,
+// or a new one if this is a cold start
+func listenProxy(address string) (net.Listener, error) {
+	fdStr := os.Getenv(listenFdEnv)
+	if fdStr == "" {
+		return net.Listen("tcp", address)
+	}
+
+	os.Unsetenv(listenFdEnv)
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", listenFdEnv, err)
+	}
+
+	file := os.NewFile(uintptr(fd), "wired-listener")
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Took over proxy listener from previous process")
+	return listener, nil
+}
+
+// signalReady tells the previous process (if any) that this process
+// serves the listener now, and moves the systemd main PID over to us
+func signalReady() {
+	writePidFile()
+
+	fdStr := os.Getenv(readyFdEnv)
+	if fdStr == "" {
+		return
+	}
+
+	os.Unsetenv(readyFdEnv)
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		slog.Warn("Invalid "+readyFdEnv, "error", err)
+		return
+	}
+
+	sdNotify(fmt.Sprintf("MAINPID=%d", os.Getpid()))
+
+	file := os.NewFile(uintptr(fd), "wired-ready")
+	defer file.Close()
+
+	_, err = file.Write([]byte{1})
+	if err != nil {
+		slog.Error("Error signalling readiness to previous process", "error", err)
+	}
+}
+
+// trackSession counts a session until the returned func is called. Once
+// the process drains it refuses, the listener belongs to the new process.
+func trackSession() (func(), bool) {
+	sessionsMux.Lock()
+	defer sessionsMux.Unlock()
+
+	if draining.Load() {
+		return nil, false
+	}
+
+	sessions++
+	return endSession, true
+}
+
+func endSession() {
+	sessionsMux.Lock()
+	defer sessionsMux.Unlock()
+
+	sessions--
+	if draining.Load() && sessions == 0 {
+		close(drained)
+	}
+}
+
+func activeSessions() int {
+	sessionsMux.Lock()
+	defer sessionsMux.Unlock()
+
+	return sessions
+}
+
+// startDraining refuses new sessions and hands the players of the open ones
+// to the new process, which reports them to the master from now on
+func startDraining(handoff *os.File) {
+	handoffMux.Lock()
+	defer handoffMux.Unlock()
+
+	sessionsMux.Lock()
+	draining.Store(true)
+	if sessions == 0 {
+		close(drained)
+	}
+	sessionsMux.Unlock()
+
+	players := utils.ListPlayers()
+	for i := range players {
+		players[i].Conn = nil
+	}
+
+	successor = gob.NewEncoder(handoff)
+	err := successor.Encode(players)
+	if err != nil {
+		slog.Error("Error handing players to new process", "error", err)
+	}
+}
+
+// dropPlayer removes p from the online players and reports whether it was
+// online. Once the process drains the leave is handed to the new process
+// instead of the master, handedOff is true then.
+func dropPlayer(p prtcl.Player) (removed bool, handedOff bool) {
+	handoffMux.Lock()
+	defer handoffMux.Unlock()
+
+	if !utils.RemovePlayer(p) {
+		return false, false
+	}
+
+	if successor == nil {
+		return true, false
+	}
+
+	err := successor.Encode(p)
+	if err != nil {
+		slog.Error("Error handing player leave to new process", "error", err)
+	}
+
+	return true, true
+}
+
+// inheritPlayers takes over the players of the previous process, if any,
+// and reports their leaves to the master until that process exited
+func inheritPlayers() {
+	fdStr := os.Getenv(handoffFdEnv)
+	if fdStr == "" {
+		close(inherited)
+		return
+	}
+
+	os.Unsetenv(handoffFdEnv)
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		slog.Warn("Invalid "+handoffFdEnv, "error", err)
+		close(inherited)
+		return
+	}
+
+	// a restart of ours waits for the previous process as for a session
+	end, _ := trackSession()
+	go func() {
+		defer end()
+
+		file := os.NewFile(uintptr(fd), "wired-handoff")
+		defer file.Close()
+
+		decoder := gob.NewDecoder(file)
+		var players []prtcl.Player
+		err := decoder.Decode(&players)
+		for _, p := range players {
+			utils.AddPlayer(p)
+		}
+		close(inherited)
+
+		if err != nil {
+			slog.Error("Error taking over players from previous process", "error", err)
+			return
+		}
+
+		slog.Info("Took over players from previous process", "players", len(players))
+		for {
+			var p prtcl.Player
+			if decoder.Decode(&p) != nil {
+				break
+			}
+
+			removePlayer(p)
+		}
+
+		// sessions still open when the previous process exited ended with it
+		for _, p := range players {
+			removePlayer(p)
+		}
+	}()
+}
+
+func handleRestartSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		slog.Info("Received SIGHUP, restarting gracefully")
+		err := restartSelf()
+		if err != nil {
+			slog.Error("Graceful restart failed", "error", err)
+		}
+	}
+}
+
+func restartSelf() error {
+	restartMux.Lock()
+	defer restartMux.Unlock()
+
+	if draining.Load() {
+		return errors.New("restart already in progress")
+	}
+
+	slog.Info("Restarting")
+	self, err := os.Executable()
+	if err != nil {
+		slog.Error("Error getting executable path", "error", err)
+		return err
+	}
+
+	// under an older unit file systemd would reap the new process together
+	// with us, so fall back to replacing the process image
+	if os.Getenv("INVOCATION_ID") != "" && os.Getenv("NOTIFY_SOCKET") == "" {
+		slog.Warn("systemd unit lacks NotifyAccess=all, falling back to exec restart (reinstall the service to enable connection handoff)")
+		return syscall.Exec(self, os.Args, os.Environ())
+	}
+
+	tcpListener, ok := currentProxyListener().(*net.TCPListener)
+	if !ok {
+		return syscall.Exec(self, os.Args, os.Environ())
+	}
+
+	listenerFile, err := tcpListener.File()
+	if err != nil {
+		return err
+	}
+	defer listenerFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	handoffR, handoffW, err := os.Pipe()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+	tookOver := false
+	defer func() {
+		if !tookOver {
+			handoffW.Close()
+		}
+	}()
+
+	cmd := exec.Command(self, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{listenerFile, readyW, handoffR}
+	cmd.Env = append(os.Environ(), listenFdEnv+"=3", readyFdEnv+"=4", handoffFdEnv+"=5")
+
+	err = cmd.Start()
+	readyW.Close()
+	handoffR.Close()
+	if err != nil {
+		return err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return fmt.Errorf("new process exited before taking over: %w", err)
+		}
+	case <-time.After(handoffTimeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.New("new process did not take over in time")
+	}
+
+	// the new process is parented to us, reap it so it does not linger as a
+	// zombie if we outlive it
+	go cmd.Wait()
+
+	slog.Info("New process took over, draining sessions", "pid", cmd.Process.Pid, "sessions", activeSessions())
+	tookOver = true
+	startDraining(handoffW)
+	if listener := currentProxyListener(); listener != nil {
+		listener.Close()
+	}
+	stopMetricsServer()
+	if conn := currentMaster(); conn != nil {
+		conn.Close()
+	}
+
+	return nil
+}
+
+// drain blocks until all proxied sessions ended or maxDrainTime passed
+func drain() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	deadline := time.After(maxDrainTime)
+	for {
+		select {
+		case <-drained:
+			slog.Info("All sessions drained, exiting")
+			return
+		case <-ticker.C:
+			slog.Info("Still draining sessions", "sessions", activeSessions())
+		case <-deadline:
+			slog.Warn("Drain time exceeded, exiting", "sessions", activeSessions())
+			return
+		}
+	}
+}
+
+func writePidFile() {
+	err := os.WriteFile("node.pid", []byte(strconv.Itoa(os.Getpid())), 0644)
+	if err != nil {
+		slog.Error("Error writing pid file", "error", err)
+	}
+}
+
+// sdNotify sends a state update to systemd if we run under a unit with
+// NotifyAccess set, see sd_notify(3)
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Error("Error connecting to systemd notify socket", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		slog.Error("Error notifying systemd", "error", err)
+	}
+}
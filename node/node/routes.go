@@ -0,0 +1,288 @@
+package node
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"wired.rip/wiredutils/config"
+	"wired.rip/wiredutils/packet"
+	prtcl "wired.rip/wiredutils/protocol"
+)
+
+const (
+	routesSnapshotFile    = "routes.snapshot.json"
+	routesSnapshotVersion = 1
+	publicKeyFile         = "wired.pub"
+)
+
+// routesSnapshot is the last signed route set received from the master,
+// persisted so a cold start can serve traffic while the master is down
+type routesSnapshot struct {
+	Version    int           `json:"version"`
+	Generation uint64        `json:"generation"`
+	Routes     []prtcl.Route `json:"routes"`
+	Signature  []byte        `json:"signature"`
+}
+
+// routeTable is an immutable set of routes, replaced as a whole so
+// connections look up routes without locking
+type routeTable struct {
+	generation uint64
+	routes     []prtcl.Route // sorted by packet.SortRoutes
+	byDomain   map[string]prtcl.Route
+}
+
+var (
+	servedRoutes = atomic.Pointer[routeTable]{}
+
+	// serializes writers, readers only load routes
+	routesWriteMux = &sync.Mutex{}
+)
+
+func getRoute(proxyDomain string) (prtcl.Route, bool) {
+	table := servedRoutes.Load()
+	if table == nil {
+		return prtcl.Route{}, false
+	}
+
+	route, ok := table.byDomain[proxyDomain]
+	return route, ok
+}
+
+func routesGeneration() uint64 {
+	table := servedRoutes.Load()
+	if table == nil {
+		return 0
+	}
+
+	return table.generation
+}
+
+func setRoutes(list []prtcl.Route, generation uint64) {
+	byDomain := make(map[string]prtcl.Route, len(list))
+	for _, route := range list {
+		byDomain[route.ProxyDomain] = route
+	}
+
+	servedRoutes.Store(&routeTable{
+		generation: generation,
+		routes:     list,
+		byDomain:   byDomain,
+	})
+}
+
+func verifyRoutes(routes packet.Routes, pub *rsa.PublicKey) error {
+	if pub == nil {
+		return errors.New("no master public key available")
+	}
+
+	if len(routes.Signature) == 0 {
+		return errors.New("routes are not signed")
+	}
+
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, routes.Digest(), routes.Signature)
+}
+
+// applyRoutes installs a routes packet from the master if it is signed
+// and not older than the routes we already serve. A resync replaces the
+// routes even if it is older, the generation of the master went back.
+func applyRoutes(full packet.Routes) error {
+	err := verifyRoutes(full, wiredPub)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	routesWriteMux.Lock()
+	defer routesWriteMux.Unlock()
+
+	current := routesGeneration()
+	if full.Generation < current {
+		if !full.Resync {
+			return fmt.Errorf("stale generation %d, serving %d", full.Generation, current)
+		}
+
+		slog.Warn("Master routes generation went back, replacing served routes", "generation", full.Generation, "served", current)
+	}
+
+	setRoutes(full.Routes, full.Generation)
+	saveRoutesSnapshot(full)
+	return nil
+}
+
+// errRoutesGap is returned for deltas that do not start at the served
+// generation, the node has to ask for all routes
+var errRoutesGap = errors.New("routes delta does not follow the served generation")
+
+// applyRoutesDelta changes the served routes by a delta. The signature
+// covers the resulting routes, so a delta that does not apply cleanly is
+// rejected as a whole.
+func applyRoutesDelta(delta packet.RoutesDelta) error {
+	routesWriteMux.Lock()
+	defer routesWriteMux.Unlock()
+
+	table := servedRoutes.Load()
+	if table == nil {
+		table = &routeTable{}
+	}
+
+	// already covered by a full routes packet
+	if delta.Generation == table.generation {
+		return nil
+	}
+
+	// the master is behind the node, only all of its routes tell what
+	// the node should serve now
+	if delta.Generation < table.generation {
+		return fmt.Errorf("%w: delta to %d, serving %d", errRoutesGap, delta.Generation, table.generation)
+	}
+
+	if delta.From != table.generation {
+		return fmt.Errorf("%w: delta from %d, serving %d", errRoutesGap, delta.From, table.generation)
+	}
+
+	byId := make(map[string]prtcl.Route, len(table.routes)+len(delta.Upsert))
+	for _, route := range table.routes {
+		byId[route.RouteId] = route
+	}
+
+	for _, routeId := range delta.Remove {
+		delete(byId, routeId)
+	}
+
+	for _, route := range delta.Upsert {
+		byId[route.RouteId] = route
+	}
+
+	list := make([]prtcl.Route, 0, len(byId))
+	for _, route := range byId {
+		list = append(list, route)
+	}
+	packet.SortRoutes(list)
+
+	full := packet.Routes{
+		Routes:     list,
+		Generation: delta.Generation,
+		Signature:  delta.Signature,
+	}
+
+	err := verifyRoutes(full, wiredPub)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	setRoutes(full.Routes, full.Generation)
+	saveRoutesSnapshot(full)
+	return nil
+}
+
+// loadRoutesSnapshot restores the routes served before the last shutdown.
+// Nodes without a snapshot fall back to the routes older versions kept in
+// config.json.
+func loadRoutesSnapshot() {
+	pub, err := loadCachedPublicKey()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("Error loading cached master public key", "error", err)
+	}
+
+	data, err := os.ReadFile(routesSnapshotFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Error reading routes snapshot", "error", err)
+		}
+
+		legacy := config.GetRoutes()
+		if len(legacy) > 0 {
+			slog.Info("Loaded routes from config.json", "routes", len(legacy))
+			setRoutes(legacy, 0)
+		}
+
+		return
+	}
+
+	var snapshot routesSnapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		slog.Error("Error decoding routes snapshot", "error", err)
+		return
+	}
+
+	if snapshot.Version != routesSnapshotVersion {
+		slog.Warn("Ignoring routes snapshot with unsupported version", "version", snapshot.Version)
+		return
+	}
+
+	full := packet.Routes{
+		Routes:     snapshot.Routes,
+		Generation: snapshot.Generation,
+		Signature:  snapshot.Signature,
+	}
+
+	err = verifyRoutes(full, pub)
+	if err != nil {
+		slog.Warn("Ignoring routes snapshot", "error", err)
+		return
+	}
+
+	setRoutes(full.Routes, full.Generation)
+	slog.Info("Loaded routes from snapshot", "routes", len(full.Routes), "generation", full.Generation)
+}
+
+func saveRoutesSnapshot(routes packet.Routes) {
+	data, err := json.MarshalIndent(routesSnapshot{
+		Version:    routesSnapshotVersion,
+		Generation: routes.Generation,
+		Routes:     routes.Routes,
+		Signature:  routes.Signature,
+	}, "", "    ")
+	if err != nil {
+		slog.Error("Error encoding routes snapshot", "error", err)
+		return
+	}
+
+	err = writeFileAtomic(routesSnapshotFile, data)
+	if err != nil {
+		slog.Error("Error writing routes snapshot", "error", err)
+	}
+}
+
+func loadCachedPublicKey() (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePEMToPublicKey(string(data))
+}
+
+func cachePublicKey(pub *rsa.PublicKey) {
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(pub),
+	})
+
+	err := writeFileAtomic(publicKeyFile, data)
+	if err != nil {
+		slog.Error("Error caching master public key", "error", err)
+	}
+}
+
+// writeFileAtomic replaces path with data without ever exposing
+// a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
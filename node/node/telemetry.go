@@ -0,0 +1,253 @@
+package node
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"wired.rip/wiredutils/packet"
+	prtcl "wired.rip/wiredutils/protocol"
+)
+
+const telemetryInterval = 30 * time.Second
+
+var processStart = time.Now()
+
+// telemetrySampler reads the host from /proc. CPU and network are counters,
+// each report covers the time since the previous sample.
+type telemetrySampler struct {
+	at        time.Time
+	cpuBusy   uint64
+	cpuTotal  uint64
+	rxBytes   uint64
+	txBytes   uint64
+	hasCounts bool
+}
+
+func newTelemetrySampler() *telemetrySampler {
+	s := &telemetrySampler{}
+	s.sample()
+	return s
+}
+
+// sample reports the host, on systems without /proc only the process
+func (s *telemetrySampler) sample() packet.Telemetry {
+	now := time.Now()
+	report := packet.Telemetry{
+		Cores:         runtime.NumCPU(),
+		Goroutines:    runtime.NumGoroutine(),
+		ProcessUptime: int64(now.Sub(processStart).Seconds()),
+	}
+
+	busy, total, cpuOk := readCPU()
+	rx, tx, netOk := readNetDev()
+	elapsed := now.Sub(s.at).Seconds()
+
+	if s.hasCounts && elapsed > 0 {
+		if cpuOk && total > s.cpuTotal && busy >= s.cpuBusy {
+			report.CPU = float64(busy-s.cpuBusy) / float64(total-s.cpuTotal) * 100
+		}
+
+		// counters restart when an interface is recreated
+		if netOk && rx >= s.rxBytes && tx >= s.txBytes {
+			report.RxRate = float64(rx-s.rxBytes) / elapsed
+			report.TxRate = float64(tx-s.txBytes) / elapsed
+		}
+	}
+
+	s.at, s.cpuBusy, s.cpuTotal, s.rxBytes, s.txBytes = now, busy, total, rx, tx
+	s.hasCounts = cpuOk && netOk
+
+	report.MemTotal, report.MemAvailable = readMemory()
+	report.Load1, report.Load5, report.Load15 = readLoad()
+	report.OpenFiles, report.MaxOpenFiles = readOpenFiles()
+	report.Uptime = readUptime()
+
+	return report
+}
+
+// readCPU returns the busy and total jiffies of all cores
+func readCPU() (uint64, uint64, bool) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0, false
+	}
+
+	line, _, _ := strings.Cut(string(data), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, false
+	}
+
+	var busy, total uint64
+	for i, field := range fields[1:] {
+		// guest time is already part of user time
+		if i >= 8 {
+			break
+		}
+
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+
+		total += value
+		// idle and iowait
+		if i != 3 && i != 4 {
+			busy += value
+		}
+	}
+
+	return busy, total, true
+}
+
+// readNetDev sums the bytes received and sent by all interfaces but loopback
+func readNetDev() (uint64, uint64, bool) {
+	file, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer file.Close()
+
+	var rx, tx uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(name) == "lo" {
+			continue
+		}
+
+		fields := strings.Fields(counters)
+		if len(fields) < 9 {
+			continue
+		}
+
+		received, _ := strconv.ParseUint(fields[0], 10, 64)
+		sent, _ := strconv.ParseUint(fields[8], 10, 64)
+		rx += received
+		tx += sent
+	}
+
+	return rx, tx, scanner.Err() == nil
+}
+
+// readMemory returns the total and available memory in bytes
+func readMemory() (uint64, uint64) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0
+	}
+	defer file.Close()
+
+	var total, available uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb * 1024
+		case "MemAvailable:":
+			available = kb * 1024
+		}
+	}
+
+	return total, available
+}
+
+func readLoad() (float64, float64, float64) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, 0, 0
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return 0, 0, 0
+	}
+
+	load1, _ := strconv.ParseFloat(fields[0], 64)
+	load5, _ := strconv.ParseFloat(fields[1], 64)
+	load15, _ := strconv.ParseFloat(fields[2], 64)
+	return load1, load5, load15
+}
+
+// readOpenFiles returns the descriptors the process holds and its soft limit
+func readOpenFiles() (uint64, uint64) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, 0
+	}
+
+	open := uint64(len(entries))
+
+	data, err := os.ReadFile("/proc/self/limits")
+	if err != nil {
+		return open, 0
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			break
+		}
+
+		// "unlimited" stays zero
+		limit, _ := strconv.ParseUint(fields[0], 10, 64)
+		return open, limit
+	}
+
+	return open, 0
+}
+
+func readUptime() int64 {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+
+	uptime, _ := strconv.ParseFloat(fields[0], 64)
+	return int64(uptime)
+}
+
+// reportTelemetry sends the master a report every interval until done
+func reportTelemetry(conn *prtcl.Conn, done <-chan struct{}) {
+	sampler := newTelemetrySampler()
+
+	ticker := time.NewTicker(telemetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			// masters before telemetry ignore the packet
+			err := conn.SendPacket(packet.Id_Telemetry, sampler.sample())
+			if err != nil {
+				slog.Error("Error sending telemetry", "error", err)
+			}
+		}
+	}
+}